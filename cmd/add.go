@@ -2,9 +2,9 @@ package cmd
 
 import (
 	"fmt"
-	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"coworktree/pkg/cowgit"
 	"github.com/spf13/cobra"
@@ -17,8 +17,43 @@ var (
 	parallelCoW     bool
 	forceParallel   bool
 	parallelDepth   int
+	backendFlag     string
+	fallbackFlag    string
+	hardlinkFlags   []string
+	resetBranchFlag string
+	detachFlag      bool
+	trackFlag       bool
+	noTrackFlag     bool
+	guessRemote     bool
+	noCheckout      bool
+	carryFlag       string
+	forceFlag       bool
+	sparseFlags     []string
+	noConeFlag      bool
+	configFlags     []string
 )
 
+// addResult is add's --json result
+type addResult struct {
+	Path       string `json:"path"`
+	Branch     string `json:"branch,omitempty"`
+	Detached   bool   `json:"detached"`
+	BaseCommit string `json:"base_commit,omitempty"`
+	// Backend is the clone backend used, or "git" for a regular git worktree
+	Backend      string                   `json:"backend"`
+	NoCheckout   bool                     `json:"no_checkout"`
+	ChangedPaths []string                 `json:"changed_paths,omitempty"`
+	Stages       []cowgit.StageTiming     `json:"stages"`
+	Elapsed      time.Duration            `json:"elapsed_ns"`
+	Rewrite      *cowgit.PathRewriteStats `json:"rewrite,omitempty"`
+}
+
+// addPlan is add's --json result with --dry-run
+type addPlan struct {
+	*cowgit.Plan
+	DryRun bool `json:"dry_run"`
+}
+
 // addCmd represents the add command
 var addCmd = &cobra.Command{
 	Use:   "add <path> [<commit-ish>]",
@@ -30,7 +65,43 @@ This command will:
 2. Create a new git branch in the worktree
 3. Register the worktree with git
 
-If CoW is not supported, it will fall back to traditional git worktree.
+Use --backend to choose how files are materialized. The default, auto, uses
+clonefile on APFS or reflinks on Linux. On Linux, --backend=overlay mounts an
+overlay with the current checkout as the read-only lower layer instead of
+cloning files; this needs root or fuse-overlayfs. --backend=hardlink hardlinks
+files under read-mostly dependency directories (--hardlink, default
+node_modules and virtualenvs) and reflinks or copies the rest; run
+'coworktree detach' on a subtree before editing files in it.
+
+If CoW is not supported, it will fall back to traditional git worktree, which
+leaves out untracked and ignored files such as node_modules or virtualenvs.
+Use --fallback=copy to make a full byte-for-byte copy of the checkout instead;
+--backend=copy always copies.
+
+Branch options follow git worktree add: -b creates a branch, -B creates or
+resets one, --detach (or a commit-ish that is not a local branch) detaches
+HEAD, --track/--no-track control the upstream of a new branch, --guess-remote
+starts the branch named after <path> from a matching remote-tracking branch,
+and --no-checkout registers the worktree without populating it.
+
+Uncommitted changes in the current checkout are cloned along with it. Use
+--carry=none to reset tracked files in the new worktree to HEAD and drop
+untracked ones (ignored build artifacts are kept), or --carry=move to keep
+them only in the new worktree by stashing them out of the current checkout.
+
+The target path must not exist or be an empty directory, and may be neither
+inside the current repository nor contain it. --force replaces an existing
+file, directory or worktree of this repository at the path; a checkout of
+another repository is never replaced.
+
+A sparse checkout stays sparse: the new worktree gets the current checkout's
+sparse-checkout patterns and settings. --sparse gives it a set of its own
+instead, as with git sparse-checkout set, enabling extensions.worktreeConfig
+so the set applies to that worktree only.
+
+--config key=value sets a git config value, such as user.email or
+core.hooksPath, for the new worktree only. It is written to the worktree's
+config.worktree, and extensions.worktreeConfig is enabled if it isn't already.
 
 Performance note: By default, absolute path rewriting is disabled for speed.
 Use --rewrite-paths if you need build artifacts (venv, node_modules) to work correctly.`,
@@ -39,10 +110,57 @@ Use --rewrite-paths if you need build artifacts (venv, node_modules) to work cor
 }
 
 func addWorktree(cmd *cobra.Command, args []string) error {
-	if err := checkGitRepo(); err != nil {
+	repo, err := currentRepository()
+	if err != nil {
+		return err
+	}
+
+	if backendFlag != cowgit.BackendAuto {
+		if _, err := cowgit.LookupBackend(backendFlag); err != nil {
+			return err
+		}
+	}
+
+	if err := cowgit.ValidateFallback(fallbackFlag); err != nil {
+		return err
+	}
+
+	if err := cowgit.ValidateCarry(carryFlag); err != nil {
+		return err
+	}
+	configOverrides, err := parseConfigFlags(configFlags)
+	if err != nil {
 		return err
 	}
 
+	// --hardlink implies the hardlink backend
+	if len(hardlinkFlags) > 0 {
+		if backendFlag == cowgit.BackendAuto {
+			backendFlag = cowgit.BackendHardlink
+		} else if backendFlag != cowgit.BackendHardlink {
+			return usageErrorf("--hardlink requires --backend=%s", cowgit.BackendHardlink)
+		}
+		for _, pattern := range hardlinkFlags {
+			if err := cowgit.ValidateGlob(pattern); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Branch modes are exclusive, as in git worktree add
+	modes := 0
+	for _, set := range []bool{branchFlag != "", resetBranchFlag != "", detachFlag} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return usageErrorf("options -b, -B and --detach cannot be used together")
+	}
+	if (trackFlag || noTrackFlag) && branchFlag == "" && resetBranchFlag == "" {
+		return usageErrorf("--[no-]track can only be used if a new branch is created")
+	}
+
 	// Parse arguments like git worktree add
 	worktreePath := args[0]
 	
@@ -62,6 +180,9 @@ func addWorktree(cmd *cobra.Command, args []string) error {
 	
 	// Use branch flag if provided, otherwise auto-generate from path
 	branchName := branchFlag
+	if resetBranchFlag != "" {
+		branchName = resetBranchFlag
+	}
 	if branchName == "" {
 		branchName = filepath.Base(worktreePath)
 	}
@@ -72,75 +193,232 @@ func addWorktree(cmd *cobra.Command, args []string) error {
 		commitish = args[1]
 	}
 
-	// Get current working directory as repo path and canonicalize it
-	repoPath, err := os.Getwd()
-	if err != nil {
-		return fmt.Errorf("failed to get current directory: %w", err)
+	// Without -b/-B, a commit-ish that is a local branch (or, with no commit-ish,
+	// an existing branch named after the path) is checked out as is, and any
+	// other commit-ish gives a detached HEAD, as in git worktree add
+	detach := detachFlag
+	existingBranch := false
+	if branchFlag == "" && resetBranchFlag == "" && !detachFlag {
+		if commitish != "" && isLocalBranch(commitish) {
+			branchName = commitish
+			existingBranch = true
+		} else if commitish != "" {
+			detach = true
+		} else if isLocalBranch(branchName) {
+			existingBranch = true
+		}
 	}
-	
-	// Canonicalize the repo path to resolve any symlinks
-	canonicalRepoPath, err := filepath.EvalSymlinks(repoPath)
-	if err != nil {
-		return fmt.Errorf("failed to canonicalize repo path %s: %w", repoPath, err)
+
+	// --guess-remote only applies to the branch named after the path, and defaults to worktree.guessRemote
+	if !cmd.Flags().Changed("guess-remote") {
+		guessRemote = gitConfigBool("worktree.guessRemote")
 	}
-	repoPath = canonicalRepoPath
+	guess := guessRemote && commitish == "" && branchFlag == "" && resetBranchFlag == "" && !detach && !existingBranch
+
+	// The source is the checkout we're in, which may be a linked worktree;
+	// the new worktree is registered in the shared git directory
+	repoPath := repo.Root
 
-	if verbose {
-		fmt.Printf("Creating worktree: %s\n", worktreePath)
-		fmt.Printf("Branch: %s\n", branchName)
-		fmt.Printf("CoW enabled: %t\n", !noCow)
+	logger.Info("creating worktree", "path", worktreePath, "branch", branchName, "detach", detach,
+		"cow", !noCow, "backend", backendFlag, "fallback", fallbackFlag, "carry", carryFlag)
+
+	// Create worktree instance (invert the logic - disable rewrite by default)
+	worktree := cowgit.NewWorktreeWithAllOptions(repoPath, worktreePath, branchName, !enableRewrite, parallelCoW, forceParallel, parallelDepth)
+	worktree.Backend = backendFlag
+	worktree.Fallback = fallbackFlag
+	worktree.HardlinkPaths = hardlinkFlags
+	if !existingBranch {
+		worktree.FromCommit = commitish
+	}
+	worktree.ExistingBranch = existingBranch
+	worktree.ResetBranch = resetBranchFlag != ""
+	worktree.Detach = detach
+	worktree.Track = trackFlag
+	worktree.NoTrack = noTrackFlag
+	worktree.GuessRemote = guess
+	worktree.NoCheckout = noCheckout
+	worktree.Carry = carryFlag
+	worktree.Force = forceFlag
+	if noConeFlag && !cmd.Flags().Changed("sparse") {
+		return usageErrorf("--no-cone requires --sparse")
+	}
+	if cmd.Flags().Changed("sparse") {
+		worktree.Sparse = sparseFlags
+		worktree.SparseNoCone = noConeFlag
 	}
+	worktree.Config = configOverrides
+	worktree.LockTimeout = lockTimeout
+	worktree.Logger = logger
 
 	if dryRun {
-		fmt.Printf("Would create worktree at: %s\n", worktreePath)
-		fmt.Printf("Would create branch: %s\n", branchName)
+		var plan *cowgit.Plan
+		var err error
+		if noCow {
+			plan, err = worktree.PlanRegular()
+		} else {
+			plan, err = worktree.Plan()
+		}
+		if err != nil {
+			return err
+		}
+		if jsonOutput {
+			return printResult("add", addPlan{Plan: plan, DryRun: true})
+		}
+		printPlan(plan, existingBranch)
 		return nil
 	}
 
-	// Create worktree instance (invert the logic - disable rewrite by default)
-	worktree := cowgit.NewWorktreeWithAllOptions(repoPath, worktreePath, branchName, !enableRewrite, parallelCoW, forceParallel, parallelDepth)
+	// Refuse unsafe targets before anything is created, whichever path is taken
+	if err := worktree.Preflight(); err != nil {
+		return err
+	}
 
 	// Create progress tracker for TTY output (shows in interactive mode or when forced)
 	progress := cowgit.NewProgressTracker(forceProgress)
+	if jsonOutput {
+		progress.SetQuiet(true)
+	}
+	start := time.Now()
 
 	// Try CoW first, fall back to regular if not supported or disabled
-	isCoW := false
+	created := false
 	if !noCow {
-		if supported, err := cowgit.IsCoWSupported(repoPath); err == nil && supported {
-			if err := worktree.CreateCoWWorktreeWithProgress(progress); err == nil {
-				isCoW = true
+		if _, err := cowgit.SelectBackendWithFallback(backendFlag, fallbackFlag, repoPath); err != nil {
+			// Only automatic selection may quietly settle for git worktree add
+			if backendFlag != cowgit.BackendAuto {
+				return fmt.Errorf("failed to create worktree: %w", err)
+			}
+			logger.Info("backend not supported, using git worktree add", "backend", backendFlag, "reason", err)
+		} else {
+			// A failed clone falls back to git worktree add inside, when that's allowed
+			if err := worktree.CreateCoWWorktreeWithProgress(progress); err != nil {
+				return fmt.Errorf("failed to create worktree: %w", err)
 			}
+			created = true
+		}
+	}
+
+	// Fall back to regular worktree if CoW was unsupported or disabled
+	if !created {
+		if err := worktree.CreateRegularWorktree(); err != nil {
+			return fmt.Errorf("failed to create regular worktree: %w", err)
 		}
 	}
+	// The CoW path may itself have ended up using git worktree add
+	isCoW := worktree.Backend != cowgit.FallbackGit
 
-	// Fall back to regular worktree if CoW failed or was disabled
-	if !isCoW {
-		// Build git worktree add command
-		gitArgs := []string{"worktree", "add"}
-		if branchFlag != "" {
-			gitArgs = append(gitArgs, "-b", branchName)
+	if jsonOutput {
+		result := addResult{
+			Path:         worktreePath,
+			Detached:     detach,
+			BaseCommit:   worktree.BaseCommit,
+			Backend:      worktree.Backend,
+			NoCheckout:   noCheckout,
+			ChangedPaths: worktree.ChangedPaths,
+			Stages:       progress.Stages(),
+			Elapsed:      time.Since(start),
+			Rewrite:      worktree.RewriteStats,
 		}
-		gitArgs = append(gitArgs, worktreePath)
-		if commitish != "" {
-			gitArgs = append(gitArgs, commitish)
+		if !detach {
+			result.Branch = branchName
 		}
-		
-		cmd := exec.Command("git", gitArgs...)
-		cmd.Dir = repoPath
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to create regular worktree: %w", err)
+		if result.Stages == nil {
+			result.Stages = []cowgit.StageTiming{}
 		}
+		return printResult("add", result)
 	}
 
-	if isCoW {
+	if isCoW && noCheckout {
+		fmt.Printf("Created worktree without checkout at: %s\n", worktreePath)
+	} else if isCoW && worktree.Backend == cowgit.BackendCopy {
+		fmt.Printf("Created copied worktree at: %s\n", worktreePath)
+	} else if isCoW {
 		fmt.Printf("Created CoW worktree at: %s\n", worktreePath)
 	} else {
 		fmt.Printf("Created regular worktree at: %s\n", worktreePath)
 	}
 
+	if isCoW && (commitish != "" || existingBranch) && !noCheckout {
+		target := commitish
+		if existingBranch {
+			target = branchName
+		}
+		fmt.Printf("Checked out %s: %d tracked paths changed\n", target, len(worktree.ChangedPaths))
+		if verbosity > 0 {
+			for _, path := range worktree.ChangedPaths {
+				fmt.Printf("  %s\n", path)
+			}
+		}
+	}
+
 	return nil
 }
 
+// printPlan describes what add would do
+func printPlan(plan *cowgit.Plan, existingBranch bool) {
+	if plan.Replaces {
+		fmt.Printf("Would replace %s with a new worktree\n", plan.Path)
+	} else {
+		fmt.Printf("Would create worktree at: %s\n", plan.Path)
+	}
+	switch {
+	case plan.Detached:
+		fmt.Printf("Would detach HEAD at %s\n", plan.BaseCommit)
+	case existingBranch:
+		fmt.Printf("Would check out branch: %s\n", plan.Branch)
+	}
+	for _, ref := range plan.Refs {
+		from := ""
+		if ref.StartPoint != "" {
+			from = fmt.Sprintf(" (from %s)", ref.StartPoint)
+		}
+		fmt.Printf("Would %s branch: %s at %s%s\n", ref.Action, strings.TrimPrefix(ref.Name, "refs/heads/"), ref.Commit, from)
+	}
+
+	switch plan.Backend {
+	case "":
+		fmt.Println("Would register the worktree without checking anything out")
+	case cowgit.FallbackGit:
+		if plan.FallbackReason != "" {
+			fmt.Printf("Would use git worktree add: %s\n", plan.FallbackReason)
+		} else {
+			fmt.Println("Would use git worktree add")
+		}
+		if !noCheckout {
+			fmt.Printf("Would check out %d tracked files in %d directories (%s)\n", plan.Files, plan.Dirs, formatBytes(plan.Bytes))
+		}
+	default:
+		fmt.Printf("Would clone %d files in %d directories (%s) with the %s backend\n", plan.Files, plan.Dirs, formatBytes(plan.Bytes), plan.Backend)
+		if plan.Hardlinked > 0 {
+			fmt.Printf("Would hardlink %d of them\n", plan.Hardlinked)
+		}
+	}
+	if len(plan.Excluded) > 0 {
+		fmt.Printf("Would leave out: %s\n", strings.Join(plan.Excluded, ", "))
+	}
+	if len(plan.ChangedPaths) > 0 {
+		fmt.Printf("Would update %d tracked paths to %s\n", len(plan.ChangedPaths), plan.BaseCommit)
+		if verbosity > 0 {
+			for _, path := range plan.ChangedPaths {
+				fmt.Printf("  %s\n", path)
+			}
+		}
+	}
+	if plan.RewriteStats != nil {
+		fmt.Printf("Would rewrite absolute paths in %d of %d ignored files\n", len(plan.Rewrites), plan.RewriteStats.GitignoreMatches)
+		for _, rewrite := range plan.Rewrites {
+			matches := "matches"
+			if rewrite.Matches == 1 {
+				matches = "match"
+			}
+			fmt.Printf("  %s (%d %s)\n", rewrite.Path, rewrite.Matches, matches)
+		}
+	}
+	fmt.Println("Would write:")
+	for _, file := range plan.MetadataFiles {
+		fmt.Printf("  %s\n", file)
+	}
+}
 
 // canonicalizePath resolves symlinks in a path, handling the case where the final component doesn't exist yet
 func canonicalizePath(path string) (string, error) {
@@ -167,9 +445,39 @@ func init() {
 	rootCmd.AddCommand(addCmd)
 
 	addCmd.Flags().StringVarP(&branchFlag, "branch", "b", "", "create a new branch")
+	addCmd.Flags().StringVarP(&resetBranchFlag, "force-branch", "B", "", "create a new branch, or reset it to <commit-ish> if it exists")
+	addCmd.Flags().BoolVar(&detachFlag, "detach", false, "detach HEAD at <commit-ish> instead of checking out a branch")
+	addCmd.Flags().BoolVar(&trackFlag, "track", false, "set up upstream tracking for the new branch")
+	addCmd.Flags().BoolVar(&noTrackFlag, "no-track", false, "do not set up upstream tracking for the new branch")
+	addCmd.Flags().BoolVar(&guessRemote, "guess-remote", false, "base the new branch on a remote-tracking branch named after <path> (default: worktree.guessRemote)")
+	addCmd.Flags().BoolVar(&noCheckout, "no-checkout", false, "register the worktree without populating it")
+	addCmd.Flags().BoolVarP(&forceFlag, "force", "f", false, "replace an existing file, directory or worktree at <path>")
+	addCmd.Flags().StringArrayVar(&sparseFlags, "sparse", nil, "give the worktree its own sparse-checkout set: a directory, or a pattern with --no-cone (repeatable; default: the current checkout's)")
+	addCmd.Flags().BoolVar(&noConeFlag, "no-cone", false, "treat --sparse values as sparse-checkout patterns instead of directories")
+	addCmd.Flags().StringArrayVar(&configFlags, "config", nil, "set key=value in the new worktree's own config, e.g. user.email (repeatable)")
+	addCmd.Flags().StringVar(&carryFlag, "carry", cowgit.CarryCopy, "uncommitted changes in the current checkout: copy (keep them in both), none (start clean) or move (stash them out of the current checkout)")
 	addCmd.Flags().BoolVar(&enableRewrite, "rewrite-paths", false, "enable absolute path rewriting in gitignored files (slow but fixes build artifacts)")
 	addCmd.Flags().BoolVar(&forceProgress, "progress", false, "show progress indicators even in non-interactive mode")
 	addCmd.Flags().BoolVar(&parallelCoW, "parallel-cow", false, "experimental: use parallel file-level CoW instead of atomic directory clone")
 	addCmd.Flags().BoolVar(&forceParallel, "force-parallel", false, "force parallel CoW even when atomic clone would work (for testing)")
 	addCmd.Flags().IntVar(&parallelDepth, "parallel-depth", 0, "recurse to depth N and clone each subdirectory atomically in parallel (0=disabled)")
-}
\ No newline at end of file
+	addCmd.Flags().StringVar(&backendFlag, "backend", cowgit.BackendAuto, fmt.Sprintf("how to materialize the worktree: %s or one of %s", cowgit.BackendAuto, strings.Join(cowgit.Backends(), ", ")))
+	addCmd.Flags().StringArrayVar(&hardlinkFlags, "hardlink", nil, "glob of paths to hardlink instead of clone, e.g. 'node_modules/**' (repeatable, implies --backend=hardlink)")
+	addCmd.Flags().StringVar(&fallbackFlag, "fallback", cowgit.FallbackGit, "what to do when CoW is unsupported: git (git worktree add) or copy (full copy including ignored files)")
+}
+
+// parseConfigFlags turns --config key=value flags into a config map
+func parseConfigFlags(flags []string) (map[string]string, error) {
+	if len(flags) == 0 {
+		return nil, nil
+	}
+	cfg := make(map[string]string, len(flags))
+	for _, flag := range flags {
+		key, value, ok := strings.Cut(flag, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid --config %q: want key=value", flag)
+		}
+		cfg[key] = value
+	}
+	return cfg, cowgit.ValidateConfig(cfg)
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -35,7 +36,7 @@ var benchmarkCmd = &cobra.Command{
 	Short: "Run CoW performance benchmarks",
 	Long: `Run comprehensive benchmarks to test CoW performance across different
 folder configurations with varying file counts and sizes.`,
-	Run: runBenchmark,
+	RunE: runBenchmark,
 }
 
 var (
@@ -56,14 +57,18 @@ func init() {
 	benchmarkCmd.Flags().BoolVar(&benchmarkCompare, "compare", false, "Include CoW vs traditional copy comparison")
 }
 
-func runBenchmark(cmd *cobra.Command, args []string) {
-	fmt.Println("🚀 Starting CoW Performance Benchmarks")
-	fmt.Println("=====================================")
+func runBenchmark(cmd *cobra.Command, args []string) error {
+	// With --json, progress goes to stderr and the suite is the JSON result
+	var status io.Writer = os.Stdout
+	if jsonOutput {
+		status = os.Stderr
+	}
+	fmt.Fprintln(status, "🚀 Starting CoW Performance Benchmarks")
+	fmt.Fprintln(status, "=====================================")
 
 	// Check if we're in the right directory
 	if _, err := os.Stat("pkg/cowgit"); err != nil {
-		fmt.Printf("Error: Must run from project root directory\n")
-		os.Exit(1)
+		return fmt.Errorf("must run from project root directory")
 	}
 
 	suite := BenchmarkSuite{
@@ -74,30 +79,29 @@ func runBenchmark(cmd *cobra.Command, args []string) {
 	}
 
 	// Run the main benchmark suite
-	fmt.Println("\n📊 Running CoW Performance Tests...")
+	fmt.Fprintln(status, "\n📊 Running CoW Performance Tests...")
 	results, err := runGoBenchmark("BenchmarkCoWPerformance", benchmarkCount, benchmarkTime)
 	if err != nil {
-		fmt.Printf("Error running CoW benchmarks: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("failed to run CoW benchmarks: %w", err)
 	}
 	suite.Results = append(suite.Results, results...)
 
 	// Run scaling benchmarks
-	fmt.Println("\n📈 Running Scaling Tests...")
+	fmt.Fprintln(status, "\n📈 Running Scaling Tests...")
 	scalingResults, err := runGoBenchmark("BenchmarkCoWScaling", benchmarkCount, benchmarkTime)
 	if err != nil {
-		fmt.Printf("Error running scaling benchmarks: %v\n", err)
+		fmt.Fprintf(status, "Error running scaling benchmarks: %v\n", err)
 	} else {
 		suite.Results = append(suite.Results, scalingResults...)
 	}
 
 	// Run comparison benchmarks if requested (warning: slow!)
 	if benchmarkCompare {
-		fmt.Println("\n⚖️  Running CoW vs Traditional Copy Comparison...")
-		fmt.Println("⚠️  Warning: Traditional copy is slow - this may take several minutes")
+		fmt.Fprintln(status, "\n⚖️  Running CoW vs Traditional Copy Comparison...")
+		fmt.Fprintln(status, "⚠️  Warning: Traditional copy is slow - this may take several minutes")
 		comparisonResults, err := runGoBenchmark("BenchmarkCoWVsTraditionalCopy", benchmarkCount, benchmarkTime)
 		if err != nil {
-			fmt.Printf("Error running comparison benchmarks: %v\n", err)
+			fmt.Fprintf(status, "Error running comparison benchmarks: %v\n", err)
 		} else {
 			suite.Results = append(suite.Results, comparisonResults...)
 			calculateSpeedups(&suite)
@@ -105,16 +109,22 @@ func runBenchmark(cmd *cobra.Command, args []string) {
 	}
 
 	// Print summary
-	printBenchmarkSummary(suite)
+	if !jsonOutput {
+		printBenchmarkSummary(suite)
+	}
 
 	// Save results if output file specified
 	if benchmarkOutput != "" {
 		if err := saveBenchmarkResults(suite, benchmarkOutput); err != nil {
-			fmt.Printf("Error saving results: %v\n", err)
-			os.Exit(1)
+			return fmt.Errorf("failed to save results: %w", err)
 		}
-		fmt.Printf("\n💾 Results saved to: %s\n", benchmarkOutput)
+		fmt.Fprintf(status, "\n💾 Results saved to: %s\n", benchmarkOutput)
+	}
+
+	if jsonOutput {
+		return printResult("benchmark", suite)
 	}
+	return nil
 }
 
 func runGoBenchmark(benchmarkName string, count int, timeLimit string) ([]BenchmarkResult, error) {
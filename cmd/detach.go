@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"coworktree/pkg/cowgit"
+	"github.com/spf13/cobra"
+)
+
+// detachResult is detach's --json result
+type detachResult struct {
+	Path string `json:"path"`
+	// Count is the number of hardlinked files detached, or that would be with --dry-run
+	Count int `json:"count"`
+	// Paths lists the files that would be detached with --dry-run
+	Paths  []string `json:"paths,omitempty"`
+	DryRun bool     `json:"dry_run,omitempty"`
+}
+
+// detachCmd represents the detach command
+var detachCmd = &cobra.Command{
+	Use:   "detach <path>",
+	Short: "Replace hardlinked files with independent copies",
+	Long: `Replace every hardlinked file under a path with an independent copy.
+
+Worktrees created with --backend=hardlink share the inodes of files under the
+hardlinked paths with the main checkout, so editing such a file in place also
+changes it everywhere else. Run detach on a subtree (for example a single
+package in node_modules) before editing files in it.`,
+	Args: cobra.ExactArgs(1),
+	RunE: detachHardlinks,
+}
+
+func detachHardlinks(cmd *cobra.Command, args []string) error {
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to resolve absolute path for %s: %w", args[0], err)
+	}
+
+	if dryRun {
+		paths, err := cowgit.FindHardlinks(path)
+		if err != nil {
+			return fmt.Errorf("failed to find hardlinks: %w", err)
+		}
+		if jsonOutput {
+			return printResult("detach", detachResult{Path: path, Count: len(paths), Paths: paths, DryRun: true})
+		}
+		for _, p := range paths {
+			fmt.Printf("Would detach: %s\n", p)
+		}
+		fmt.Printf("Would detach %d hardlinked files under %s\n", len(paths), path)
+		return nil
+	}
+
+	detached, err := cowgit.DetachHardlinks(path)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		return printResult("detach", detachResult{Path: path, Count: detached})
+	}
+	fmt.Printf("Detached %d hardlinked files under %s\n", detached, path)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(detachCmd)
+}
@@ -1,32 +1,267 @@
 package cmd
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"os"
-	"os/exec"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
 
+	"coworktree/pkg/cowgit"
 	"github.com/spf13/cobra"
 )
 
+var (
+	listPorcelain bool
+	listNulFlag   bool
+	listFormat    string
+	listSize      bool
+)
+
 // listCmd represents the list command
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List all worktrees",
-	Long:  `List all worktrees. This forwards directly to 'git worktree list'.`,
-	RunE:  listWorktrees,
+	Long: `List all worktrees with their branch, HEAD, the backend coworktree created
+them with (git for ones git worktree add created), when they were created,
+and their status: changed and untracked paths, commits ahead of and behind
+the upstream, and whether they are locked or prunable. A worktree's own config
+settings (from add --config) are listed below it.
+
+--size also shows the disk space each linked worktree uses on its own. It walks
+every file of every worktree, so it is off by default; --json always includes
+it. The size is an estimate: files hardlinked from elsewhere and blocks a
+reflink clone still shares with the main checkout are not counted, but blocks
+a macOS clonefile clone shares are.
+
+--porcelain prints git worktree list --porcelain output with these details as
+extra attributes (backend, created, base, upstream, dirty, ahead, behind,
+size with --size, config); -z ends each line with NUL instead. The global
+--json prints an object with a worktrees array, and --format executes a Go
+template for each worktree, for example '{{.Path}} {{.Branch}} {{.Dirty}}';
+see cowgit.WorktreeInfo for the fields.`,
+	Args: cobra.NoArgs,
+	RunE: listWorktrees,
 }
 
 func listWorktrees(cmd *cobra.Command, args []string) error {
-	if err := checkGitRepo(); err != nil {
+	outputs := 0
+	for _, set := range []bool{listPorcelain, jsonOutput, listFormat != ""} {
+		if set {
+			outputs++
+		}
+	}
+	if outputs > 1 {
+		return usageErrorf("--porcelain, --json and --format are mutually exclusive")
+	}
+	if listNulFlag && !listPorcelain {
+		return usageErrorf("-z requires --porcelain")
+	}
+
+	var tmpl *template.Template
+	if listFormat != "" {
+		var err error
+		if tmpl, err = template.New("format").Parse(listFormat); err != nil {
+			return usageErrorf("invalid --format template: %w", err)
+		}
+	}
+
+	repo, err := currentRepository()
+	if err != nil {
 		return err
 	}
+	size := listSize || jsonOutput
+	infos, err := cowgit.ListWorktreesWithOptions(repo.Root, cowgit.ListOptions{Status: true, DiskUsage: size})
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case listPorcelain:
+		printPorcelain(os.Stdout, infos, listNulFlag, size)
+	case jsonOutput:
+		return printResult("list", listResult{Worktrees: infos})
+	case tmpl != nil:
+		for _, info := range infos {
+			if err := tmpl.Execute(os.Stdout, info); err != nil {
+				return fmt.Errorf("failed to execute --format template: %w", err)
+			}
+			fmt.Println()
+		}
+	default:
+		printTable(os.Stdout, infos, size)
+	}
+	return nil
+}
+
+// listResult is list's --json result
+type listResult struct {
+	Worktrees []cowgit.WorktreeInfo `json:"worktrees"`
+}
+
+// printTable prints one row per worktree, with its own config settings below
+// it, and a size column if size is set
+func printTable(out io.Writer, infos []cowgit.WorktreeInfo, size bool) {
+	var buf bytes.Buffer
+	table := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	columns := []string{"PATH", "BRANCH", "HEAD", "BACKEND", "CREATED", "STATUS"}
+	if size {
+		columns = []string{"PATH", "BRANCH", "HEAD", "BACKEND", "CREATED", "SIZE", "STATUS"}
+	}
+	fmt.Fprintln(table, strings.Join(columns, "\t"))
+	for i, info := range infos {
+		branch := info.Branch
+		switch {
+		case info.Bare:
+			branch = "(bare)"
+		case info.Detached:
+			branch = "(detached)"
+		}
+		head := info.HEAD
+		if len(head) > 7 {
+			head = head[:7]
+		}
+		backend, created, usage := "-", "-", "-"
+		if info.Backend != "" {
+			backend = info.Backend
+		}
+		if !info.Created.IsZero() {
+			created = info.Created.Local().Format("2006-01-02 15:04")
+		}
+		if i > 0 && !info.Prunable {
+			usage = formatBytes(info.DiskUsage)
+		}
+		cells := []string{info.Path, branch, head, backend, created, worktreeStatus(info)}
+		if size {
+			cells = []string{info.Path, branch, head, backend, created, usage, worktreeStatus(info)}
+		}
+		fmt.Fprintln(table, strings.Join(cells, "\t"))
+		for _, line := range configLines(info.Config) {
+			fmt.Fprintf(table, "  config: %s%s\n", line, strings.Repeat("\t", len(columns)-1))
+		}
+	}
+	table.Flush()
+
+	// Config lines have empty cells to keep the columns aligned; drop their padding
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		fmt.Fprintln(out, strings.TrimRight(line, " "))
+	}
+}
 
-	// Forward to git worktree list with all arguments
-	gitCmd := exec.Command("git", append([]string{"worktree", "list"}, args...)...)
-	gitCmd.Stdout = os.Stdout
-	gitCmd.Stderr = os.Stderr
-	return gitCmd.Run()
+// worktreeStatus summarizes a worktree's state for the table
+func worktreeStatus(info cowgit.WorktreeInfo) string {
+	var parts []string
+	if info.Prunable {
+		parts = append(parts, withReason("prunable", info.PrunableReason))
+	}
+	if info.Locked {
+		parts = append(parts, withReason("locked", info.LockReason))
+	}
+	if info.Dirty > 0 {
+		parts = append(parts, fmt.Sprintf("%d changed", info.Dirty))
+	}
+	if info.Ahead > 0 {
+		parts = append(parts, fmt.Sprintf("ahead %d", info.Ahead))
+	}
+	if info.Behind > 0 {
+		parts = append(parts, fmt.Sprintf("behind %d", info.Behind))
+	}
+	if len(parts) == 0 {
+		if info.Bare {
+			return "-"
+		}
+		return "clean"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// withReason appends a lock or prune reason in parentheses, if there is one
+func withReason(state, reason string) string {
+	if reason == "" {
+		return state
+	}
+	return fmt.Sprintf("%s (%s)", state, reason)
+}
+
+// printPorcelain prints git worktree list --porcelain output with coworktree's
+// details as extra attributes, ending lines with NUL if nul is set and
+// including each linked worktree's size if size is set
+func printPorcelain(out io.Writer, infos []cowgit.WorktreeInfo, nul, size bool) {
+	end := "\n"
+	if nul {
+		end = "\x00"
+	}
+	for i, info := range infos {
+		attr := func(format string, a ...interface{}) {
+			fmt.Fprintf(out, format+end, a...)
+		}
+		attr("worktree %s", info.Path)
+		if info.Bare {
+			attr("bare")
+		} else {
+			attr("HEAD %s", info.HEAD)
+			if info.Detached {
+				attr("detached")
+			} else {
+				attr("branch refs/heads/%s", info.Branch)
+			}
+		}
+		if info.Locked {
+			attr("%s", strings.TrimSpace("locked "+info.LockReason))
+		}
+		if info.Prunable {
+			attr("%s", strings.TrimSpace("prunable "+info.PrunableReason))
+		}
+		if info.Backend != "" {
+			attr("backend %s", info.Backend)
+		}
+		if !info.Created.IsZero() {
+			attr("created %s", info.Created.UTC().Format(time.RFC3339))
+		}
+		if info.BaseCommit != "" {
+			attr("base %s", info.BaseCommit)
+		}
+		if !info.Bare && !info.Prunable {
+			if info.Upstream != "" {
+				attr("upstream %s", info.Upstream)
+				attr("ahead %d", info.Ahead)
+				attr("behind %d", info.Behind)
+			}
+			attr("dirty %d", info.Dirty)
+			if size && i > 0 {
+				attr("size %d", info.DiskUsage)
+			}
+		}
+		for _, line := range configLines(info.Config) {
+			attr("config %s", line)
+		}
+		fmt.Fprint(out, end)
+	}
+}
+
+// configLines formats a worktree's own config settings as key=value in key order
+func configLines(cfg map[string]string) []string {
+	keys := make([]string, 0, len(cfg))
+	for key := range cfg {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	lines := make([]string, 0, len(keys))
+	for _, key := range keys {
+		lines = append(lines, key+"="+cfg[key])
+	}
+	return lines
 }
 
 func init() {
 	rootCmd.AddCommand(listCmd)
-}
\ No newline at end of file
+
+	listCmd.Flags().BoolVar(&listPorcelain, "porcelain", false, "print machine-readable output in git worktree list --porcelain format, with extra attributes")
+	listCmd.Flags().BoolVarP(&listNulFlag, "nul", "z", false, "end each --porcelain line with NUL instead of newline")
+	listCmd.Flags().BoolVar(&listSize, "size", false, "show the disk space each linked worktree uses on its own (slow on large worktrees)")
+	listCmd.Flags().StringVar(&listFormat, "format", "", "print each worktree with a Go template, e.g. '{{.Path}} {{.Branch}}'")
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"coworktree/pkg/cowgit"
+)
+
+// jsonDocument is the single document a command prints with --json
+type jsonDocument struct {
+	Command string      `json:"command"`
+	OK      bool        `json:"ok"`
+	Result  interface{} `json:"result,omitempty"`
+	Error   *jsonError  `json:"error,omitempty"`
+}
+
+// jsonError describes a failure with a code that scripts can match on
+type jsonError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// errorCodes maps the library's sentinel errors to stable --json error codes
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{cowgit.ErrNotRepository, "not_a_repository"},
+	{cowgit.ErrInterrupted, "interrupted"},
+	{cowgit.ErrLockTimeout, "lock_timeout"},
+	{cowgit.ErrTargetExists, "target_exists"},
+	{cowgit.ErrTargetInsideSource, "target_inside_source"},
+	{cowgit.ErrTargetContainsSource, "target_contains_source"},
+	{cowgit.ErrTargetOtherRepository, "target_other_repository"},
+	{cowgit.ErrBranchExists, "branch_exists"},
+	{cowgit.ErrBranchCheckedOut, "branch_checked_out"},
+	{cowgit.ErrDirtyWorktree, "dirty_worktree"},
+	{cowgit.ErrUnpushedCommits, "unpushed_commits"},
+	{cowgit.ErrBranchNotMerged, "branch_not_merged"},
+	{cowgit.ErrWorktreeLocked, "worktree_locked"},
+	{cowgit.ErrWorktreeNotFound, "worktree_not_found"},
+	{cowgit.ErrMainWorktree, "main_worktree"},
+}
+
+// usageError is an invalid flag or argument, reported with the usage error code
+type usageError struct{ error }
+
+func (e usageError) Unwrap() error { return e.error }
+
+// usageErrorf formats a usageError
+func usageErrorf(format string, a ...interface{}) error {
+	return usageError{fmt.Errorf(format, a...)}
+}
+
+// errorCode returns the --json error code for err: usage for bad flags or
+// arguments, a specific code for the library's sentinel errors, and error
+// for anything else
+func errorCode(err error) string {
+	var usage usageError
+	if errors.As(err, &usage) || !started {
+		return "usage"
+	}
+	for _, entry := range errorCodes {
+		if errors.Is(err, entry.err) {
+			return entry.code
+		}
+	}
+	return "error"
+}
+
+// printJSON writes v as indented JSON
+func printJSON(out io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// printResult prints a command's successful result as its --json document
+func printResult(command string, result interface{}) error {
+	return printJSON(os.Stdout, jsonDocument{Command: command, OK: true, Result: result})
+}
+
+// printError prints a failed command's --json document
+func printError(command string, err error) {
+	printJSON(os.Stdout, jsonDocument{Command: command, Error: &jsonError{Code: errorCode(err), Message: err.Error()}})
+}
@@ -1,32 +1,118 @@
 package cmd
 
 import (
-	"os"
-	"os/exec"
+	"errors"
+	"fmt"
 
+	"coworktree/pkg/cowgit"
 	"github.com/spf13/cobra"
 )
 
+var (
+	removeForce      bool
+	deleteBranchFlag bool
+)
+
+// removeResult is remove's --json result
+type removeResult struct {
+	Path          string `json:"path"`
+	Branch        string `json:"branch,omitempty"`
+	BranchDeleted bool   `json:"branch_deleted"`
+	// Reclaimed is the estimated disk space freed, or that would be with --dry-run
+	Reclaimed int64 `json:"reclaimed_bytes"`
+	DryRun    bool  `json:"dry_run,omitempty"`
+}
+
 // removeCmd represents the remove command
 var removeCmd = &cobra.Command{
-	Use:   "remove <worktree>",
+	Use:   "remove <path|branch>",
 	Short: "Remove a worktree",
-	Long:  `Remove a worktree. This forwards directly to 'git worktree remove'.`,
-	RunE:  removeWorktree,
+	Long: `Remove a linked worktree, given its path or the branch checked out in it.
+
+Removal is refused when the worktree has uncommitted changes or untracked
+files, commits that are not on its branch's upstream (or, without one, on any
+other branch or tag), or a lock from git worktree lock. --force removes it
+anyway. Ignored files such as build artifacts don't count.
+
+--delete-branch also deletes the branch, if it is merged into its upstream or,
+without one, into the current HEAD; with --force it is deleted regardless.
+
+Backend state is released first: an overlay worktree is unmounted and its
+upper layer deleted. The space reclaimed is an estimate: files hardlinked from
+elsewhere and blocks a reflink clone still shares with the main checkout are
+not counted, but blocks a macOS clonefile clone shares are.`,
+	Args: cobra.ExactArgs(1),
+	RunE: removeWorktree,
 }
 
 func removeWorktree(cmd *cobra.Command, args []string) error {
-	if err := checkGitRepo(); err != nil {
+	repo, err := currentRepository()
+	if err != nil {
 		return err
 	}
+	manager := &cowgit.Manager{RepoPath: repo.Root, LockTimeout: lockTimeout, Logger: logger}
+	opts := cowgit.RemoveOptions{Force: removeForce, DeleteBranch: deleteBranchFlag}
+
+	info, err := manager.FindWorktree(args[0])
+	if err != nil {
+		return err
+	}
+	worktree := cowgit.NewWorktree(repo.Root, info.Path, info.Branch)
+	worktree.LockTimeout = lockTimeout
+	worktree.Logger = logger
+
+	if dryRun {
+		if err := worktree.CheckRemove(opts); err != nil {
+			return removeError(err)
+		}
+		if jsonOutput {
+			result := removeResult{Path: info.Path, Branch: info.Branch, DryRun: true}
+			result.Reclaimed, _ = worktree.DiskUsage()
+			return printResult("remove", result)
+		}
+		fmt.Printf("Would remove worktree at: %s\n", info.Path)
+		if opts.DeleteBranch && info.Branch != "" {
+			fmt.Printf("Would delete branch: %s\n", info.Branch)
+		}
+		if usage, err := worktree.DiskUsage(); err == nil {
+			fmt.Printf("Would reclaim about %s\n", formatBytes(usage))
+		}
+		return nil
+	}
+
+	result, err := worktree.RemoveWithOptions(opts)
+	if err != nil {
+		return removeError(err)
+	}
 
-	// Forward to git worktree remove with all arguments
-	gitCmd := exec.Command("git", append([]string{"worktree", "remove"}, args...)...)
-	gitCmd.Stdout = os.Stdout
-	gitCmd.Stderr = os.Stderr
-	return gitCmd.Run()
+	if jsonOutput {
+		return printResult("remove", removeResult{Path: info.Path, Branch: result.Branch, BranchDeleted: result.BranchDeleted, Reclaimed: result.Reclaimed})
+	}
+	if result.Branch != "" {
+		fmt.Printf("Removed worktree at %s (branch %s)\n", info.Path, result.Branch)
+	} else {
+		fmt.Printf("Removed worktree at %s (detached HEAD)\n", info.Path)
+	}
+	if result.BranchDeleted {
+		fmt.Printf("Deleted branch %s\n", result.Branch)
+	}
+	fmt.Printf("Reclaimed about %s\n", formatBytes(result.Reclaimed))
+	return nil
+}
+
+// removeError points at --force when removal was refused to protect work
+func removeError(err error) error {
+	for _, refusal := range []error{cowgit.ErrDirtyWorktree, cowgit.ErrUnpushedCommits, cowgit.ErrWorktreeLocked, cowgit.ErrBranchNotMerged} {
+		if errors.Is(err, refusal) {
+			return fmt.Errorf("%w (use --force to remove it anyway)", err)
+		}
+	}
+	return err
 }
 
 func init() {
 	rootCmd.AddCommand(removeCmd)
-}
\ No newline at end of file
+
+	removeCmd.Flags().BoolVarP(&removeForce, "force", "f", false, "remove even with uncommitted or unpushed work or a lock, and delete an unmerged branch")
+	removeCmd.Flags().BoolVar(&deleteBranchFlag, "delete-branch", false, "also delete the branch checked out in the worktree, if it is merged")
+}
@@ -2,15 +2,27 @@ package cmd
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
+	"time"
 
+	"coworktree/pkg/cowgit"
 	"github.com/spf13/cobra"
 )
 
 var (
-	verbose bool
-	dryRun  bool
-	noCow   bool
+	verbosity   int
+	logFormat   string
+	dryRun      bool
+	noCow       bool
+	lockTimeout time.Duration
+	chdir       string
+	jsonOutput  bool
+	// started is set once flags and arguments have been accepted, so errors
+	// before then are reported as usage errors
+	started bool
+	// logger writes the library's logs to stderr at the level set by -v
+	logger = slog.New(slog.DiscardHandler)
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -21,31 +33,89 @@ var rootCmd = &cobra.Command{
 to create instant, fully-featured development environments.
 
 Features:
-- Instant environment setup using CoW (APFS on macOS, overlayfs on Linux)
+- Instant environment setup using CoW (APFS on macOS, reflinks or overlayfs on Linux)
 - Complete isolation with shared dependencies
 - Proper git worktree integration
 - Cross-platform support`,
 	Version: "0.1.0",
+	// main prints the error, once
+	SilenceErrors: true,
+	// Like git -C, run as if started in another directory
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Past flag and argument validation, failures are not about usage
+		started = true
+		cmd.SilenceUsage = true
+		if err := setupLogger(); err != nil {
+			return err
+		}
+		if chdir == "" {
+			return nil
+		}
+		if err := os.Chdir(chdir); err != nil {
+			return fmt.Errorf("cannot change to '%s': %w", chdir, err)
+		}
+		return nil
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
+// With --json, a failure is also printed as the command's JSON document.
 func Execute() error {
-	return rootCmd.Execute()
+	cmd, err := rootCmd.ExecuteC()
+	if err != nil && jsonOutput {
+		printError(cmd.Name(), err)
+	}
+	return err
 }
 
 func init() {
-	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "enable verbose logging")
+	rootCmd.PersistentFlags().StringVarP(&chdir, "directory", "C", "", "run as if coworktree was started in <path> instead of the current directory")
+	rootCmd.PersistentFlags().CountVarP(&verbosity, "verbose", "v", "log backend decisions and fallbacks to stderr; repeat (-vv) to also log git commands and worker pool scaling")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "log format: text or json")
 	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "show what would be done without executing")
 	rootCmd.PersistentFlags().BoolVar(&noCow, "no-cow", false, "force traditional git worktree (skip CoW)")
+	rootCmd.PersistentFlags().BoolVar(&jsonOutput, "json", false, "print a single JSON document with the result, or an error with a stable code")
+	rootCmd.PersistentFlags().DurationVar(&lockTimeout, "lock-timeout", cowgit.DefaultLockTimeout, "how long to wait for other coworktree processes to release the repository lock")
 	
 	// Add benchmark command
 	rootCmd.AddCommand(benchmarkCmd)
+
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return usageError{err}
+	})
 }
 
-// checkGitRepo verifies we're in a git repository
-func checkGitRepo() error {
-	if _, err := os.Stat(".git"); err != nil {
-		return fmt.Errorf("not in a git repository (or any parent directories)")
+// setupLogger builds the logger from -v and --log-format: warnings only by
+// default, info with -v and debug with -vv
+func setupLogger() error {
+	level := slog.LevelWarn
+	switch {
+	case verbosity >= 2:
+		level = slog.LevelDebug
+	case verbosity == 1:
+		level = slog.LevelInfo
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	switch logFormat {
+	case "text":
+		logger = slog.New(slog.NewTextHandler(os.Stderr, opts))
+	case "json":
+		logger = slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	default:
+		return usageErrorf("invalid --log-format %q: must be text or json", logFormat)
 	}
 	return nil
+}
+
+// checkGitRepo verifies we're in a git repository
+func checkGitRepo() error {
+	_, err := currentRepository()
+	return err
+}
+
+// currentRepository finds the checkout we're in the way git does: from any
+// subdirectory, from a linked worktree, or through GIT_DIR and GIT_WORK_TREE
+func currentRepository() (*cowgit.Repository, error) {
+	return cowgit.DiscoverRepository(".")
 }
\ No newline at end of file
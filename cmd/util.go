@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"fmt"
 	"os/exec"
+	"strings"
 )
 
 // runGitCommand executes a git command in the specified directory
@@ -9,4 +11,29 @@ func runGitCommand(dir string, args ...string) error {
 	cmd := exec.Command("git", args...)
 	cmd.Dir = dir
 	return cmd.Run()
-}
\ No newline at end of file
+}
+
+// isLocalBranch reports whether name is a branch in the current repository
+func isLocalBranch(name string) bool {
+	return runGitCommand(".", "show-ref", "--verify", "--quiet", "refs/heads/"+name) == nil
+}
+
+// gitConfigBool reads a boolean git config value, treating unset or invalid values as false
+func gitConfigBool(key string) bool {
+	output, err := exec.Command("git", "config", "--bool", key).Output()
+	return err == nil && strings.TrimSpace(string(output)) == "true"
+}
+
+// formatBytes formats a byte count with a binary unit, like 1.5 MiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
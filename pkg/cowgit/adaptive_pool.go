@@ -2,9 +2,12 @@ package cowgit
 
 import (
 	"bytes"
+	"context"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -23,6 +26,11 @@ type WorkerPool struct {
 	dstDirBytes []byte
 	gitignore   *GitIgnore
 	dstDir      string
+	// readOnly records the files that need rewriting in planned instead of rewriting them
+	readOnly bool
+	planned  []PlannedRewrite
+	// ctx stops the workers and Submit early when cancelled
+	ctx context.Context
 	
 	mu        sync.RWMutex
 	wg        sync.WaitGroup
@@ -42,6 +50,7 @@ type PoolController struct {
 	pool       *WorkerPool
 	done       chan struct{}
 	lastAdjust time.Time
+	logger     *slog.Logger
 }
 
 // NewWorkerPool creates a new worker pool
@@ -54,15 +63,27 @@ func NewWorkerPool(srcDir, dstDir string, gitignore *GitIgnore) *WorkerPool {
 		dstDirBytes:   []byte(dstDir),
 		gitignore:     gitignore,
 		dstDir:        dstDir,
+		ctx:           context.Background(),
 		startTime:     time.Now(),
 	}
 }
 
+// newPlanningPool creates a read-only worker pool that scans the source
+// checkout for the files a clone at dstDir would need rewritten
+func newPlanningPool(srcDir, dstDir string, gitignore *GitIgnore) *WorkerPool {
+	pool := NewWorkerPool(srcDir, dstDir, gitignore)
+	// The clone doesn't exist yet, so paths are relative to the source
+	pool.dstDir = srcDir
+	pool.readOnly = true
+	return pool
+}
+
 // NewPoolController creates a controller for the worker pool
 func NewPoolController(pool *WorkerPool) *PoolController {
 	return &PoolController{
-		pool: pool,
-		done: make(chan struct{}),
+		pool:   pool,
+		done:   make(chan struct{}),
+		logger: discardLogger,
 	}
 }
 
@@ -81,9 +102,12 @@ func (p *WorkerPool) Stop() {
 	close(p.errChan)
 }
 
-// Submit adds a file to be processed
+// Submit adds a file to be processed, dropping it once the pool's context is cancelled
 func (p *WorkerPool) Submit(filepath string) {
-	p.fileChan <- filepath
+	select {
+	case p.fileChan <- filepath:
+	case <-p.ctx.Done():
+	}
 }
 
 // Error returns the error channel
@@ -128,15 +152,15 @@ func (p *WorkerPool) RemoveWorker() bool {
 
 // PathRewriteStats contains detailed statistics about path rewriting
 type PathRewriteStats struct {
-	Workers         int32
-	ProcessedFiles  int64
-	GitignoreMatches int64
-	TextFiles       int64
-	ModifiedFiles   int64
-	SkippedBinary   int64
-	SkippedNoMatch  int64
-	QueueDepth      int
-	ElapsedTime     time.Duration
+	Workers          int32         `json:"workers"`
+	ProcessedFiles   int64         `json:"processed_files"`
+	GitignoreMatches int64         `json:"gitignore_matches"`
+	TextFiles        int64         `json:"text_files"`
+	ModifiedFiles    int64         `json:"modified_files"`
+	SkippedBinary    int64         `json:"skipped_binary"`
+	SkippedNoMatch   int64         `json:"skipped_no_match"`
+	QueueDepth       int           `json:"queue_depth"`
+	ElapsedTime      time.Duration `json:"elapsed_ns"`
 }
 
 // GetStats returns current pool statistics
@@ -184,6 +208,9 @@ func (p *WorkerPool) worker(id int, stop <-chan struct{}) {
 			
 		case <-stop:
 			return // Worker stopped
+			
+		case <-p.ctx.Done():
+			return // Rewrite cancelled
 		}
 	}
 }
@@ -203,6 +230,11 @@ func (p *WorkerPool) processFile(path string) error {
 	
 	atomic.AddInt64(&p.gitignoreMatches, 1)
 
+	// Symlinks may point back into the main checkout; only rewrite regular files
+	if info, err := os.Lstat(path); err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+
 	// Read file and check if it's text
 	content, err := os.ReadFile(path)
 	if err != nil {
@@ -217,15 +249,60 @@ func (p *WorkerPool) processFile(path string) error {
 	
 	atomic.AddInt64(&p.textFiles, 1)
 
+	if p.readOnly {
+		if matches := bytes.Count(content, p.srcDirBytes); matches > 0 {
+			atomic.AddInt64(&p.modifiedFiles, 1)
+			p.mu.Lock()
+			p.planned = append(p.planned, PlannedRewrite{Path: filepath.ToSlash(relPath), Matches: matches})
+			p.mu.Unlock()
+		}
+		return nil
+	}
+
 	// Replace srcDir with dstDir
 	if updated := bytes.ReplaceAll(content, p.srcDirBytes, p.dstDirBytes); !bytes.Equal(content, updated) {
 		atomic.AddInt64(&p.modifiedFiles, 1)
-		return os.WriteFile(path, updated, 0644)
+		return replaceFile(path, updated)
 	}
 	
 	return nil
 }
 
+// plannedRewrites returns the files a read-only pool found, sorted by path
+func (p *WorkerPool) plannedRewrites() []PlannedRewrite {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	planned := append([]PlannedRewrite(nil), p.planned...)
+	sort.Slice(planned, func(i, j int) bool { return planned[i].Path < planned[j].Path })
+	return planned
+}
+
+// replaceFile writes content to a new file renamed over path, so files the
+// worktree shares with the main checkout (hardlinks) are never changed in place
+func replaceFile(path string, content []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".coworktree-rewrite-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
 // Start begins monitoring and adjusting the worker pool
 func (c *PoolController) Start() {
 	go c.controlLoop()
@@ -279,6 +356,7 @@ func (c *PoolController) adjustWorkers(workers int32, queueLen int, processingRa
 	if queueLen > int(workers)*5 && workers < maxWorkers && processingRate > 0 {
 		c.pool.AddWorker()
 		c.lastAdjust = time.Now()
+		c.logger.Debug("added path rewrite worker", "workers", workers+1, "queue", queueLen, "rate", processingRate)
 		return
 	}
 	
@@ -286,6 +364,7 @@ func (c *PoolController) adjustWorkers(workers int32, queueLen int, processingRa
 	if queueLen == 0 && processingRate < 1 {
 		if c.pool.RemoveWorker() {
 			c.lastAdjust = time.Now()
+			c.logger.Debug("removed path rewrite worker", "workers", workers-1, "queue", queueLen, "rate", processingRate)
 		}
 		return
 	}
@@ -0,0 +1,243 @@
+package cowgit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Backend names accepted by --backend and CreateOptions.Backend
+const (
+	// BackendAuto picks the first supported backend from the automatic selection order
+	BackendAuto = "auto"
+	// BackendClonefile clones the checkout with APFS clonefile on macOS
+	BackendClonefile = "clonefile"
+	// BackendReflink clones every file with the FICLONE ioctl on Linux
+	BackendReflink = "reflink"
+	// BackendOverlay mounts an overlay with the main checkout as the lower layer
+	BackendOverlay = "overlay"
+	// BackendCopy copies every byte; it works on any filesystem but uses full disk space
+	BackendCopy = "copy"
+	// BackendHardlink hardlinks files matching HardlinkPaths and reflinks or copies the rest
+	BackendHardlink = "hardlink"
+)
+
+// Fallbacks accepted by --fallback and CreateOptions.Fallback, used when no CoW backend is supported
+const (
+	// FallbackGit creates the worktree with git worktree add, dropping untracked and ignored files
+	FallbackGit = "git"
+	// FallbackCopy creates the worktree with the copy backend, keeping untracked and ignored files
+	FallbackCopy = "copy"
+)
+
+// autoBackendOrder lists the backends BackendAuto tries, in order of preference.
+// Overlay is opt-in because it needs mount privileges.
+var autoBackendOrder = []string{BackendClonefile, BackendReflink}
+
+// CloneOptions controls how a backend materializes a worktree
+type CloneOptions struct {
+	Parallel      bool
+	ForceParallel bool
+	ParallelDepth int
+	Progress      *ProgressTracker
+	// HardlinkPaths are the globs the hardlink backend links instead of cloning
+	HardlinkPaths []string
+	// Exclude lists globs, relative to the source, that are never cloned.
+	// nil means DefaultCloneExclude; use an empty slice to clone everything.
+	Exclude []string
+	// Logger receives worker pool scaling decisions; nil discards them
+	Logger *slog.Logger
+	// Context stops the clone early when cancelled, leaving a partial dst
+	// for the caller to delete; nil never cancels
+	Context context.Context
+}
+
+// DefaultCloneExclude keeps the main checkout's git directory out of worktree clones;
+// registration replaces it with a .git file, so cloning it would only be wasted work
+var DefaultCloneExclude = []string{".git"}
+
+// excludes returns the exclude set with the default applied
+func (o CloneOptions) excludes() []string {
+	if o.Exclude == nil {
+		return DefaultCloneExclude
+	}
+	return o.Exclude
+}
+
+// context returns the clone's context, defaulting to one that is never cancelled
+func (o CloneOptions) context() context.Context {
+	if o.Context == nil {
+		return context.Background()
+	}
+	return o.Context
+}
+
+// CloneBackend materializes a copy of a checkout at a new path
+type CloneBackend interface {
+	// Name returns the identifier used to select the backend
+	Name() string
+	// Probe reports whether the backend can clone a checkout located at path
+	Probe(path string) (bool, error)
+	// Clone materializes src at dst, which must not exist yet
+	Clone(src, dst string, opts CloneOptions) error
+	// Cleanup releases backend-specific state for dst before the directory is deleted
+	Cleanup(dst string) error
+}
+
+// displaceableBackend is implemented by backends whose worktrees are tied to
+// their path, like a mounted overlay, so --force can't just rename them aside.
+// Displace detaches the worktree at dst, keeping its contents, and returns
+// functions that reattach it once its directory is back or release it for good.
+type displaceableBackend interface {
+	Displace(dst string) (restore, release func() error, err error)
+}
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]CloneBackend)
+)
+
+// RegisterBackend makes a backend selectable by name, replacing any backend with the same name
+func RegisterBackend(backend CloneBackend) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[backend.Name()] = backend
+}
+
+// UnregisterBackend removes a backend from the registry
+func UnregisterBackend(name string) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	delete(backends, name)
+}
+
+// LookupBackend returns the registered backend with the given name
+func LookupBackend(name string) (CloneBackend, error) {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	backend, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q (available: %s)", name, strings.Join(backendNamesLocked(), ", "))
+	}
+	return backend, nil
+}
+
+// Backends returns the names of all registered backends
+func Backends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	return backendNamesLocked()
+}
+
+// backendNamesLocked returns the sorted backend names; the caller must hold backendsMu
+func backendNamesLocked() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SelectBackend resolves a backend name for a checkout at path and verifies it is usable.
+// An empty name or BackendAuto picks the first supported backend in autoBackendOrder.
+func SelectBackend(name, path string) (CloneBackend, error) {
+	return selectBackend(name, path, discardLogger)
+}
+
+// selectBackend is SelectBackend, logging each backend it probes
+func selectBackend(name, path string, logger *slog.Logger) (CloneBackend, error) {
+	if explicitBackend(name) {
+		backend, err := LookupBackend(name)
+		if err != nil {
+			return nil, err
+		}
+		supported, err := backend.Probe(path)
+		logProbe(logger, name, supported, err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to probe %s backend: %w", name, err)
+		}
+		if !supported {
+			return nil, fmt.Errorf("%s backend is not supported for %s", name, path)
+		}
+		return backend, nil
+	}
+
+	for _, candidate := range autoBackendOrder {
+		backend, err := LookupBackend(candidate)
+		if err != nil {
+			logger.Debug("backend not available on this platform", "backend", candidate)
+			continue
+		}
+		supported, err := backend.Probe(path)
+		logProbe(logger, candidate, supported, err)
+		if err == nil && supported {
+			return backend, nil
+		}
+	}
+
+	return nil, errCoWUnsupported()
+}
+
+// explicitBackend reports whether name asks for a specific backend rather than
+// leaving the choice to BackendAuto. Only automatic selection falls back to git.
+func explicitBackend(name string) bool {
+	return name != "" && name != BackendAuto
+}
+
+// SelectBackendWithFallback is SelectBackend, except that FallbackCopy turns an
+// unsupported backend into the copy backend instead of an error
+func SelectBackendWithFallback(name, fallback, path string) (CloneBackend, error) {
+	return selectBackendWithFallback(name, fallback, path, discardLogger)
+}
+
+// selectBackendWithFallback is SelectBackendWithFallback, logging the backends
+// it probes and why it falls back to copying
+func selectBackendWithFallback(name, fallback, path string, logger *slog.Logger) (CloneBackend, error) {
+	backend, err := selectBackend(name, path, logger)
+	if err != nil && fallback == FallbackCopy {
+		if copyBackend, lookupErr := LookupBackend(BackendCopy); lookupErr == nil {
+			logger.Info("falling back to copying", "backend", name, "reason", err)
+			return copyBackend, nil
+		}
+	}
+	return backend, err
+}
+
+// ValidateFallback checks that fallback is empty, FallbackGit or FallbackCopy
+func ValidateFallback(fallback string) error {
+	switch fallback {
+	case "", FallbackGit, FallbackCopy:
+		return nil
+	}
+	return fmt.Errorf("unknown fallback %q (available: %s, %s)", fallback, FallbackGit, FallbackCopy)
+}
+
+// cowBackend clones with the platform's copy-on-write primitive (clonefile or reflinks)
+type cowBackend struct {
+	name string
+}
+
+// Name returns the backend name
+func (b *cowBackend) Name() string {
+	return b.name
+}
+
+// Probe checks that the filesystem holding path supports copy-on-write
+func (b *cowBackend) Probe(path string) (bool, error) {
+	return isCoWFilesystem(path)
+}
+
+// Clone clones src to dst with CloneDirectoryWithOptions
+func (b *cowBackend) Clone(src, dst string, opts CloneOptions) error {
+	return CloneDirectoryWithOptions(src, dst, opts)
+}
+
+// Cleanup has nothing to release for cloned worktrees
+func (b *cowBackend) Cleanup(dst string) error {
+	return nil
+}
@@ -0,0 +1,169 @@
+package cowgit
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeBackend is a CloneBackend that copies files and records how it was called
+type fakeBackend struct {
+	name      string
+	supported bool
+
+	mu       sync.Mutex
+	clones   []string
+	cleanups []string
+}
+
+func (b *fakeBackend) Name() string {
+	return b.name
+}
+
+func (b *fakeBackend) Probe(path string) (bool, error) {
+	if _, err := os.Stat(path); err != nil {
+		return false, err
+	}
+	return b.supported, nil
+}
+
+func (b *fakeBackend) Clone(src, dst string, opts CloneOptions) error {
+	b.mu.Lock()
+	b.clones = append(b.clones, dst)
+	b.mu.Unlock()
+	return traditionalCopy(src, dst)
+}
+
+func (b *fakeBackend) Cleanup(dst string) error {
+	b.mu.Lock()
+	b.cleanups = append(b.cleanups, dst)
+	b.mu.Unlock()
+	return nil
+}
+
+// registerFakeBackend registers a fake backend for the duration of a test
+func registerFakeBackend(t *testing.T, name string, supported bool) *fakeBackend {
+	t.Helper()
+	backend := &fakeBackend{name: name, supported: supported}
+	RegisterBackend(backend)
+	t.Cleanup(func() { UnregisterBackend(name) })
+	return backend
+}
+
+func TestSelectBackend(t *testing.T) {
+	tempDir := t.TempDir()
+
+	registerFakeBackend(t, "fake-supported", true)
+	registerFakeBackend(t, "fake-unsupported", false)
+
+	if _, err := SelectBackend("no-such-backend", tempDir); err == nil {
+		t.Error("Expected error for unknown backend")
+	}
+
+	if _, err := SelectBackend("fake-unsupported", tempDir); err == nil {
+		t.Error("Expected error for backend whose probe fails")
+	}
+
+	backend, err := SelectBackend("fake-supported", tempDir)
+	if err != nil {
+		t.Fatalf("Failed to select registered backend: %v", err)
+	}
+	if backend.Name() != "fake-supported" {
+		t.Errorf("Selected backend %s, want fake-supported", backend.Name())
+	}
+
+	found := false
+	for _, name := range Backends() {
+		if name == "fake-supported" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Registered backend missing from Backends(): %v", Backends())
+	}
+
+	// Auto selection only considers the built-in CoW backends
+	if backend, err := SelectBackend(BackendAuto, tempDir); err == nil {
+		if backend.Name() != BackendClonefile && backend.Name() != BackendReflink {
+			t.Errorf("Auto selected unexpected backend %s", backend.Name())
+		}
+	}
+}
+
+func TestWorktreeUsesRegisteredBackend(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "coworktree-backend-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	backend := registerFakeBackend(t, "fake", true)
+
+	repoDir := filepath.Join(tempDir, "repo")
+	if err := os.MkdirAll(repoDir, 0755); err != nil {
+		t.Fatalf("Failed to create repo dir: %v", err)
+	}
+	setupGitRepo(t, repoDir)
+
+	// Untracked state must be carried over by the backend's clone
+	if err := os.WriteFile(filepath.Join(repoDir, "untracked.txt"), []byte("untracked"), 0644); err != nil {
+		t.Fatalf("Failed to create untracked file: %v", err)
+	}
+
+	worktreePath := filepath.Join(tempDir, "fake-wt")
+	worktree := NewWorktree(repoDir, worktreePath, "fake-branch")
+	worktree.Backend = "fake"
+
+	if err := worktree.CreateCoWWorktree(); err != nil {
+		t.Fatalf("Failed to create worktree: %v", err)
+	}
+
+	if len(backend.clones) != 1 || backend.clones[0] != worktreePath {
+		t.Fatalf("Backend clones = %v, want [%s]", backend.clones, worktreePath)
+	}
+	if worktree.Backend != "fake" {
+		t.Errorf("Worktree.Backend = %s, want fake", worktree.Backend)
+	}
+
+	gitDir, err := resolveWorktreeGitDir(worktreePath)
+	if err != nil {
+		t.Fatalf("Failed to resolve worktree git dir: %v", err)
+	}
+	meta, err := readWorktreeMetadata(gitDir)
+	if err != nil {
+		t.Fatalf("Failed to read worktree metadata: %v", err)
+	}
+	if meta.Backend != "fake" {
+		t.Errorf("Recorded backend = %s, want fake", meta.Backend)
+	}
+
+	if content, err := os.ReadFile(filepath.Join(worktreePath, "untracked.txt")); err != nil {
+		t.Errorf("Untracked file not cloned: %v", err)
+	} else if string(content) != "untracked" {
+		t.Errorf("Untracked file content mismatch: got %s", string(content))
+	}
+
+	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
+	cmd.Dir = worktreePath
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git does not recognize the worktree: %v", err)
+	}
+	if branch := strings.TrimSpace(string(output)); branch != "fake-branch" {
+		t.Errorf("Worktree HEAD = %s, want fake-branch", branch)
+	}
+
+	// Removal through a fresh Worktree must find the recorded backend
+	if err := NewWorktree(repoDir, worktreePath, "fake-branch").Remove(); err != nil {
+		t.Fatalf("Failed to remove worktree: %v", err)
+	}
+	if len(backend.cleanups) != 1 || backend.cleanups[0] != worktreePath {
+		t.Errorf("Backend cleanups = %v, want [%s]", backend.cleanups, worktreePath)
+	}
+	if _, err := os.Stat(worktreePath); !os.IsNotExist(err) {
+		t.Error("Worktree path still exists after removal")
+	}
+}
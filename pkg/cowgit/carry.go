@@ -0,0 +1,112 @@
+package cowgit
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Carry modes accepted by --carry and CreateOptions.Carry, deciding what
+// happens to the source checkout's uncommitted changes
+const (
+	// CarryCopy keeps the cloned changes in the new worktree and leaves the source alone (the default)
+	CarryCopy = "copy"
+	// CarryNone resets the new worktree's tracked and untracked files to HEAD, keeping ignored files
+	CarryNone = "none"
+	// CarryMove keeps the changes in the new worktree and stashes them out of the source
+	CarryMove = "move"
+)
+
+// ValidateCarry checks that carry is empty, CarryCopy, CarryNone or CarryMove
+func ValidateCarry(carry string) error {
+	switch carry {
+	case "", CarryCopy, CarryNone, CarryMove:
+		return nil
+	}
+	return fmt.Errorf("unknown carry mode %q (available: %s, %s, %s)", carry, CarryNone, CarryCopy, CarryMove)
+}
+
+// dropCarriedChanges discards the uncommitted changes cloned from the source:
+// the index and tracked files go back to the source commit and untracked files
+// are removed, while ignored files such as build artifacts stay
+func (w *Worktree) dropCarriedChanges() error {
+	steps := [][]string{
+		{"read-tree", "--reset", "-u", w.sourceCommit},
+		{"clean", "-f", "-d", "-q"},
+	}
+	for _, args := range steps {
+		if output, err := w.runGitCommandCombined(w.WorktreePath, args...); err != nil {
+			return fmt.Errorf("failed to drop uncommitted changes: %w: %s", err, strings.TrimSpace(string(output)))
+		}
+	}
+
+	// Submodules are separate checkouts, so the superproject's reset leaves them alone
+	for _, sub := range w.submodules {
+		for _, args := range [][]string{{"read-tree", "--reset", "-u", "HEAD"}, {"clean", "-f", "-d", "-q"}} {
+			if output, err := w.runGitCommandCombined(sub.dir, args...); err != nil {
+				return fmt.Errorf("failed to drop uncommitted changes in submodule %s: %w: %s", sub.Path, err, strings.TrimSpace(string(output)))
+			}
+		}
+	}
+	return nil
+}
+
+// stashSourceChanges stashes the source checkout's uncommitted changes,
+// including untracked files, and returns the stash commit, or an empty string
+// if there was nothing to stash
+func (w *Worktree) stashSourceChanges() (string, error) {
+	before, _ := w.runGitCommand(w.RepoPath, "rev-parse", "-q", "--verify", "refs/stash")
+
+	if output, err := w.runGitCommandCombined(w.RepoPath, "stash", "push", "--include-untracked", "-q", "-m", "coworktree: moved to "+w.WorktreePath); err != nil {
+		return "", fmt.Errorf("failed to stash changes in %s: %w: %s", w.RepoPath, err, strings.TrimSpace(string(output)))
+	}
+
+	after, _ := w.runGitCommand(w.RepoPath, "rev-parse", "-q", "--verify", "refs/stash")
+	if string(after) == string(before) {
+		return "", nil
+	}
+	return strings.TrimSpace(string(after)), nil
+}
+
+// MoveChangesFromSource stashes the source checkout's uncommitted changes and
+// applies the stash in the worktree. It implements CarryMove for worktrees
+// created with git worktree add, which carries nothing; the stash entry is
+// kept as a backup.
+func (w *Worktree) MoveChangesFromSource() error {
+	if _, err := w.repository(); err != nil {
+		return err
+	}
+	stash, err := w.stashSourceChanges()
+	if err != nil || stash == "" {
+		return err
+	}
+
+	if output, err := w.runGitCommandCombined(w.WorktreePath, "stash", "apply", "--index", "-q", stash); err != nil {
+		return fmt.Errorf("failed to apply moved changes (they are kept in stash %s): %w: %s", shortCommit(stash), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// stashFiles lists the files in the source's git directories that stashing
+// and popping rewrite even when the changes themselves round-trip
+func (w *Worktree) stashFiles() []string {
+	gitDir, commonDir := w.gitDir(), w.commonDir()
+	return []string{
+		filepath.Join(gitDir, "index"),
+		filepath.Join(gitDir, "ORIG_HEAD"),
+		filepath.Join(gitDir, "logs", "HEAD"),
+		filepath.Join(commonDir, "refs", "stash"),
+		filepath.Join(commonDir, "logs", "refs", "stash"),
+	}
+}
+
+// restoreStashedChanges puts changes stashed by stashSourceChanges back into the source
+func (w *Worktree) restoreStashedChanges(stash string) error {
+	if stash == "" {
+		return nil
+	}
+	if output, err := w.runGitCommandCombined(w.RepoPath, "stash", "pop", "--index", "-q"); err != nil {
+		return fmt.Errorf("failed to restore stashed changes (they are kept in stash %s): %w: %s", shortCommit(stash), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
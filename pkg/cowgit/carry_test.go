@@ -0,0 +1,125 @@
+package cowgit
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupDirtyRepo creates a repository with a modified tracked file, a staged
+// new file, an untracked file and an ignored build artifact
+func setupDirtyRepo(t *testing.T, repoDir string) {
+	if err := os.MkdirAll(repoDir, 0755); err != nil {
+		t.Fatalf("Failed to create repo dir: %v", err)
+	}
+	setupGitRepo(t, repoDir)
+
+	if err := os.WriteFile(filepath.Join(repoDir, ".git", "info", "exclude"), []byte("build/\n"), 0644); err != nil {
+		t.Fatalf("Failed to write exclude: %v", err)
+	}
+	files := map[string]string{
+		"test.txt":      "in progress",
+		"staged.txt":    "staged",
+		"untracked.txt": "untracked",
+		"build/out.o":   "object",
+	}
+	for name, content := range files {
+		path := filepath.Join(repoDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir for %s: %v", name, err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	if err := runCommand(repoDir, "git", "add", "staged.txt"); err != nil {
+		t.Fatalf("Failed to stage file: %v", err)
+	}
+}
+
+// gitStatus returns git status --porcelain for dir
+func gitStatus(t *testing.T, dir string) string {
+	cmd := exec.Command("git", "status", "--porcelain")
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git status failed in %s: %v", dir, err)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func TestCarryModes(t *testing.T) {
+	const dirty = "A  staged.txt\n M test.txt\n?? untracked.txt"
+
+	tests := []struct {
+		carry          string
+		noCoW          bool
+		worktreeStatus string
+		sourceStatus   string
+		testContent    string
+	}{
+		{carry: "", worktreeStatus: dirty, sourceStatus: dirty, testContent: "in progress"},
+		{carry: CarryCopy, worktreeStatus: dirty, sourceStatus: dirty, testContent: "in progress"},
+		{carry: CarryNone, worktreeStatus: "", sourceStatus: dirty, testContent: "initial content"},
+		{carry: CarryMove, worktreeStatus: dirty, sourceStatus: "", testContent: "in progress"},
+		{carry: CarryMove, noCoW: true, worktreeStatus: dirty, sourceStatus: "", testContent: "in progress"},
+	}
+
+	for _, tt := range tests {
+		name := tt.carry
+		if name == "" {
+			name = "default"
+		}
+		if tt.noCoW {
+			name += " without CoW"
+		}
+		t.Run(name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			repoDir := filepath.Join(tempDir, "repo")
+			setupDirtyRepo(t, repoDir)
+
+			manager, err := NewManager(repoDir)
+			if err != nil {
+				t.Fatalf("Failed to create manager: %v", err)
+			}
+			worktreePath := filepath.Join(tempDir, "carry-wt")
+			if _, err := manager.Create(CreateOptions{
+				BranchName:   "carry-branch",
+				WorktreePath: worktreePath,
+				NoRewrite:    true,
+				NoCoW:        tt.noCoW,
+				Backend:      BackendCopy,
+				Carry:        tt.carry,
+			}); err != nil {
+				t.Fatalf("Failed to create worktree: %v", err)
+			}
+
+			if status := gitStatus(t, worktreePath); status != tt.worktreeStatus {
+				t.Errorf("worktree status = %q, want %q", status, tt.worktreeStatus)
+			}
+			if status := gitStatus(t, repoDir); status != tt.sourceStatus {
+				t.Errorf("source status = %q, want %q", status, tt.sourceStatus)
+			}
+			if content, err := os.ReadFile(filepath.Join(worktreePath, "test.txt")); err != nil || string(content) != tt.testContent {
+				t.Errorf("worktree test.txt = %q, %v; want %q", content, err, tt.testContent)
+			}
+
+			// Ignored build artifacts are only cloned, never carried or dropped
+			_, err = os.Stat(filepath.Join(worktreePath, "build", "out.o"))
+			if tt.noCoW && err == nil {
+				t.Error("git worktree add unexpectedly produced build/out.o")
+			} else if !tt.noCoW && err != nil {
+				t.Errorf("Ignored build artifact missing from worktree: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(repoDir, "build", "out.o")); err != nil {
+				t.Errorf("Ignored build artifact removed from source: %v", err)
+			}
+		})
+	}
+
+	if err := ValidateCarry("stash"); err == nil {
+		t.Error("Expected error for unknown carry mode")
+	}
+}
@@ -0,0 +1,88 @@
+package cowgit
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+func init() {
+	RegisterBackend(copyBackend{})
+}
+
+// copyBackend copies the checkout byte for byte with the parallel CoW pool.
+// It produces the same worktree as a CoW backend, untracked and ignored files
+// included, at the cost of time and disk space.
+type copyBackend struct{}
+
+// Name returns the backend name
+func (copyBackend) Name() string {
+	return BackendCopy
+}
+
+// Probe reports true for any existing path since copying needs no filesystem support
+func (copyBackend) Probe(path string) (bool, error) {
+	if _, err := os.Stat(path); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Clone copies src to dst in parallel
+func (copyBackend) Clone(src, dst string, opts CloneOptions) error {
+	return CopyDirectoryParallel(src, dst, opts)
+}
+
+// Cleanup has nothing to release for copied worktrees
+func (copyBackend) Cleanup(dst string) error {
+	return nil
+}
+
+// CopyDirectoryParallel copies src to dst using parallel file operations.
+// Unlike CloneDirectoryParallel it never attempts copy-on-write, so it works on any filesystem.
+// Only opts.Exclude, opts.Progress, opts.Logger and opts.Context are used.
+func CopyDirectoryParallel(src, dst string, opts CloneOptions) error {
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("copy failed: %s already exists", dst)
+	}
+
+	if opts.Progress != nil {
+		opts.Progress.UpdateStage("Copying files in parallel")
+	}
+
+	return cloneDirectoryParallelFallback(opts.context(), NewCopyPool(), src, dst, opts.excludes(), opts.Progress, loggerOrDiscard(opts.Logger))
+}
+
+// copyRegularFile copies a regular file's contents, permission bits and modification time
+func copyRegularFile(src, dst string, info os.FileInfo) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if err := copyFileContents(dstFile, srcFile, info.Size()); err != nil {
+		dstFile.Close()
+		return err
+	}
+	if err := dstFile.Close(); err != nil {
+		return err
+	}
+
+	return copyMetadata(dst, info)
+}
+
+// copyMetadata applies the permission bits and modification time from info to path.
+// The exact mode is set rather than the umask-filtered creation mode, and build
+// tools compare mtimes, so both must match the source.
+func copyMetadata(path string, info os.FileInfo) error {
+	if err := os.Chmod(path, info.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+	return os.Chtimes(path, time.Time{}, info.ModTime())
+}
@@ -0,0 +1,73 @@
+//go:build linux
+
+package cowgit
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyFileContents copies size bytes from src to dst inside the kernel.
+// copy_file_range lets filesystems share or offload the copy; when it isn't
+// available (older kernels, cross-filesystem copies) sendfile is used, and
+// a userspace copy is the last resort.
+func copyFileContents(dst, src *os.File, size int64) error {
+	srcFd, dstFd := int(src.Fd()), int(dst.Fd())
+	remaining := size
+
+	for remaining > 0 {
+		n, err := unix.CopyFileRange(srcFd, nil, dstFd, nil, int(min64(remaining, 1<<30)), 0)
+		if err != nil {
+			if isCopyRangeUnsupported(err) && remaining == size {
+				return sendfileContents(dst, src, size)
+			}
+			return &os.PathError{Op: "copy_file_range", Path: src.Name(), Err: err}
+		}
+		if n == 0 {
+			break // Source shrank while copying
+		}
+		remaining -= int64(n)
+	}
+
+	return nil
+}
+
+// sendfileContents copies with sendfile, falling back to a userspace copy
+func sendfileContents(dst, src *os.File, size int64) error {
+	srcFd, dstFd := int(src.Fd()), int(dst.Fd())
+	var offset int64
+
+	for offset < size {
+		n, err := unix.Sendfile(dstFd, srcFd, &offset, int(min64(size-offset, 1<<30)))
+		if err != nil {
+			if offset == 0 && (errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOSYS)) {
+				_, err = io.Copy(dst, src)
+				return err
+			}
+			return &os.PathError{Op: "sendfile", Path: src.Name(), Err: err}
+		}
+		if n == 0 {
+			break
+		}
+	}
+
+	return nil
+}
+
+// isCopyRangeUnsupported reports whether copy_file_range can't be used for this pair of files
+func isCopyRangeUnsupported(err error) bool {
+	return errors.Is(err, unix.ENOSYS) ||
+		errors.Is(err, unix.EXDEV) ||
+		errors.Is(err, unix.EINVAL) ||
+		errors.Is(err, unix.EOPNOTSUPP)
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
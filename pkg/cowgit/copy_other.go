@@ -0,0 +1,17 @@
+//go:build !linux
+
+package cowgit
+
+import (
+	"io"
+	"os"
+)
+
+// copyFileContents copies size bytes from src to dst
+func copyFileContents(dst, src *os.File, size int64) error {
+	_, err := io.CopyN(dst, src, size)
+	if err == io.EOF {
+		return nil // Source shrank while copying
+	}
+	return err
+}
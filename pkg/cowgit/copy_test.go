@@ -0,0 +1,151 @@
+package cowgit
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCopyDirectoryParallel(t *testing.T) {
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "src")
+	dst := filepath.Join(tempDir, "dst")
+
+	if err := os.MkdirAll(filepath.Join(src, "nested", "deeper"), 0755); err != nil {
+		t.Fatalf("Failed to create source tree: %v", err)
+	}
+
+	// Large enough to need several copy_file_range calls on some kernels
+	large := bytes.Repeat([]byte("coworktree copy backend\n"), 200000)
+	if err := os.WriteFile(filepath.Join(src, "nested", "deeper", "large.bin"), large, 0644); err != nil {
+		t.Fatalf("Failed to create large file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "run.sh"), []byte("#!/bin/sh\necho hi\n"), 0755); err != nil {
+		t.Fatalf("Failed to create executable: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "empty"), nil, 0600); err != nil {
+		t.Fatalf("Failed to create empty file: %v", err)
+	}
+	if err := os.Symlink("nested/deeper/large.bin", filepath.Join(src, "link")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	past := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(src, "run.sh"), past, past); err != nil {
+		t.Fatalf("Failed to set mtime: %v", err)
+	}
+
+	if err := CopyDirectoryParallel(src, dst, CloneOptions{}); err != nil {
+		t.Fatalf("CopyDirectoryParallel failed: %v", err)
+	}
+
+	if content, err := os.ReadFile(filepath.Join(dst, "nested", "deeper", "large.bin")); err != nil {
+		t.Errorf("Large file not copied: %v", err)
+	} else if !bytes.Equal(content, large) {
+		t.Errorf("Large file content mismatch: got %d bytes, want %d", len(content), len(large))
+	}
+
+	info, err := os.Stat(filepath.Join(dst, "run.sh"))
+	if err != nil {
+		t.Fatalf("Executable not copied: %v", err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("Executable mode = %v, want 0755", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(past) {
+		t.Errorf("Executable mtime = %v, want %v", info.ModTime(), past)
+	}
+
+	if info, err := os.Stat(filepath.Join(dst, "empty")); err != nil {
+		t.Errorf("Empty file not copied: %v", err)
+	} else if info.Size() != 0 || info.Mode().Perm() != 0600 {
+		t.Errorf("Empty file copied as size %d mode %v", info.Size(), info.Mode().Perm())
+	}
+
+	if target, err := os.Readlink(filepath.Join(dst, "link")); err != nil {
+		t.Errorf("Symlink not copied: %v", err)
+	} else if target != "nested/deeper/large.bin" {
+		t.Errorf("Symlink target = %s, want nested/deeper/large.bin", target)
+	}
+
+	// Like a clone, copying never merges into an existing destination
+	if err := CopyDirectoryParallel(src, dst, CloneOptions{}); err == nil {
+		t.Error("Expected error when destination exists")
+	}
+}
+
+func TestCopyFallbackKeepsIgnoredFiles(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "coworktree-copy-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	repoDir := filepath.Join(tempDir, "repo")
+	if err := os.MkdirAll(repoDir, 0755); err != nil {
+		t.Fatalf("Failed to create repo dir: %v", err)
+	}
+	setupGitRepo(t, repoDir)
+
+	if err := os.WriteFile(filepath.Join(repoDir, ".gitignore"), []byte("node_modules/\n"), 0644); err != nil {
+		t.Fatalf("Failed to create .gitignore: %v", err)
+	}
+	if err := runCommand(repoDir, "git", "add", ".gitignore"); err != nil {
+		t.Fatalf("Failed to add .gitignore: %v", err)
+	}
+	if err := runCommand(repoDir, "git", "commit", "-m", "Ignore node_modules"); err != nil {
+		t.Fatalf("Failed to commit .gitignore: %v", err)
+	}
+
+	ignoredFile := filepath.Join("node_modules", "left-pad", "index.js")
+	if err := os.MkdirAll(filepath.Join(repoDir, filepath.Dir(ignoredFile)), 0755); err != nil {
+		t.Fatalf("Failed to create node_modules: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repoDir, ignoredFile), []byte("module.exports = {}\n"), 0644); err != nil {
+		t.Fatalf("Failed to create ignored file: %v", err)
+	}
+
+	manager, err := NewManager(repoDir)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	worktreePath := filepath.Join(tempDir, "copy-wt")
+	worktree, err := manager.Create(CreateOptions{
+		BranchName:   "copy-branch",
+		WorktreePath: worktreePath,
+		NoRewrite:    true,
+		Fallback:     FallbackCopy,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create worktree: %v", err)
+	}
+
+	if supported, _ := IsCoWSupported(repoDir); !supported && worktree.Backend != BackendCopy {
+		t.Errorf("Worktree.Backend = %s, want %s on a filesystem without CoW", worktree.Backend, BackendCopy)
+	}
+
+	if content, err := os.ReadFile(filepath.Join(worktreePath, ignoredFile)); err != nil {
+		t.Errorf("Ignored file missing from worktree: %v", err)
+	} else if string(content) != "module.exports = {}\n" {
+		t.Errorf("Ignored file content mismatch: got %q", string(content))
+	}
+
+	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
+	cmd.Dir = worktreePath
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git does not recognize the worktree: %v", err)
+	}
+	if branch := strings.TrimSpace(string(output)); branch != "copy-branch" {
+		t.Errorf("Worktree HEAD = %s, want copy-branch", branch)
+	}
+
+	if _, err := manager.Create(CreateOptions{BranchName: "bad", Fallback: "rsync"}); err == nil {
+		t.Error("Expected error for unknown fallback")
+	}
+}
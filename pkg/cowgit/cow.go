@@ -1,70 +1,69 @@
 package cowgit
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"runtime"
-	"unsafe"
-
-	"golang.org/x/sys/unix"
 )
 
 // CloneDirectory creates a copy-on-write clone of a directory using platform-specific methods
 func CloneDirectory(src, dst string) error {
-	switch runtime.GOOS {
-	case "darwin":
-		return cloneDirectoryAPFS(src, dst)
-	case "linux":
-		// TODO: Implement overlayfs for Linux
-		return errors.New("linux CoW not yet implemented")
-	default:
-		return fmt.Errorf("copy-on-write not supported on %s", runtime.GOOS)
+	if err := requireCoW(src); err != nil {
+		return err
+	}
+
+	return cloneTree(context.Background(), src, dst, nil)
+}
+
+// CloneDirectoryWithOptions creates a copy-on-write clone of a directory, skipping
+// opts.Exclude. It dispatches to the atomic, parallel, forced-parallel or
+// depth-based clone depending on opts, and stops early when opts.Context is cancelled.
+func CloneDirectoryWithOptions(src, dst string, opts CloneOptions) error {
+	if err := requireCoW(src); err != nil {
+		return err
+	}
+
+	ctx := opts.context()
+	exclude := opts.excludes()
+	logger := loggerOrDiscard(opts.Logger)
+	if !opts.Parallel {
+		return cloneTree(ctx, src, dst, exclude)
 	}
+	if opts.ParallelDepth > 0 {
+		return cloneDirectoryParallelDepth(ctx, src, dst, opts.ParallelDepth, exclude, opts.Progress, logger)
+	}
+	if opts.ForceParallel {
+		return cloneDirectoryParallelForced(ctx, src, dst, exclude, opts.Progress, logger)
+	}
+	return cloneDirectoryParallel(ctx, src, dst, exclude, opts.Progress, logger)
 }
 
 // IsCoWSupported checks if copy-on-write is supported for the given path
 func IsCoWSupported(path string) (bool, error) {
-	switch runtime.GOOS {
-	case "darwin":
-		return isAPFS(path)
-	case "linux":
-		// TODO: Check overlayfs availability
-		return false, errors.New("linux CoW not yet implemented")
-	default:
-		return false, nil
-	}
+	return isCoWFilesystem(path)
 }
 
-// cloneDirectoryAPFS creates a CoW clone using APFS clonefile on macOS
-func cloneDirectoryAPFS(src, dst string) error {
-	// Check if we're on APFS
-	if isAPFS, err := isAPFS(src); err != nil {
+// requireCoW returns an error unless the filesystem holding path supports copy-on-write
+func requireCoW(path string) error {
+	supported, err := isCoWFilesystem(path)
+	if err != nil {
 		return fmt.Errorf("failed to check filesystem: %w", err)
-	} else if !isAPFS {
-		return errors.New("copy-on-write requires APFS filesystem")
 	}
-
-	// Clone the directory
-	if err := unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW); err != nil {
-		// Handle cases where clonefile isn't supported
-		if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EXDEV) {
-			return fmt.Errorf("clonefile not supported: %w", err)
-		}
-		return fmt.Errorf("clonefile failed: %w", err)
+	if !supported {
+		return errCoWUnsupported()
 	}
-
 	return nil
 }
 
-// isAPFS checks if the given path is on an APFS filesystem
-func isAPFS(path string) (bool, error) {
-	var stat unix.Statfs_t
-	err := unix.Statfs(path, &stat)
-	if err != nil {
-		return false, err
+// errCoWUnsupported describes what copy-on-write needs on the current platform
+func errCoWUnsupported() error {
+	switch runtime.GOOS {
+	case "darwin":
+		return errors.New("copy-on-write requires APFS filesystem")
+	case "linux":
+		return errors.New("copy-on-write requires a filesystem with reflink support (btrfs, XFS with reflink=1, bcachefs)")
+	default:
+		return fmt.Errorf("copy-on-write not supported on %s", runtime.GOOS)
 	}
-
-	// Convert filesystem name from C string
-	fstype := unix.ByteSliceToString((*[256]byte)(unsafe.Pointer(&stat.Fstypename[0]))[:])
-	return fstype == "apfs", nil
-}
\ No newline at end of file
+}
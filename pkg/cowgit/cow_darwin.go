@@ -0,0 +1,108 @@
+//go:build darwin
+
+package cowgit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func init() {
+	RegisterBackend(&cowBackend{name: BackendClonefile})
+}
+
+// hasAtomicDirClone reports whether cloneTree clones a whole directory in one syscall
+const hasAtomicDirClone = true
+
+// isCoWFilesystem checks if the given path supports clonefile
+func isCoWFilesystem(path string) (bool, error) {
+	return isAPFS(path)
+}
+
+// cloneTree creates a CoW clone of a directory using APFS clonefile.
+// With exclusions, each top-level entry is cloned atomically on its own and
+// directories are only walked where an exclude pattern reaches inside them.
+// A single clonefile can't be interrupted, so ctx is only checked between entries.
+func cloneTree(ctx context.Context, src, dst string, exclude []string) error {
+	if len(exclude) > 0 {
+		return cloneTreeExcluding(ctx, src, dst, "", exclude)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW); err != nil {
+		// Handle cases where clonefile isn't supported
+		if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EXDEV) {
+			return fmt.Errorf("clonefile not supported: %w", err)
+		}
+		return fmt.Errorf("clonefile failed: %w", err)
+	}
+
+	return nil
+}
+
+// cloneTreeExcluding clones the entries of src into a new directory dst, skipping
+// those whose path relative to the clone root (rel joined with the entry name) is excluded
+func cloneTreeExcluding(ctx context.Context, src, dst, rel string, exclude []string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(dst, 0700); err != nil {
+		return fmt.Errorf("clonefile failed: %w", err)
+	}
+
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entryRel := path.Join(rel, entry.Name())
+		if matchAnyGlob(exclude, entryRel) {
+			continue
+		}
+
+		srcPath := filepath.Join(src, entry.Name())
+		dstPath := filepath.Join(dst, entry.Name())
+		if entry.IsDir() && anyGlobMayMatchInside(exclude, entryRel) {
+			err = cloneTreeExcluding(ctx, srcPath, dstPath, entryRel, exclude)
+		} else {
+			err = unix.Clonefile(srcPath, dstPath, unix.CLONE_NOFOLLOW)
+		}
+		if err != nil {
+			return fmt.Errorf("clonefile failed: %w", err)
+		}
+	}
+
+	return copyMetadata(dst, info)
+}
+
+// cloneFile creates a CoW clone of a single file using APFS clonefile
+func cloneFile(src, dst string, _ os.FileInfo) error {
+	return unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW)
+}
+
+// isAPFS checks if the given path is on an APFS filesystem
+func isAPFS(path string) (bool, error) {
+	var stat unix.Statfs_t
+	err := unix.Statfs(path, &stat)
+	if err != nil {
+		return false, err
+	}
+
+	// Convert filesystem name from C string
+	fstype := unix.ByteSliceToString((*[256]byte)(unsafe.Pointer(&stat.Fstypename[0]))[:])
+	return fstype == "apfs", nil
+}
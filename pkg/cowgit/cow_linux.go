@@ -0,0 +1,225 @@
+//go:build linux
+
+package cowgit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func init() {
+	RegisterBackend(&cowBackend{name: BackendReflink})
+}
+
+// hasAtomicDirClone reports whether cloneTree clones a whole directory in one syscall.
+// Linux has no directory-level reflink, so cloneTree walks the tree file by file.
+const hasAtomicDirClone = false
+
+// isCoWFilesystem checks if the given path supports reflinks
+func isCoWFilesystem(path string) (bool, error) {
+	return isReflinkSupported(path)
+}
+
+// isReflinkSupported probes the filesystem holding path by reflinking a temp file.
+// This covers btrfs, XFS formatted with reflink=1 and bcachefs without
+// maintaining a list of filesystem magic numbers.
+func isReflinkSupported(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	dir := path
+	if !info.IsDir() {
+		dir = filepath.Dir(path)
+	}
+
+	src, err := os.CreateTemp(dir, ".coworktree-probe-")
+	if err != nil {
+		return false, fmt.Errorf("failed to create reflink probe: %w", err)
+	}
+	defer os.Remove(src.Name())
+	defer src.Close()
+
+	if _, err := src.WriteString("coworktree reflink probe\n"); err != nil {
+		return false, fmt.Errorf("failed to write reflink probe: %w", err)
+	}
+
+	dst, err := os.CreateTemp(dir, ".coworktree-probe-")
+	if err != nil {
+		return false, fmt.Errorf("failed to create reflink probe: %w", err)
+	}
+	defer os.Remove(dst.Name())
+	defer dst.Close()
+
+	if err := unix.IoctlFileClone(int(dst.Fd()), int(src.Fd())); err != nil {
+		if isReflinkUnsupported(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("reflink probe failed: %w", err)
+	}
+
+	return true, nil
+}
+
+// isReflinkUnsupported reports whether a FICLONE error means the filesystem can't share extents
+func isReflinkUnsupported(err error) bool {
+	return errors.Is(err, unix.EOPNOTSUPP) ||
+		errors.Is(err, unix.EXDEV) ||
+		errors.Is(err, unix.EINVAL) ||
+		errors.Is(err, unix.ENOTTY) ||
+		errors.Is(err, unix.ENOSYS)
+}
+
+// cloneTree recreates src at dst, reflinking every regular file with FICLONE.
+// Directories, symlinks, permission bits and timestamps are recreated to match
+// the source; sockets, devices and FIFOs are skipped like in the parallel clone,
+// and so are paths matching exclude. The walk stops when ctx is cancelled.
+func cloneTree(ctx context.Context, src, dst string, exclude []string) error {
+	// Match clonefile semantics: never merge into an existing destination
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("reflink clone failed: %w", &os.PathError{Op: "clone", Path: dst, Err: fs.ErrExist})
+	}
+
+	type clonedDir struct {
+		path string
+		info os.FileInfo
+	}
+	var dirs []clonedDir
+
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		dstPath := filepath.Join(dst, relPath)
+
+		if relPath != "." && matchAnyGlob(exclude, filepath.ToSlash(relPath)) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.IsDir():
+			// Create writable first; the real mode is applied once the contents exist
+			if err := os.Mkdir(dstPath, 0700); err != nil {
+				return err
+			}
+			dirs = append(dirs, clonedDir{path: dstPath, info: info})
+		case info.Mode().IsRegular():
+			return reflinkFile(path, dstPath, info)
+		case info.Mode()&os.ModeSymlink != 0:
+			return copySymlink(path, dstPath, info)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("reflink clone failed: %w", err)
+	}
+
+	// Restore directory metadata deepest-first so creating children doesn't bump parent mtimes
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := restoreMetadata(dirs[i].path, dirs[i].info); err != nil {
+			return fmt.Errorf("reflink clone failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// cloneFile creates a reflink of a single regular file
+func cloneFile(src, dst string, info os.FileInfo) error {
+	return reflinkFile(src, dst, info)
+}
+
+// reflinkFile clones src into a new file at dst with FICLONE and copies its metadata
+func reflinkFile(src, dst string, info os.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := unix.IoctlFileClone(int(out.Fd()), int(in.Fd())); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return &os.PathError{Op: "ficlone", Path: src, Err: err}
+	}
+
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	return restoreMetadata(dst, info)
+}
+
+// copySymlink recreates a symlink and its timestamps
+func copySymlink(src, dst string, info os.FileInfo) error {
+	target, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+	if err := os.Symlink(target, dst); err != nil {
+		return err
+	}
+
+	atime, mtime := fileTimes(info)
+	times := []unix.Timespec{unix.NsecToTimespec(atime.UnixNano()), unix.NsecToTimespec(mtime.UnixNano())}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, dst, times, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+// restoreMetadata applies the mode and timestamps from info to path
+func restoreMetadata(path string, info os.FileInfo) error {
+	if err := os.Chmod(path, info.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+
+	atime, mtime := fileTimes(info)
+	return os.Chtimes(path, atime, mtime)
+}
+
+// fileTimes extracts access and modification times from a FileInfo
+func fileTimes(info os.FileInfo) (atime, mtime time.Time) {
+	mtime = info.ModTime()
+	atime = mtime
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		atime = time.Unix(stat.Atim.Unix())
+	}
+	return atime, mtime
+}
+
+// isAPFS always reports false on Linux; the path is still checked so callers
+// get the same errors as on macOS
+func isAPFS(path string) (bool, error) {
+	if _, err := os.Stat(path); err != nil {
+		return false, err
+	}
+	return false, nil
+}
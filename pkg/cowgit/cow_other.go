@@ -0,0 +1,34 @@
+//go:build !darwin && !linux
+
+package cowgit
+
+import (
+	"context"
+	"os"
+)
+
+// hasAtomicDirClone reports whether cloneTree clones a whole directory in one syscall
+const hasAtomicDirClone = false
+
+// isCoWFilesystem reports that copy-on-write is unavailable on this platform
+func isCoWFilesystem(path string) (bool, error) {
+	return false, nil
+}
+
+// cloneTree is not supported on this platform
+func cloneTree(_ context.Context, src, dst string, _ []string) error {
+	return errCoWUnsupported()
+}
+
+// cloneFile is not supported on this platform
+func cloneFile(src, dst string, _ os.FileInfo) error {
+	return errCoWUnsupported()
+}
+
+// isAPFS always reports false outside macOS
+func isAPFS(path string) (bool, error) {
+	if _, err := os.Stat(path); err != nil {
+		return false, err
+	}
+	return false, nil
+}
@@ -1,8 +1,9 @@
 package cowgit
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -10,8 +11,6 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
-
-	"golang.org/x/sys/unix"
 )
 
 // CoWPool manages parallel file-level copy-on-write operations
@@ -21,6 +20,10 @@ type CoWPool struct {
 	workerCount   int32
 	activeWorkers map[int]chan struct{}
 	nextWorkerID  int32
+	// copyOnly skips the CoW attempt and copies every file's bytes
+	copyOnly      bool
+	// ctx stops the workers and Submit early when cancelled
+	ctx           context.Context
 	
 	mu        sync.RWMutex
 	wg        sync.WaitGroup
@@ -60,6 +63,7 @@ type CoWPoolController struct {
 	pool       *CoWPool
 	done       chan struct{}
 	lastAdjust time.Time
+	logger     *slog.Logger
 }
 
 // NewCoWPool creates a new CoW worker pool
@@ -68,15 +72,24 @@ func NewCoWPool() *CoWPool {
 		fileChan:      make(chan CoWTask, 1000),
 		errChan:       make(chan error, 1),
 		activeWorkers: make(map[int]chan struct{}),
+		ctx:           context.Background(),
 		startTime:     time.Now(),
 	}
 }
 
+// NewCopyPool creates a worker pool that copies files instead of cloning them
+func NewCopyPool() *CoWPool {
+	pool := NewCoWPool()
+	pool.copyOnly = true
+	return pool
+}
+
 // NewCoWPoolController creates a controller for the CoW pool
 func NewCoWPoolController(pool *CoWPool) *CoWPoolController {
 	return &CoWPoolController{
-		pool: pool,
-		done: make(chan struct{}),
+		pool:   pool,
+		done:   make(chan struct{}),
+		logger: discardLogger,
 	}
 }
 
@@ -94,9 +107,12 @@ func (p *CoWPool) Stop() {
 	close(p.errChan)
 }
 
-// Submit adds a file copy task to be processed
+// Submit adds a file copy task to be processed, dropping it once the pool's context is cancelled
 func (p *CoWPool) Submit(task CoWTask) {
-	p.fileChan <- task
+	select {
+	case p.fileChan <- task:
+	case <-p.ctx.Done():
+	}
 }
 
 // Error returns the error channel
@@ -177,6 +193,9 @@ func (p *CoWPool) worker(_ int, stop <-chan struct{}) {
 			
 		case <-stop:
 			return // Worker stopped
+			
+		case <-p.ctx.Done():
+			return // Clone cancelled
 		}
 	}
 }
@@ -199,11 +218,18 @@ func (p *CoWPool) processCoWTask(task CoWTask) error {
 		return err
 	}
 	
-	// For regular files, try clonefile first
+	// For regular files, try a CoW clone first
 	if task.Info.Mode().IsRegular() {
 		atomic.AddInt64(&p.regularFiles, 1)
-		if err := unix.Clonefile(task.SrcPath, task.DstPath, unix.CLONE_NOFOLLOW); err != nil {
-			// Fall back to regular copy if clonefile fails
+		if p.copyOnly {
+			if err := p.regularCopy(task.SrcPath, task.DstPath, task.Info); err != nil {
+				return err
+			}
+			atomic.AddInt64(&p.copiedFiles, 1)
+			return nil
+		}
+		if err := cloneFile(task.SrcPath, task.DstPath, task.Info); err != nil {
+			// Fall back to regular copy if cloning fails
 			atomic.AddInt64(&p.skippedFiles, 1)
 			return p.regularCopy(task.SrcPath, task.DstPath, task.Info)
 		}
@@ -229,21 +255,7 @@ func (p *CoWPool) processCoWTask(task CoWTask) error {
 
 // regularCopy performs a regular file copy as fallback
 func (p *CoWPool) regularCopy(src, dst string, info os.FileInfo) error {
-	srcFile, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer srcFile.Close()
-	
-	dstFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode())
-	if err != nil {
-		return err
-	}
-	defer dstFile.Close()
-	
-	// Use Go's efficient copy
-	_, err = srcFile.WriteTo(dstFile)
-	return err
+	return copyRegularFile(src, dst, info)
 }
 
 // Start begins monitoring and adjusting the CoW pool
@@ -299,6 +311,7 @@ func (c *CoWPoolController) adjustWorkers(stats CoWStats, processingRate float64
 	if stats.QueueDepth > int(stats.Workers)*10 && stats.Workers < maxWorkers && processingRate > 0 {
 		c.pool.AddWorker()
 		c.lastAdjust = time.Now()
+		c.logger.Debug("added clone worker", "workers", stats.Workers+1, "queue", stats.QueueDepth, "rate", processingRate)
 		return
 	}
 	
@@ -306,6 +319,7 @@ func (c *CoWPoolController) adjustWorkers(stats CoWStats, processingRate float64
 	if stats.QueueDepth == 0 && processingRate < 5 {
 		if c.pool.RemoveWorker() {
 			c.lastAdjust = time.Now()
+			c.logger.Debug("removed clone worker", "workers", stats.Workers-1, "queue", stats.QueueDepth, "rate", processingRate)
 		}
 		return
 	}
@@ -314,42 +328,56 @@ func (c *CoWPoolController) adjustWorkers(stats CoWStats, processingRate float64
 // CloneDirectoryParallel creates a CoW clone using parallel file operations  
 // It tries atomic cloning first, then falls back to file-by-file parallel cloning
 func CloneDirectoryParallel(src, dst string, progress *ProgressTracker) error {
-	// Check if we're on APFS
-	if isAPFS, err := isAPFS(src); err != nil {
-		return fmt.Errorf("failed to check filesystem: %w", err)
-	} else if !isAPFS {
-		return errors.New("copy-on-write requires APFS filesystem")
-	}
-	
+	return CloneDirectoryWithOptions(src, dst, CloneOptions{Parallel: true, Progress: progress, Exclude: []string{}})
+}
+
+// cloneDirectoryParallel is CloneDirectoryParallel with an exclude set and a context that cancels it
+func cloneDirectoryParallel(ctx context.Context, src, dst string, exclude []string, progress *ProgressTracker, logger *slog.Logger) error {
 	// Try atomic directory clone first - this is usually much faster
-	if progress != nil {
-		progress.UpdateStage("Trying atomic directory clone")
-	}
-	
-	if err := unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW); err == nil {
-		// Atomic clone succeeded - we're done!
+	if hasAtomicDirClone {
 		if progress != nil {
-			progress.UpdateStage("Atomic clone successful")
+			progress.UpdateStage("Trying atomic directory clone")
+		}
+
+		err := cloneTree(ctx, src, dst, exclude)
+		if err == nil {
+			// Atomic clone succeeded - we're done!
+			if progress != nil {
+				progress.UpdateStage("Atomic clone successful")
+			}
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		logger.Debug("atomic clone failed, cloning file by file", "error", err)
+		if progress != nil {
+			progress.UpdateStage("Atomic clone failed, using parallel approach")
 		}
-		return nil
-	} else if progress != nil {
-		progress.UpdateStage("Atomic clone failed, using parallel approach")
 	}
 	
 	// Atomic clone failed - fall back to parallel file-by-file approach
-	return cloneDirectoryParallelFallback(src, dst, progress)
+	return cloneDirectoryParallelFallback(ctx, NewCoWPool(), src, dst, exclude, progress, logger)
 }
 
-// cloneDirectoryParallelFallback handles the file-by-file parallel cloning
-func cloneDirectoryParallelFallback(src, dst string, progress *ProgressTracker) error {
+// cloneDirectoryParallelFallback handles the file-by-file parallel cloning with the given pool,
+// skipping paths that match exclude and logging the pool's scaling to logger.
+// Cancelling ctx stops both the walk and the pool's workers.
+func cloneDirectoryParallelFallback(ctx context.Context, pool *CoWPool, src, dst string, exclude []string, progress *ProgressTracker, logger *slog.Logger) error {
 	// Create destination directory
 	if err := os.MkdirAll(dst, 0755); err != nil {
 		return fmt.Errorf("failed to create destination directory: %w", err)
 	}
 	
-	// Create CoW pool
-	pool := NewCoWPool()
+	verb := "cloned"
+	if pool.copyOnly {
+		verb = "copied"
+	}
+	
+	// Create the pool's controller
+	pool.ctx = ctx
 	controller := NewCoWPoolController(pool)
+	controller.logger = logger
 	
 	// Start pool and controller
 	pool.Start()
@@ -374,8 +402,8 @@ func cloneDirectoryParallelFallback(src, dst string, progress *ProgressTracker)
 					stats := pool.GetStats()
 					throughput := float64(stats.ProcessedFiles) / stats.ElapsedTime.Seconds()
 					
-					info := fmt.Sprintf("%d items processed (%d dirs, %d files), %d cloned, %.0f items/sec", 
-						stats.ProcessedFiles, stats.Directories, stats.RegularFiles, stats.CopiedFiles, throughput)
+					info := fmt.Sprintf("%d items processed (%d dirs, %d files), %d %s, %.0f items/sec", 
+						stats.ProcessedFiles, stats.Directories, stats.RegularFiles, stats.CopiedFiles, verb, throughput)
 					progress.UpdateStage(info)
 				}
 			case <-done:
@@ -389,6 +417,9 @@ func cloneDirectoryParallelFallback(src, dst string, progress *ProgressTracker)
 		if err != nil {
 			return err
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		
 		// Calculate destination path
 		relPath, err := filepath.Rel(src, path)
@@ -402,6 +433,14 @@ func cloneDirectoryParallelFallback(src, dst string, progress *ProgressTracker)
 			return nil
 		}
 		
+		// Skip excluded paths, and everything below excluded directories
+		if matchAnyGlob(exclude, filepath.ToSlash(relPath)) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		
 		// Submit ALL paths for parallel processing (dirs and files)
 		pool.Submit(CoWTask{
 			SrcPath: path,
@@ -421,8 +460,8 @@ func cloneDirectoryParallelFallback(src, dst string, progress *ProgressTracker)
 		finalStats := pool.GetStats()
 		
 		if finalStats.CopiedFiles > 0 {
-			info := fmt.Sprintf("%d of %d items cloned (%d dirs, %d files, %d symlinks, %d skipped)", 
-				finalStats.CopiedFiles, finalStats.ProcessedFiles, 
+			info := fmt.Sprintf("%d of %d items %s (%d dirs, %d files, %d symlinks, %d skipped)", 
+				finalStats.CopiedFiles, finalStats.ProcessedFiles, verb, 
 				finalStats.Directories, finalStats.RegularFiles, finalStats.Symlinks, finalStats.SkippedFiles)
 			progress.UpdateStage(info)
 		} else {
@@ -446,52 +485,45 @@ func cloneDirectoryParallelFallback(src, dst string, progress *ProgressTracker)
 
 // CloneDirectoryParallelForced forces file-by-file parallel cloning (skips atomic)
 func CloneDirectoryParallelForced(src, dst string, progress *ProgressTracker) error {
-	// Check if we're on APFS
-	if isAPFS, err := isAPFS(src); err != nil {
-		return fmt.Errorf("failed to check filesystem: %w", err)
-	} else if !isAPFS {
-		return errors.New("copy-on-write requires APFS filesystem")
-	}
-	
+	return CloneDirectoryWithOptions(src, dst, CloneOptions{Parallel: true, ForceParallel: true, Progress: progress, Exclude: []string{}})
+}
+
+// cloneDirectoryParallelForced is CloneDirectoryParallelForced with an exclude set and a context that cancels it
+func cloneDirectoryParallelForced(ctx context.Context, src, dst string, exclude []string, progress *ProgressTracker, logger *slog.Logger) error {
 	if progress != nil {
 		progress.UpdateStage("Forcing parallel file-by-file CoW")
 	}
 	
 	// Skip atomic attempt and go straight to parallel fallback
-	return cloneDirectoryParallelFallback(src, dst, progress)
+	return cloneDirectoryParallelFallback(ctx, NewCoWPool(), src, dst, exclude, progress, logger)
 }
 
 // CloneDirectoryParallelDepth recursively finds subdirectories at maxDepth and clones each atomically in parallel
 func CloneDirectoryParallelDepth(src, dst string, maxDepth int, progress *ProgressTracker) error {
-	// Check if we're on APFS
-	if isAPFS, err := isAPFS(src); err != nil {
-		return fmt.Errorf("failed to check filesystem: %w", err)
-	} else if !isAPFS {
-		return errors.New("copy-on-write requires APFS filesystem")
-	}
-	
+	return CloneDirectoryWithOptions(src, dst, CloneOptions{Parallel: true, ParallelDepth: maxDepth, Progress: progress, Exclude: []string{}})
+}
+
+// cloneDirectoryParallelDepth is CloneDirectoryParallelDepth with an exclude set and a context that cancels it
+func cloneDirectoryParallelDepth(ctx context.Context, src, dst string, maxDepth int, exclude []string, progress *ProgressTracker, logger *slog.Logger) error {
 	if progress != nil {
 		progress.UpdateStage(fmt.Sprintf("Finding subdirectories at depth %d for parallel atomic cloning", maxDepth))
 	}
 	
-	// Find all directories at the target depth
-	targets, err := findDirectoriesAtDepth(src, maxDepth)
+	// Recreate everything above the target depth and find the directories to clone atomically
+	targets, err := prepareDepthClone(ctx, src, dst, maxDepth, exclude)
 	if err != nil {
-		return fmt.Errorf("failed to find directories at depth %d: %w", maxDepth, err)
+		return fmt.Errorf("failed to prepare depth-%d clone: %w", maxDepth, err)
 	}
 	
 	if progress != nil {
 		progress.UpdateStage(fmt.Sprintf("Found %d directories to clone in parallel", len(targets)))
 	}
 	
-	// Create destination directory structure up to maxDepth-1
-	if err := createDirectoryStructure(src, dst, maxDepth-1); err != nil {
-		return fmt.Errorf("failed to create directory structure: %w", err)
-	}
-	
 	// Create worker pool for atomic cloning of subdirectories
 	pool := NewAtomicClonePool()
+	pool.ctx = ctx
 	controller := NewAtomicCloneController(pool)
+	controller.logger = logger
 	
 	// Start pool and controller
 	pool.Start()
@@ -541,6 +573,11 @@ func CloneDirectoryParallelDepth(src, dst string, maxDepth int, progress *Progre
 	close(pool.taskChan)
 	done <- true
 	
+	// Targets dropped by a cancelled clone are missing, not failed
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	
 	// Get final statistics
 	if progress != nil {
 		finalStats := pool.GetStats()
@@ -583,6 +620,9 @@ type AtomicClonePool struct {
 	completedDirs int64
 	failedDirs    int64
 	startTime     time.Time
+	
+	// ctx stops the workers and Submit early when cancelled
+	ctx context.Context
 }
 
 // AtomicCloneStats contains statistics about atomic cloning
@@ -600,6 +640,7 @@ type AtomicCloneController struct {
 	pool       *AtomicClonePool
 	done       chan struct{}
 	lastAdjust time.Time
+	logger     *slog.Logger
 }
 
 // NewAtomicClonePool creates a new atomic clone pool
@@ -609,14 +650,16 @@ func NewAtomicClonePool() *AtomicClonePool {
 		errChan:       make(chan error, 1),
 		activeWorkers: make(map[int]chan struct{}),
 		startTime:     time.Now(),
+		ctx:           context.Background(),
 	}
 }
 
 // NewAtomicCloneController creates a controller for the atomic clone pool
 func NewAtomicCloneController(pool *AtomicClonePool) *AtomicCloneController {
 	return &AtomicCloneController{
-		pool: pool,
-		done: make(chan struct{}),
+		pool:   pool,
+		done:   make(chan struct{}),
+		logger: discardLogger,
 	}
 }
 
@@ -634,9 +677,12 @@ func (p *AtomicClonePool) Stop() {
 	close(p.errChan)
 }
 
-// Submit adds a directory clone task
+// Submit adds a directory clone task, dropping it once the pool's context is cancelled
 func (p *AtomicClonePool) Submit(task AtomicCloneTask) {
-	p.taskChan <- task
+	select {
+	case p.taskChan <- task:
+	case <-p.ctx.Done():
+	}
 }
 
 // Error returns the error channel
@@ -717,14 +763,17 @@ func (p *AtomicClonePool) worker(_ int, stop <-chan struct{}) {
 			
 		case <-stop:
 			return // Worker stopped
+			
+		case <-p.ctx.Done():
+			return // Clone cancelled
 		}
 	}
 }
 
 // processAtomicClone performs atomic cloning of a directory
 func (p *AtomicClonePool) processAtomicClone(task AtomicCloneTask) error {
-	// Use atomic clonefile for the entire directory
-	return unix.Clonefile(task.SrcPath, task.DstPath, unix.CLONE_NOFOLLOW)
+	// Clone the entire directory (a single clonefile on APFS, a reflink walk on Linux)
+	return cloneTree(p.ctx, task.SrcPath, task.DstPath, nil)
 }
 
 // Start begins monitoring the atomic clone pool
@@ -780,6 +829,7 @@ func (c *AtomicCloneController) adjustWorkers(stats AtomicCloneStats, processing
 	if stats.QueueDepth > int(stats.Workers)*5 && stats.Workers < maxWorkers && processingRate > 0 {
 		c.pool.AddWorker()
 		c.lastAdjust = time.Now()
+		c.logger.Debug("added directory clone worker", "workers", stats.Workers+1, "queue", stats.QueueDepth, "rate", processingRate)
 		return
 	}
 	
@@ -787,93 +837,71 @@ func (c *AtomicCloneController) adjustWorkers(stats AtomicCloneStats, processing
 	if stats.QueueDepth == 0 && processingRate < 1 {
 		if c.pool.RemoveWorker() {
 			c.lastAdjust = time.Now()
+			c.logger.Debug("removed directory clone worker", "workers", stats.Workers-1, "queue", stats.QueueDepth, "rate", processingRate)
 		}
 		return
 	}
 }
 
-// findDirectoriesAtDepth finds all directories at exactly the specified depth
-func findDirectoriesAtDepth(root string, targetDepth int) ([]AtomicCloneTask, error) {
+// prepareDepthClone walks src, recreating directories shallower than targetDepth
+// at dst and cloning the files in them, and returns the directories at
+// targetDepth for atomic cloning. Excluded paths are skipped; a directory that
+// an exclude pattern reaches into is walked instead of being cloned as a whole.
+// The walk stops when ctx is cancelled.
+func prepareDepthClone(ctx context.Context, src, dst string, targetDepth int, exclude []string) ([]AtomicCloneTask, error) {
 	var targets []AtomicCloneTask
 	
-	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		
-		if !info.IsDir() {
-			return nil
-		}
-		
-		// Calculate depth relative to root
-		relPath, err := filepath.Rel(root, path)
-		if err != nil {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
 		
-		// Root directory is depth 0
-		if relPath == "." {
-			return nil
-		}
-		
-		depth := strings.Count(relPath, string(filepath.Separator)) + 1
-		
-		if depth == targetDepth {
-			// Found a directory at target depth
-			targets = append(targets, AtomicCloneTask{
-				SrcPath: path,
-				DstPath: "", // Will be set later
-			})
-			// Don't recurse deeper into this directory
-			return filepath.SkipDir
-		} else if depth > targetDepth {
-			// We've gone too deep, skip
-			return filepath.SkipDir
-		}
-		
-		return nil
-	})
-	
-	return targets, err
-}
-
-// createDirectoryStructure creates the directory structure up to maxDepth-1
-func createDirectoryStructure(src, dst string, maxDepth int) error {
-	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		
-		if !info.IsDir() {
-			return nil
-		}
-		
-		// Calculate depth and destination path
 		relPath, err := filepath.Rel(src, path)
 		if err != nil {
 			return err
 		}
+		dstPath := filepath.Join(dst, relPath)
 		
+		// Root directory is depth 0
 		if relPath == "." {
-			// Create root destination directory
 			return os.MkdirAll(dst, info.Mode())
 		}
 		
-		depth := strings.Count(relPath, string(filepath.Separator)) + 1
-		
-		if depth <= maxDepth {
-			// Create this directory
-			dstPath := filepath.Join(dst, relPath)
-			if err := os.MkdirAll(dstPath, info.Mode()); err != nil {
-				return err
+		slashPath := filepath.ToSlash(relPath)
+		if matchAnyGlob(exclude, slashPath) {
+			if info.IsDir() {
+				return filepath.SkipDir
 			}
+			return nil
 		}
 		
-		if depth >= maxDepth {
-			// Don't recurse deeper
-			return filepath.SkipDir
+		switch {
+		case info.IsDir():
+			depth := strings.Count(relPath, string(filepath.Separator)) + 1
+			if depth >= targetDepth && !anyGlobMayMatchInside(exclude, slashPath) {
+				// Found a directory to clone atomically; don't recurse into it
+				targets = append(targets, AtomicCloneTask{SrcPath: path, DstPath: dstPath})
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(dstPath, info.Mode())
+		case info.Mode().IsRegular():
+			if err := cloneFile(path, dstPath, info); err != nil {
+				os.Remove(dstPath)
+				return copyRegularFile(path, dstPath, info)
+			}
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, dstPath)
 		}
 		
 		return nil
 	})
-}
\ No newline at end of file
+	
+	return targets, err
+}
@@ -1,7 +1,11 @@
 package cowgit
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestIsAPFS(t *testing.T) {
@@ -22,14 +26,14 @@ func TestIsAPFS(t *testing.T) {
 }
 
 func TestCloneDirectory(t *testing.T) {
-	// This test will only work on APFS
-	result, err := isAPFS(".")
+	// This test will only work on a CoW-capable filesystem (APFS or reflink)
+	result, err := IsCoWSupported(".")
 	if err != nil {
 		t.Fatalf("Failed to check filesystem: %v", err)
 	}
 	
 	if !result {
-		t.Skip("Skipping CoW test - not on APFS filesystem")
+		t.Skip("Skipping CoW test - filesystem does not support copy-on-write")
 	}
 	
 	// Test with non-existent source
@@ -55,4 +59,205 @@ func TestIsCoWSupported(t *testing.T) {
 	if err == nil {
 		t.Error("Expected error for non-existent path")
 	}
-}
\ No newline at end of file
+}
+
+func TestCloneDirectoryPreservesMetadata(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "cow-metadata-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	if supported, err := IsCoWSupported(tempDir); err != nil || !supported {
+		t.Skip("CoW not supported on this filesystem")
+	}
+
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "destination")
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// Create a private directory, an executable and a symlink with known metadata
+	if err := os.MkdirAll(filepath.Join(srcDir, "private"), 0700); err != nil {
+		t.Fatalf("Failed to create private dir: %v", err)
+	}
+	script := filepath.Join(srcDir, "private", "run.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho hi\n"), 0750); err != nil {
+		t.Fatalf("Failed to create script: %v", err)
+	}
+	if err := os.Symlink("private/run.sh", filepath.Join(srcDir, "run")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if err := os.Chtimes(script, mtime, mtime); err != nil {
+		t.Fatalf("Failed to set script times: %v", err)
+	}
+	if err := os.Chtimes(filepath.Join(srcDir, "private"), mtime, mtime); err != nil {
+		t.Fatalf("Failed to set dir times: %v", err)
+	}
+
+	if err := CloneDirectory(srcDir, dstDir); err != nil {
+		t.Fatalf("CoW clone failed: %v", err)
+	}
+
+	checks := []struct {
+		path string
+		mode os.FileMode
+	}{
+		{"private", os.ModeDir | 0700},
+		{filepath.Join("private", "run.sh"), 0750},
+	}
+	for _, check := range checks {
+		info, err := os.Stat(filepath.Join(dstDir, check.path))
+		if err != nil {
+			t.Errorf("Cloned %s missing: %v", check.path, err)
+			continue
+		}
+		if info.Mode() != check.mode {
+			t.Errorf("Mode of %s = %v, want %v", check.path, info.Mode(), check.mode)
+		}
+		if !info.ModTime().Equal(mtime) {
+			t.Errorf("Mtime of %s = %v, want %v", check.path, info.ModTime(), mtime)
+		}
+	}
+
+	if target, err := os.Readlink(filepath.Join(dstDir, "run")); err != nil {
+		t.Errorf("Symlink not cloned: %v", err)
+	} else if target != "private/run.sh" {
+		t.Errorf("Symlink target = %q, want %q", target, "private/run.sh")
+	}
+
+	// Cloning onto an existing destination must fail rather than merge
+	if err := CloneDirectory(srcDir, dstDir); err == nil {
+		t.Error("Expected error when cloning onto an existing destination")
+	}
+}
+
+func TestCloneExclude(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	files := []string{
+		".git/objects/ab/cdef",
+		".git/HEAD",
+		"root.txt",
+		"src/main.go",
+		"build/keep.txt",
+		"build/out/app.o",
+	}
+	for _, file := range files {
+		path := filepath.Join(src, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(file), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", file, err)
+		}
+	}
+
+	exclude := []string{".git", "build/out"}
+	wantPresent := []string{"root.txt", "src/main.go", "build/keep.txt"}
+	wantAbsent := []string{".git", "build/out"}
+
+	checkClone := func(t *testing.T, dst string) {
+		t.Helper()
+		for _, file := range wantPresent {
+			if _, err := os.Stat(filepath.Join(dst, filepath.FromSlash(file))); err != nil {
+				t.Errorf("%s missing from clone: %v", file, err)
+			}
+		}
+		for _, file := range wantAbsent {
+			if _, err := os.Lstat(filepath.Join(dst, filepath.FromSlash(file))); !os.IsNotExist(err) {
+				t.Errorf("Excluded %s was cloned", file)
+			}
+		}
+	}
+
+	t.Run("parallel", func(t *testing.T) {
+		dst := filepath.Join(t.TempDir(), "dst")
+		if err := CopyDirectoryParallel(src, dst, CloneOptions{Exclude: exclude}); err != nil {
+			t.Fatalf("Parallel copy failed: %v", err)
+		}
+		checkClone(t, dst)
+	})
+
+	t.Run("depth", func(t *testing.T) {
+		dst := filepath.Join(t.TempDir(), "dst")
+		targets, err := prepareDepthClone(context.Background(), src, dst, 1, exclude)
+		if err != nil {
+			t.Fatalf("prepareDepthClone failed: %v", err)
+		}
+
+		// build is walked because an exclude pattern reaches into it; src is cloned whole
+		if len(targets) != 1 || targets[0].SrcPath != filepath.Join(src, "src") {
+			t.Fatalf("Depth targets = %v, want only %s", targets, filepath.Join(src, "src"))
+		}
+		if _, err := os.Lstat(targets[0].DstPath); !os.IsNotExist(err) {
+			t.Fatal("Depth target directory was created before being cloned")
+		}
+
+		// Stand in for the atomic clone of the target, which needs CoW
+		if err := traditionalCopy(targets[0].SrcPath, targets[0].DstPath); err != nil {
+			t.Fatalf("Failed to copy target: %v", err)
+		}
+		checkClone(t, dst)
+	})
+
+	t.Run("hardlink", func(t *testing.T) {
+		dst := filepath.Join(t.TempDir(), "dst")
+		if err := HardlinkDirectory(src, dst, CloneOptions{Exclude: exclude}); err != nil {
+			t.Fatalf("Hardlink clone failed: %v", err)
+		}
+		checkClone(t, dst)
+	})
+
+	t.Run("default excludes .git", func(t *testing.T) {
+		dst := filepath.Join(t.TempDir(), "dst")
+		if err := CopyDirectoryParallel(src, dst, CloneOptions{}); err != nil {
+			t.Fatalf("Parallel copy failed: %v", err)
+		}
+		if _, err := os.Lstat(filepath.Join(dst, ".git")); !os.IsNotExist(err) {
+			t.Error(".git was cloned with the default exclude set")
+		}
+		if _, err := os.Stat(filepath.Join(dst, "build", "out", "app.o")); err != nil {
+			t.Errorf("build/out missing with the default exclude set: %v", err)
+		}
+	})
+
+	t.Run("cow", func(t *testing.T) {
+		if supported, _ := IsCoWSupported(src); !supported {
+			t.Skip("filesystem does not support copy-on-write")
+		}
+		modes := map[string]CloneOptions{
+			"atomic":   {},
+			"parallel": {Parallel: true},
+			"forced":   {Parallel: true, ForceParallel: true},
+			"depth":    {Parallel: true, ParallelDepth: 1},
+		}
+		for name, opts := range modes {
+			dst := filepath.Join(t.TempDir(), name)
+			opts.Exclude = exclude
+			if err := CloneDirectoryWithOptions(src, dst, opts); err != nil {
+				t.Fatalf("%s clone failed: %v", name, err)
+			}
+			checkClone(t, dst)
+		}
+	})
+}
+
+func TestGlobMayMatchInside(t *testing.T) {
+	tests := []struct {
+		pattern string
+		dir     string
+		want    bool
+	}{
+		{".git", ".git", false},
+		{".git", "src", false},
+		{"build/out", "build", true},
+		{"build/out", "src", false},
+		{"**/node_modules", "src", true},
+		{"*/cache", "pkg", true},
+	}
+
+	for _, tt := range tests {
+		if got := globMayMatchInside(tt.pattern, tt.dir); got != tt.want {
+			t.Errorf("globMayMatchInside(%q, %q) = %v, want %v", tt.pattern, tt.dir, got, tt.want)
+		}
+	}
+}
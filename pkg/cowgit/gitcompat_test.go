@@ -0,0 +1,216 @@
+package cowgit
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// setupCompatRepo creates a repository with two commits, a local branch at
+// the first one and a remote-tracking branch origin/feature
+func setupCompatRepo(t *testing.T, repoDir string) {
+	if err := os.MkdirAll(repoDir, 0755); err != nil {
+		t.Fatalf("Failed to create repo dir: %v", err)
+	}
+	setupGitRepo(t, repoDir)
+
+	if err := os.WriteFile(filepath.Join(repoDir, "test.txt"), []byte("second content"), 0644); err != nil {
+		t.Fatalf("Failed to modify test.txt: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repoDir, "second.txt"), []byte("second file"), 0644); err != nil {
+		t.Fatalf("Failed to create second.txt: %v", err)
+	}
+
+	commands := [][]string{
+		{"add", "."},
+		{"commit", "-m", "Second commit"},
+		{"branch", "existing", "HEAD~1"},
+		{"config", "remote.origin.url", "/nonexistent/origin.git"},
+		{"config", "remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*"},
+		{"update-ref", "refs/remotes/origin/feature", "HEAD~1"},
+	}
+	for _, args := range commands {
+		if output, err := exec.Command("git", append([]string{"-C", repoDir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s failed: %v: %s", strings.Join(args, " "), err, output)
+		}
+	}
+}
+
+// currentBranch returns the branch checked out in repoDir
+func currentBranch(t *testing.T, repoDir string) string {
+	output, err := exec.Command("git", "-C", repoDir, "symbolic-ref", "--short", "HEAD").Output()
+	if err != nil {
+		t.Fatalf("Failed to get current branch: %v", err)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// worktreeSnapshot records everything about a new worktree that git worktree add decides
+func worktreeSnapshot(t *testing.T, repoDir, worktreePath string) map[string]string {
+	git := func(dir string, args ...string) string {
+		output, _ := exec.Command("git", append([]string{"-C", dir}, args...)...).Output()
+		return strings.TrimSpace(string(output))
+	}
+
+	entries, err := os.ReadDir(worktreePath)
+	if err != nil {
+		t.Fatalf("Failed to read worktree: %v", err)
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.Name() != ".git" {
+			files = append(files, entry.Name())
+		}
+	}
+	sort.Strings(files)
+
+	return map[string]string{
+		"HEAD":     git(worktreePath, "rev-parse", "HEAD"),
+		"symref":   git(worktreePath, "symbolic-ref", "-q", "HEAD"),
+		"branches": git(repoDir, "for-each-ref", "--format=%(refname) %(objectname) %(upstream)", "refs/heads"),
+		"config":   git(repoDir, "config", "--get-regexp", `^branch\.`),
+		"status":   git(worktreePath, "status", "--porcelain"),
+		"files":    strings.Join(files, " "),
+	}
+}
+
+func TestWorktreeModesMatchGit(t *testing.T) {
+	// Fixed dates make both repositories produce the same commit ids
+	for _, name := range []string{"GIT_AUTHOR_DATE", "GIT_COMMITTER_DATE"} {
+		t.Setenv(name, "2024-01-01T00:00:00Z")
+	}
+
+	tests := []struct {
+		name     string
+		dir      string
+		worktree Worktree
+		gitArgs  []string // git worktree add arguments; "<path>" is replaced by the worktree path
+	}{
+		{
+			name:     "new branch",
+			dir:      "topic",
+			worktree: Worktree{BranchName: "topic"},
+			gitArgs:  []string{"-b", "topic", "<path>"},
+		},
+		{
+			name:     "reset existing branch",
+			dir:      "existing",
+			worktree: Worktree{BranchName: "existing", ResetBranch: true},
+			gitArgs:  []string{"-B", "existing", "<path>"},
+		},
+		{
+			name:     "detach",
+			dir:      "detached",
+			worktree: Worktree{Detach: true, FromCommit: "HEAD~1"},
+			gitArgs:  []string{"--detach", "<path>", "HEAD~1"},
+		},
+		{
+			name:     "remote start point tracks by default",
+			dir:      "feat",
+			worktree: Worktree{BranchName: "feat", FromCommit: "origin/feature"},
+			gitArgs:  []string{"-b", "feat", "<path>", "origin/feature"},
+		},
+		{
+			name:     "track",
+			dir:      "feat",
+			worktree: Worktree{BranchName: "feat", Track: true, FromCommit: "origin/feature"},
+			gitArgs:  []string{"-b", "feat", "--track", "<path>", "origin/feature"},
+		},
+		{
+			name:     "no track",
+			dir:      "feat",
+			worktree: Worktree{BranchName: "feat", NoTrack: true, FromCommit: "origin/feature"},
+			gitArgs:  []string{"-b", "feat", "--no-track", "<path>", "origin/feature"},
+		},
+		{
+			name:     "guess remote",
+			dir:      "feature",
+			worktree: Worktree{BranchName: "feature", GuessRemote: true},
+			gitArgs:  []string{"--guess-remote", "<path>"},
+		},
+		{
+			name:     "existing branch",
+			dir:      "existing",
+			worktree: Worktree{BranchName: "existing", ExistingBranch: true},
+			gitArgs:  []string{"<path>", "existing"},
+		},
+		{
+			name:     "no checkout",
+			dir:      "empty",
+			worktree: Worktree{BranchName: "empty", NoCheckout: true},
+			gitArgs:  []string{"-b", "empty", "--no-checkout", "<path>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+
+			// Reference: real git worktree add
+			gitRepo := filepath.Join(tempDir, "git", "repo")
+			setupCompatRepo(t, gitRepo)
+			gitPath := filepath.Join(tempDir, "git", tt.dir)
+			args := []string{"-C", gitRepo, "worktree", "add"}
+			for _, arg := range tt.gitArgs {
+				if arg == "<path>" {
+					arg = gitPath
+				}
+				args = append(args, arg)
+			}
+			if output, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+				t.Fatalf("git worktree add failed: %v: %s", err, output)
+			}
+
+			// The same options through the CoW path
+			cowRepo := filepath.Join(tempDir, "cow", "repo")
+			setupCompatRepo(t, cowRepo)
+			worktree := tt.worktree
+			worktree.RepoPath = cowRepo
+			worktree.WorktreePath = filepath.Join(tempDir, "cow", tt.dir)
+			worktree.NoRewrite = true
+			worktree.Backend = BackendCopy
+			worktree.Fallback = FallbackCopy
+			if err := worktree.CreateCoWWorktree(); err != nil {
+				t.Fatalf("CreateCoWWorktree failed: %v", err)
+			}
+
+			want := worktreeSnapshot(t, gitRepo, gitPath)
+			got := worktreeSnapshot(t, cowRepo, worktree.WorktreePath)
+			for _, key := range []string{"HEAD", "symref", "branches", "config", "status", "files"} {
+				if got[key] != want[key] {
+					t.Errorf("%s differs from git worktree add:\n got: %q\nwant: %q", key, got[key], want[key])
+				}
+			}
+		})
+	}
+
+	t.Run("invalid combinations", func(t *testing.T) {
+		repoDir := filepath.Join(t.TempDir(), "repo")
+		setupCompatRepo(t, repoDir)
+
+		invalid := map[string]Worktree{
+			"branch exists":    {BranchName: "existing"},
+			"detach and -B":    {BranchName: "x", Detach: true, ResetBranch: true},
+			"track and no":     {BranchName: "x", Track: true, NoTrack: true},
+			"detach and track": {Detach: true, Track: true},
+			"bad branch name":  {BranchName: "bad..name"},
+			"missing branch":   {BranchName: "missing", ExistingBranch: true},
+			"checked out":      {BranchName: currentBranch(t, repoDir), ExistingBranch: true},
+		}
+		for name, worktree := range invalid {
+			worktree.RepoPath = repoDir
+			worktree.WorktreePath = filepath.Join(filepath.Dir(repoDir), "invalid")
+			worktree.Backend = BackendCopy
+			worktree.Fallback = FallbackCopy
+			if err := worktree.CreateCoWWorktree(); err == nil {
+				t.Errorf("%s: expected error", name)
+			}
+			if _, err := os.Stat(worktree.WorktreePath); !os.IsNotExist(err) {
+				t.Errorf("%s: worktree directory left behind", name)
+			}
+		}
+	})
+}
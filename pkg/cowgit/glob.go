@@ -0,0 +1,91 @@
+package cowgit
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// matchGlob reports whether a slash-separated relative path matches pattern.
+// Each segment uses path.Match syntax, and a "**" segment matches any number
+// of segments, including none, so "node_modules/**" covers the whole tree.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// matchSegments matches path segments against pattern segments
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
+
+// matchAnyGlob reports whether name matches at least one of the patterns
+func matchAnyGlob(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// globMayMatchInside reports whether pattern could match a path strictly inside dir.
+// Clone paths use it to decide whether a directory can be cloned as a whole or
+// must be walked so excluded entries below it can be skipped.
+func globMayMatchInside(pattern, dir string) bool {
+	patternSegs, dirSegs := strings.Split(pattern, "/"), strings.Split(dir, "/")
+	for len(dirSegs) > 0 {
+		if len(patternSegs) == 0 {
+			return false
+		}
+		if patternSegs[0] == "**" {
+			return true
+		}
+		if ok, err := path.Match(patternSegs[0], dirSegs[0]); err != nil || !ok {
+			return false
+		}
+		patternSegs, dirSegs = patternSegs[1:], dirSegs[1:]
+	}
+	return len(patternSegs) > 0
+}
+
+// anyGlobMayMatchInside reports whether any of the patterns could match a path inside dir
+func anyGlobMayMatchInside(patterns []string, dir string) bool {
+	for _, pattern := range patterns {
+		if globMayMatchInside(pattern, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateGlob checks that pattern is a well-formed glob for matchGlob
+func ValidateGlob(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("empty glob pattern")
+	}
+	for _, segment := range strings.Split(pattern, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
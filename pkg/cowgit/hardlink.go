@@ -0,0 +1,226 @@
+package cowgit
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+func init() {
+	RegisterBackend(hardlinkBackend{})
+}
+
+// DefaultHardlinkPaths are the globs the hardlink backend links when none are configured
+var DefaultHardlinkPaths = []string{"**/node_modules/**", ".venv/**", "venv/**"}
+
+// hardlinkBackend hardlinks files under read-mostly dependency directories and
+// reflinks or copies everything else. Hardlinked files share their inode with
+// the source checkout, so editing one in place edits both; DetachHardlinks
+// turns them into independent copies.
+type hardlinkBackend struct{}
+
+// Name returns the backend name
+func (hardlinkBackend) Name() string {
+	return BackendHardlink
+}
+
+// Probe reports true for any existing path; hardlinks work on every local filesystem
+func (hardlinkBackend) Probe(path string) (bool, error) {
+	if _, err := os.Stat(path); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Clone builds the hardlink farm at dst
+func (hardlinkBackend) Clone(src, dst string, opts CloneOptions) error {
+	return HardlinkDirectory(src, dst, opts)
+}
+
+// Cleanup has nothing to release for hardlinked worktrees
+func (hardlinkBackend) Cleanup(dst string) error {
+	return nil
+}
+
+// HardlinkDirectory recreates src at dst, hardlinking regular files whose path
+// relative to src matches one of opts.HardlinkPaths (DefaultHardlinkPaths if empty).
+// Other files are reflinked when the filesystem supports it and copied otherwise,
+// and paths matching opts.Exclude are skipped. src and dst must be on the same filesystem.
+// The walk stops when opts.Context is cancelled.
+func HardlinkDirectory(src, dst string, opts CloneOptions) error {
+	patterns := opts.HardlinkPaths
+	if len(patterns) == 0 {
+		patterns = DefaultHardlinkPaths
+	}
+	for _, pattern := range patterns {
+		if err := ValidateGlob(pattern); err != nil {
+			return err
+		}
+	}
+
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("hardlink clone failed: %s already exists", dst)
+	}
+
+	ctx := opts.context()
+	exclude := opts.excludes()
+	useCoW, _ := isCoWFilesystem(src)
+
+	type createdDir struct {
+		path string
+		info os.FileInfo
+	}
+	var dirs []createdDir
+	var linked, cloned, copied int
+
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		dstPath := filepath.Join(dst, relPath)
+
+		if relPath != "." && matchAnyGlob(exclude, filepath.ToSlash(relPath)) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.IsDir():
+			// Create writable first; the real mode is applied once the contents exist
+			if err := os.Mkdir(dstPath, 0700); err != nil {
+				return err
+			}
+			dirs = append(dirs, createdDir{path: dstPath, info: info})
+		case info.Mode().IsRegular():
+			if matchAnyGlob(patterns, filepath.ToSlash(relPath)) {
+				linked++
+				return os.Link(path, dstPath)
+			}
+			if useCoW {
+				if err := cloneFile(path, dstPath, info); err == nil {
+					cloned++
+					return nil
+				}
+				os.Remove(dstPath)
+			}
+			copied++
+			return copyRegularFile(path, dstPath, info)
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(target, dstPath); err != nil {
+				return err
+			}
+			return restoreSymlinkTimes(dstPath, info)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("hardlink clone failed: %w", err)
+	}
+
+	// Apply directory metadata deepest-first so creating children doesn't bump parent mtimes
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := copyMetadata(dirs[i].path, dirs[i].info); err != nil {
+			return fmt.Errorf("hardlink clone failed: %w", err)
+		}
+	}
+
+	if opts.Progress != nil {
+		opts.Progress.UpdateStage(fmt.Sprintf("%d files hardlinked, %d cloned, %d copied", linked, cloned, copied))
+	}
+
+	return nil
+}
+
+// FindHardlinks returns the regular files under root that share their inode with another path.
+// Nested .git directories are skipped.
+func FindHardlinks(root string) ([]string, error) {
+	var paths []string
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if linkCount(info) > 1 {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+
+	return paths, err
+}
+
+// DetachHardlinks replaces every hardlinked file under root with an independent
+// copy, so later edits stay local to this worktree. It returns the number of
+// files detached.
+func DetachHardlinks(root string) (int, error) {
+	paths, err := FindHardlinks(root)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find hardlinks: %w", err)
+	}
+
+	for i, path := range paths {
+		if err := detachFile(path); err != nil {
+			return i, fmt.Errorf("failed to detach %s: %w", path, err)
+		}
+	}
+
+	return len(paths), nil
+}
+
+// detachFile copies path next to itself and renames the copy over the hardlink
+func detachFile(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".coworktree-detach-")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+
+	if err := copyRegularFile(path, tmpPath, info); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
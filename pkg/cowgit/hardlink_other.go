@@ -0,0 +1,15 @@
+//go:build !unix
+
+package cowgit
+
+import "os"
+
+// linkCount reports a single link where the platform doesn't expose link counts
+func linkCount(info os.FileInfo) uint64 {
+	return 1
+}
+
+// restoreSymlinkTimes is a no-op where symlink timestamps can't be set
+func restoreSymlinkTimes(path string, info os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,149 @@
+package cowgit
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"node_modules/**", "node_modules/left-pad/index.js", true},
+		{"node_modules/**", "node_modules/index.js", true},
+		{"node_modules/**", "src/node_modules/index.js", false},
+		{"**/node_modules/**", "src/node_modules/index.js", true},
+		{"**/node_modules/**", "node_modules/a/b/c.js", true},
+		{"**/node_modules/**", "src/index.js", false},
+		{".venv/lib/*/site-packages/**", ".venv/lib/python3.12/site-packages/six.py", true},
+		{".venv/lib/*/site-packages/**", ".venv/bin/python", false},
+		{"*.lock", "yarn.lock", true},
+		{"*.lock", "sub/yarn.lock", false},
+		{"vendor/**/*.go", "vendor/github.com/x/y.go", true},
+		{"vendor/**/*.go", "vendor/github.com/x/y.c", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+
+	if err := ValidateGlob("node_modules/[a-"); err == nil {
+		t.Error("Expected error for malformed glob")
+	}
+}
+
+func TestHardlinkWorktreeAndDetach(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "coworktree-hardlink-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	repoDir := filepath.Join(tempDir, "repo")
+	if err := os.MkdirAll(repoDir, 0755); err != nil {
+		t.Fatalf("Failed to create repo dir: %v", err)
+	}
+	setupGitRepo(t, repoDir)
+
+	depFiles := []string{
+		filepath.Join("node_modules", "left-pad", "index.js"),
+		filepath.Join("node_modules", "right-pad", "index.js"),
+	}
+	for _, file := range depFiles {
+		if err := os.MkdirAll(filepath.Join(repoDir, filepath.Dir(file)), 0755); err != nil {
+			t.Fatalf("Failed to create dependency dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(repoDir, file), []byte("module.exports = {}\n"), 0644); err != nil {
+			t.Fatalf("Failed to create dependency file: %v", err)
+		}
+	}
+
+	// A symlink with an old mtime, which the worktree's copy should keep
+	symlink := filepath.Join("node_modules", ".bin-left-pad")
+	if err := os.Symlink(filepath.Join("left-pad", "index.js"), filepath.Join(repoDir, symlink)); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	symlinkTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	stamp := filepath.Join(tempDir, "stamp")
+	if err := os.WriteFile(stamp, nil, 0644); err != nil {
+		t.Fatalf("Failed to create stamp file: %v", err)
+	}
+	if err := os.Chtimes(stamp, symlinkTime, symlinkTime); err != nil {
+		t.Fatalf("Failed to set stamp mtime: %v", err)
+	}
+	stampInfo, err := os.Stat(stamp)
+	if err != nil {
+		t.Fatalf("Failed to stat stamp file: %v", err)
+	}
+	if err := restoreSymlinkTimes(filepath.Join(repoDir, symlink), stampInfo); err != nil {
+		t.Fatalf("Failed to set symlink mtime: %v", err)
+	}
+
+	worktreePath := filepath.Join(tempDir, "hardlink-wt")
+	worktree := NewWorktree(repoDir, worktreePath, "hardlink-branch")
+	worktree.Backend = BackendHardlink
+	worktree.HardlinkPaths = []string{"node_modules/**"}
+
+	if err := worktree.CreateCoWWorktree(); err != nil {
+		t.Fatalf("Failed to create worktree: %v", err)
+	}
+	if worktree.Backend != BackendHardlink {
+		t.Fatalf("Worktree.Backend = %s, want %s (creation fell back)", worktree.Backend, BackendHardlink)
+	}
+
+	sameFile := func(rel string) bool {
+		a, errA := os.Stat(filepath.Join(repoDir, rel))
+		b, errB := os.Stat(filepath.Join(worktreePath, rel))
+		if errA != nil || errB != nil {
+			t.Fatalf("Failed to stat %s: %v, %v", rel, errA, errB)
+		}
+		return os.SameFile(a, b)
+	}
+
+	for _, file := range depFiles {
+		if !sameFile(file) {
+			t.Errorf("%s was not hardlinked", file)
+		}
+	}
+	if sameFile("test.txt") {
+		t.Error("test.txt was hardlinked but does not match any glob")
+	}
+	if info, err := os.Lstat(filepath.Join(worktreePath, symlink)); err != nil {
+		t.Errorf("Symlink missing from worktree: %v", err)
+	} else if runtime.GOOS != "windows" && !info.ModTime().Equal(symlinkTime) {
+		t.Errorf("Symlink mtime = %v, want %v", info.ModTime(), symlinkTime)
+	}
+
+	// Detaching one package leaves the other linked
+	detached, err := DetachHardlinks(filepath.Join(worktreePath, "node_modules", "left-pad"))
+	if err != nil {
+		t.Fatalf("DetachHardlinks failed: %v", err)
+	}
+	if detached != 1 {
+		t.Errorf("Detached %d files, want 1", detached)
+	}
+	if sameFile(depFiles[0]) {
+		t.Errorf("%s still hardlinked after detach", depFiles[0])
+	}
+	if !sameFile(depFiles[1]) {
+		t.Errorf("%s was detached outside the requested subtree", depFiles[1])
+	}
+
+	// Edits to a detached file stay in the worktree
+	if err := os.WriteFile(filepath.Join(worktreePath, depFiles[0]), []byte("changed\n"), 0644); err != nil {
+		t.Fatalf("Failed to edit detached file: %v", err)
+	}
+	if content, err := os.ReadFile(filepath.Join(repoDir, depFiles[0])); err != nil {
+		t.Fatalf("Failed to read source file: %v", err)
+	} else if string(content) != "module.exports = {}\n" {
+		t.Errorf("Editing the detached file changed the source: %q", string(content))
+	}
+}
+
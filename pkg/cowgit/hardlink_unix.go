@@ -0,0 +1,25 @@
+//go:build unix
+
+package cowgit
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// linkCount returns the number of hardlinks to the file described by info
+func linkCount(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Nlink)
+	}
+	return 1
+}
+
+// restoreSymlinkTimes applies the modification time from info to the symlink
+// at path without following it, like copyMetadata does for other files
+func restoreSymlinkTimes(path string, info os.FileInfo) error {
+	mtime := unix.NsecToTimeval(info.ModTime().UnixNano())
+	return unix.Lutimes(path, []unix.Timeval{mtime, mtime})
+}
@@ -0,0 +1,133 @@
+package cowgit
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+)
+
+// indexStatData holds the stat fields git caches per index entry besides mtime and size
+type indexStatData struct {
+	ctime time.Time
+	dev   uint32
+	ino   uint32
+	uid   uint32
+	gid   uint32
+}
+
+// staleEntryVerifier reports whether the worktree file at path, whose index
+// entry was stale in the source, still matches the entry's blob
+type staleEntryVerifier func(entry *index.Entry, path string, info os.FileInfo) bool
+
+// cloneIndex gives a new worktree its own copy of the source checkout's index.
+// Entries whose cached stat data still matches the file in the source are
+// refreshed against the file in the worktree, so git status is clean right
+// away without rehashing. Entries that were already stale keep their old stat
+// data and are rehashed by git as usual, unless verify, which may be nil,
+// confirms them.
+func cloneIndex(repoPath, sourceGitDir, worktreePath, worktreeGitDir string, verify staleEntryVerifier) error {
+	data, err := os.ReadFile(filepath.Join(sourceGitDir, "index"))
+	if os.IsNotExist(err) {
+		return nil // Nothing staged yet
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+
+	dstIndex := filepath.Join(worktreeGitDir, "index")
+
+	idx := &index.Index{}
+	if err := index.NewDecoder(bytes.NewReader(data)).Decode(idx); err != nil {
+		// Split or sparse indexes can't be rewritten here; hand git a plain copy to refresh
+		if err := os.WriteFile(dstIndex, data, 0644); err != nil {
+			return fmt.Errorf("failed to copy index: %w", err)
+		}
+		cmd := gitCommand(worktreePath, "update-index", "-q", "--refresh")
+		cmd.Run() // Exits non-zero when files differ, which is expected for dirty checkouts
+		return nil
+	}
+
+	refreshIndexEntries(idx, repoPath, worktreePath, verify)
+
+	var buf bytes.Buffer
+	if err := index.NewEncoder(&buf).Encode(idx); err != nil {
+		return fmt.Errorf("failed to encode index: %w", err)
+	}
+
+	// Write through index.lock like git so a concurrent git never sees a partial index
+	lockFile := dstIndex + ".lock"
+	if err := os.WriteFile(lockFile, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	if err := os.Rename(lockFile, dstIndex); err != nil {
+		os.Remove(lockFile)
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+
+	return nil
+}
+
+// refreshIndexEntries updates the cached stat data of entries that are clean in repoPath,
+// or that verify confirms, to describe the corresponding files in worktreePath
+func refreshIndexEntries(idx *index.Index, repoPath, worktreePath string, verify staleEntryVerifier) {
+	for _, entry := range idx.Entries {
+		if entry.Stage != 0 || entry.SkipWorktree || entry.IntentToAdd || entry.Mode == filemode.Submodule {
+			continue
+		}
+
+		name := filepath.FromSlash(entry.Name)
+		dstPath := filepath.Join(worktreePath, name)
+		dstInfo, err := os.Lstat(dstPath)
+		if err != nil {
+			continue
+		}
+		srcInfo, err := os.Lstat(filepath.Join(repoPath, name))
+		if err == nil && entryMatchesStat(entry, srcInfo) {
+			if dstInfo.Size() != srcInfo.Size() || dstInfo.Mode() != srcInfo.Mode() {
+				continue
+			}
+		} else if verify == nil || !dstInfo.Mode().IsRegular() || !verify(entry, dstPath, dstInfo) {
+			continue
+		}
+		stat, ok := indexStat(dstInfo)
+		if !ok {
+			continue
+		}
+
+		entry.CreatedAt = stat.ctime
+		entry.ModifiedAt = dstInfo.ModTime()
+		entry.Dev = stat.dev
+		entry.Inode = stat.ino
+		entry.UID = stat.uid
+		entry.GID = stat.gid
+		entry.Size = uint32(dstInfo.Size())
+	}
+}
+
+// entryMatchesStat reports whether an index entry's cached stat data describes info,
+// meaning git considers the file unchanged since it was last hashed
+func entryMatchesStat(entry *index.Entry, info os.FileInfo) bool {
+	stat, ok := indexStat(info)
+	if !ok {
+		return false
+	}
+	return sameIndexTime(entry.ModifiedAt, info.ModTime()) &&
+		sameIndexTime(entry.CreatedAt, stat.ctime) &&
+		entry.Size == uint32(info.Size()) &&
+		entry.Inode == stat.ino &&
+		entry.Dev == stat.dev
+}
+
+// sameIndexTime compares a cached index timestamp with a file time. Git built
+// without nanosecond support stores zero nanoseconds, which only compares seconds.
+func sameIndexTime(cached, actual time.Time) bool {
+	if cached.Unix() != actual.Unix() {
+		return false
+	}
+	return cached.Nanosecond() == 0 || cached.Nanosecond() == actual.Nanosecond()
+}
@@ -0,0 +1,105 @@
+package cowgit
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+)
+
+func TestWorktreeGetsRefreshedIndex(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "coworktree-index-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	repoDir := filepath.Join(tempDir, "repo")
+	if err := os.MkdirAll(filepath.Join(repoDir, "src", "pkg"), 0755); err != nil {
+		t.Fatalf("Failed to create repo dir: %v", err)
+	}
+	setupGitRepo(t, repoDir)
+
+	tracked := map[string]string{
+		"src/main.go":      "package main\n",
+		"src/pkg/lib.go":   "package pkg\n",
+		"src/pkg/notes.md": "notes\n",
+		"dirty.txt":        "committed\n",
+	}
+	for name, content := range tracked {
+		if err := os.WriteFile(filepath.Join(repoDir, filepath.FromSlash(name)), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+	if err := runCommand(repoDir, "git", "add", "."); err != nil {
+		t.Fatalf("Failed to add files: %v", err)
+	}
+	if err := runCommand(repoDir, "git", "commit", "-m", "Add sources"); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+
+	// An unstaged change in the source must still show up in the worktree
+	if err := os.WriteFile(filepath.Join(repoDir, "dirty.txt"), []byte("changed\n"), 0644); err != nil {
+		t.Fatalf("Failed to modify dirty.txt: %v", err)
+	}
+	if err := runCommand(repoDir, "git", "update-index", "--refresh"); err == nil {
+		t.Fatal("Expected update-index to report dirty.txt as modified")
+	}
+
+	worktreePath := filepath.Join(tempDir, "index-wt")
+	worktree := NewWorktree(repoDir, worktreePath, "index-branch")
+	worktree.Backend = BackendCopy
+
+	if err := worktree.CreateCoWWorktree(); err != nil {
+		t.Fatalf("Failed to create worktree: %v", err)
+	}
+	if worktree.Backend != BackendCopy {
+		t.Fatalf("Worktree.Backend = %s, want %s (creation fell back)", worktree.Backend, BackendCopy)
+	}
+
+	gitDir, err := resolveWorktreeGitDir(worktreePath)
+	if err != nil {
+		t.Fatalf("Failed to resolve worktree git dir: %v", err)
+	}
+	file, err := os.Open(filepath.Join(gitDir, "index"))
+	if err != nil {
+		t.Fatalf("Worktree has no index: %v", err)
+	}
+	idx := &index.Index{}
+	err = index.NewDecoder(file).Decode(idx)
+	file.Close()
+	if err != nil {
+		t.Fatalf("Failed to decode worktree index: %v", err)
+	}
+
+	// Clean entries must describe the worktree's files before git has looked at them
+	for _, entry := range idx.Entries {
+		info, err := os.Lstat(filepath.Join(worktreePath, filepath.FromSlash(entry.Name)))
+		if err != nil {
+			t.Fatalf("Indexed file %s missing from worktree: %v", entry.Name, err)
+		}
+		stat, ok := indexStat(info)
+		if !ok {
+			t.Skip("stat data not available on this platform")
+		}
+		refreshed := entry.Inode == stat.ino
+		if entry.Name == "dirty.txt" && refreshed {
+			t.Error("Stale entry dirty.txt was refreshed")
+		} else if entry.Name != "dirty.txt" && !refreshed {
+			t.Errorf("Entry %s was not refreshed for the worktree", entry.Name)
+		}
+	}
+
+	cmd := exec.Command("git", "status", "--porcelain")
+	cmd.Dir = worktreePath
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git status failed in worktree: %v", err)
+	}
+	if status := strings.TrimSpace(string(output)); status != "M dirty.txt" {
+		t.Errorf("git status = %q, want only dirty.txt modified", status)
+	}
+}
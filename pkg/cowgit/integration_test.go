@@ -7,13 +7,13 @@ import (
 )
 
 func TestCoWIntegration(t *testing.T) {
-	// Skip if not on APFS
-	result, err := isAPFS(".")
+	// Skip if not on a CoW-capable filesystem
+	result, err := IsCoWSupported(".")
 	if err != nil {
 		t.Fatalf("Failed to check filesystem: %v", err)
 	}
 	if !result {
-		t.Skip("Skipping CoW integration test - not on APFS filesystem")
+		t.Skip("Skipping CoW integration test - filesystem does not support copy-on-write")
 	}
 
 	// Create temporary directories
@@ -0,0 +1,119 @@
+package cowgit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+)
+
+// lfsPointerVersion is the first line of every Git LFS pointer file
+const lfsPointerVersion = "version https://git-lfs.github.com/spec/v1"
+
+// lfsPointer is the object a Git LFS pointer file refers to
+type lfsPointer struct {
+	oid  string // hex SHA-256 of the content
+	size int64
+}
+
+// parseLFSPointer parses the content of a Git LFS pointer file
+func parseLFSPointer(data []byte) (lfsPointer, bool) {
+	var pointer lfsPointer
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) < 3 || lines[0] != lfsPointerVersion {
+		return pointer, false
+	}
+	pointer.size = -1
+	for _, line := range lines[1:] {
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "oid":
+			pointer.oid, _ = strings.CutPrefix(value, "sha256:")
+		case "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return pointer, false
+			}
+			pointer.size = size
+		}
+	}
+	return pointer, len(pointer.oid) == sha256.Size*2 && pointer.size >= 0
+}
+
+// lfsVerifier confirms stale index entries of Git LFS files in the checkout at
+// dir. The index records each file's pointer while the checkout holds the
+// smudged content, so the first git status in the new worktree would run every
+// stale one back through the LFS clean filter. Hashing the file against its
+// pointer here settles it once, without git-lfs and without fetching anything.
+// The LFS paths are only looked up if an entry is stale.
+func (w *Worktree) lfsVerifier(dir string) staleEntryVerifier {
+	var paths map[string]bool
+	return func(entry *index.Entry, path string, info os.FileInfo) bool {
+		if paths == nil {
+			paths = make(map[string]bool)
+			// Attribute pathspecs match what .gitattributes assigns to the lfs filter
+			output, _ := w.runGitCommand(dir, "ls-files", "-z", "--", ":(attr:filter=lfs)")
+			for _, name := range strings.Split(string(output), "\x00") {
+				if name != "" {
+					paths[name] = true
+				}
+			}
+		}
+		if !paths[entry.Name] {
+			return false
+		}
+		blob, err := w.runGitCommand(dir, "cat-file", "blob", entry.Hash.String())
+		if err != nil {
+			return false
+		}
+		return lfsFileMatches(blob, path, info)
+	}
+}
+
+// lfsFileMatches reports whether the file at path holds the content the pointer
+// blob refers to, or the pointer itself, which the clean filter leaves as it is
+func lfsFileMatches(blob []byte, path string, info os.FileInfo) bool {
+	if info.Size() == int64(len(blob)) {
+		if content, err := os.ReadFile(path); err == nil && bytes.Equal(content, blob) {
+			return true
+		}
+	}
+
+	pointer, ok := parseLFSPointer(blob)
+	if !ok || pointer.size != info.Size() {
+		return false
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return false
+	}
+	return hex.EncodeToString(hash.Sum(nil)) == pointer.oid
+}
+
+// lfsStorageDir returns the Git LFS object cache of the repository whose common
+// git directory is commonDir, given its lfs.storage setting, or an empty
+// string if it has none
+func lfsStorageDir(commonDir, setting string) string {
+	dir := setting
+	if dir == "" {
+		dir = "lfs"
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(commonDir, dir)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return ""
+	}
+	return dir
+}
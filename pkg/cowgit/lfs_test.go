@@ -0,0 +1,223 @@
+package cowgit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lfsCleanScript and lfsSmudgeScript stand in for the Git LFS filters, so
+// tests need neither git-lfs nor a server. Objects are kept where git-lfs keeps
+// them, under lfs/objects in the common git directory, and every filter run
+// is logged; a smudge whose object is missing logs a download and fails.
+const lfsCleanScript = `#!/bin/sh
+tmp=$(mktemp)
+cat > "$tmp"
+oid=$(sha256sum "$tmp" | cut -d' ' -f1)
+size=$(wc -c < "$tmp" | tr -d ' ')
+dir="$(git rev-parse --git-common-dir)/lfs/objects/$(echo "$oid" | cut -c1-2)/$(echo "$oid" | cut -c3-4)"
+mkdir -p "$dir" && mv "$tmp" "$dir/$oid"
+echo "clean $1" >> "$LFS_LOG"
+printf 'version https://git-lfs.github.com/spec/v1\noid sha256:%s\nsize %s\n' "$oid" "$size"
+`
+
+const lfsSmudgeScript = `#!/bin/sh
+oid=$(sed -n 's/^oid sha256://p')
+object="$(git rev-parse --git-common-dir)/lfs/objects/$(echo "$oid" | cut -c1-2)/$(echo "$oid" | cut -c3-4)/$oid"
+if [ ! -f "$object" ]; then
+	echo "download $1" >> "$LFS_LOG"
+	exit 1
+fi
+echo "smudge $1" >> "$LFS_LOG"
+cat "$object"
+`
+
+// setupLFSRepo creates a repository whose *.bin files go through the LFS
+// stand-in and returns the filter log
+func setupLFSRepo(t *testing.T, repoDir string) string {
+	t.Helper()
+	setupCompatRepo(t, repoDir)
+	if _, err := exec.LookPath("sha256sum"); err != nil {
+		t.Skip("sha256sum not available for the LFS stand-in")
+	}
+
+	scripts := t.TempDir()
+	for name, script := range map[string]string{"clean": lfsCleanScript, "smudge": lfsSmudgeScript} {
+		if err := os.WriteFile(filepath.Join(scripts, name), []byte(script), 0755); err != nil {
+			t.Fatalf("Failed to write %s script: %v", name, err)
+		}
+	}
+	log := filepath.Join(scripts, "filter.log")
+	t.Setenv("LFS_LOG", log)
+
+	files := map[string]string{
+		".gitattributes": "*.bin filter=lfs diff=lfs merge=lfs -text\n",
+		"model.bin":      strings.Repeat("weights ", 4096),
+		"data.bin":       strings.Repeat("samples ", 4096),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(repoDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	commands := [][]string{
+		{"config", "filter.lfs.clean", filepath.Join(scripts, "clean") + " %f"},
+		{"config", "filter.lfs.smudge", filepath.Join(scripts, "smudge") + " %f"},
+		{"config", "filter.lfs.required", "true"},
+		{"add", "."},
+		{"commit", "-q", "-m", "Add LFS files"},
+	}
+	for _, args := range commands {
+		if output, err := exec.Command("git", append([]string{"-C", repoDir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, output)
+		}
+	}
+	return log
+}
+
+// lfsObjectPath returns where the stand-in keeps an object for content
+func lfsObjectPath(commonDir, content string) string {
+	sum := sha256.Sum256([]byte(content))
+	oid := hex.EncodeToString(sum[:])
+	return filepath.Join(commonDir, "lfs", "objects", oid[:2], oid[2:4], oid)
+}
+
+func TestCreateWithLFS(t *testing.T) {
+	tempDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to resolve temp dir: %v", err)
+	}
+	repoDir := filepath.Join(tempDir, "repo")
+	log := setupLFSRepo(t, repoDir)
+
+	// Files written in the same second as the index are racily clean, and git
+	// rehashes them however fresh their stat data is, so backdate them first
+	earlier := time.Now().Add(-time.Minute)
+	for _, name := range []string{"model.bin", "data.bin"} {
+		if err := os.Chtimes(filepath.Join(repoDir, name), earlier, earlier); err != nil {
+			t.Fatalf("Failed to backdate %s: %v", name, err)
+		}
+	}
+	if err := runCommand(repoDir, "git", "update-index", "-q", "--refresh"); err != nil {
+		t.Fatalf("git update-index failed: %v", err)
+	}
+
+	// Rewriting a file with the same content leaves its index entry stale, as
+	// git lfs pull does; git status in the source would run it through the
+	// clean filter again
+	data, err := os.ReadFile(filepath.Join(repoDir, "data.bin"))
+	if err != nil {
+		t.Fatalf("Failed to read data.bin: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repoDir, "data.bin"), data, 0644); err != nil {
+		t.Fatalf("Failed to rewrite data.bin: %v", err)
+	}
+	if err := os.Chtimes(filepath.Join(repoDir, "data.bin"), earlier, earlier); err != nil {
+		t.Fatalf("Failed to backdate data.bin: %v", err)
+	}
+	os.Remove(log)
+
+	worktree := NewWorktreeWithOptions(repoDir, filepath.Join(tempDir, "wt"), "with-lfs", true)
+	worktree.Backend = BackendCopy
+	worktree.Fallback = FallbackCopy
+	if err := worktree.CreateCoWWorktree(); err != nil {
+		t.Fatalf("CreateCoWWorktree failed: %v", err)
+	}
+
+	if status := gitStatus(t, worktree.WorktreePath); status != "" {
+		t.Errorf("worktree is not clean:\n%s", status)
+	}
+	if content, _ := os.ReadFile(filepath.Join(worktree.WorktreePath, "data.bin")); string(content) != string(data) {
+		t.Error("data.bin is not the smudged content")
+	}
+	// Neither creating the worktree nor its first status ran the filters
+	if runs, err := os.ReadFile(log); err == nil {
+		t.Errorf("LFS filters ran:\n%s", runs)
+	}
+
+	// The worktree uses the source's object cache: a new LFS file added there
+	// lands in it, and the source can check it out without downloading
+	commonDir := revParse(t, worktree.WorktreePath, "--path-format=absolute", "--git-common-dir")
+	if commonDir != filepath.Join(repoDir, ".git") {
+		t.Errorf("worktree common dir = %s", commonDir)
+	}
+	if err := os.WriteFile(filepath.Join(worktree.WorktreePath, "new.bin"), []byte("new weights"), 0644); err != nil {
+		t.Fatalf("Failed to write new.bin: %v", err)
+	}
+	if err := runCommand(worktree.WorktreePath, "git", "add", "new.bin"); err != nil {
+		t.Fatalf("git add failed: %v", err)
+	}
+	if _, err := os.Stat(lfsObjectPath(commonDir, "new weights")); err != nil {
+		t.Errorf("new LFS object not in the shared cache: %v", err)
+	}
+	if err := runCommand(worktree.WorktreePath, "git", "commit", "-q", "-m", "Add new.bin"); err != nil {
+		t.Fatalf("git commit failed: %v", err)
+	}
+	if output, err := exec.Command("git", "-C", repoDir, "checkout", "-q", "with-lfs", "--", "new.bin").CombinedOutput(); err != nil {
+		t.Errorf("checkout in the source failed: %v: %s", err, output)
+	}
+	if runs, _ := os.ReadFile(log); strings.Contains(string(runs), "download") {
+		t.Errorf("LFS object was downloaded:\n%s", runs)
+	}
+}
+
+func TestSubmoduleSharesLFSStorage(t *testing.T) {
+	tempDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to resolve temp dir: %v", err)
+	}
+	repoDir := setupSubmoduleRepo(t, tempDir)
+	storage := filepath.Join(repoDir, ".git", "modules", "libs", "lib", "lfs")
+	if err := os.MkdirAll(filepath.Join(storage, "objects"), 0755); err != nil {
+		t.Fatalf("Failed to create LFS storage: %v", err)
+	}
+
+	worktree := NewWorktreeWithOptions(repoDir, filepath.Join(tempDir, "wt"), "lfs-submodule", true)
+	worktree.Backend = BackendCopy
+	worktree.Fallback = FallbackCopy
+	if err := worktree.CreateCoWWorktree(); err != nil {
+		t.Fatalf("CreateCoWWorktree failed: %v", err)
+	}
+
+	// The submodule's new git directory would otherwise start with an empty cache
+	output, _ := exec.Command("git", "-C", filepath.Join(worktree.WorktreePath, "libs", "lib"), "config", "lfs.storage").Output()
+	if got := strings.TrimSpace(string(output)); got != storage {
+		t.Errorf("lib lfs.storage = %q, want the source's %s", got, storage)
+	}
+	// A submodule without LFS objects is left alone
+	output, _ = exec.Command("git", "-C", filepath.Join(worktree.WorktreePath, "libs", "lib", "deep"), "config", "lfs.storage").Output()
+	if got := strings.TrimSpace(string(output)); got != "" {
+		t.Errorf("deep lfs.storage = %q, want it unset", got)
+	}
+}
+
+func TestParseLFSPointer(t *testing.T) {
+	oid := strings.Repeat("ab", 32)
+	tests := []struct {
+		name string
+		data string
+		want lfsPointer
+		ok   bool
+	}{
+		{"pointer", fmt.Sprintf("%s\noid sha256:%s\nsize 12\n", lfsPointerVersion, oid), lfsPointer{oid, 12}, true},
+		{"extension lines", fmt.Sprintf("%s\next-0-foo sha256:%s\noid sha256:%s\nsize 0\n", lfsPointerVersion, oid, oid), lfsPointer{oid, 0}, true},
+		{"other version", fmt.Sprintf("version 2\noid sha256:%s\nsize 12\n", oid), lfsPointer{}, false},
+		{"short oid", lfsPointerVersion + "\noid sha256:abcd\nsize 12\n", lfsPointer{}, false},
+		{"missing size", fmt.Sprintf("%s\noid sha256:%s\nx y\n", lfsPointerVersion, oid), lfsPointer{}, false},
+		{"content", "weights weights weights", lfsPointer{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseLFSPointer([]byte(tt.data))
+			if ok != tt.ok || (ok && got != tt.want) {
+				t.Errorf("parseLFSPointer = %+v, %t; want %+v, %t", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
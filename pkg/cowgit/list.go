@@ -0,0 +1,203 @@
+package cowgit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WorktreeInfo represents information about a git worktree
+type WorktreeInfo struct {
+	Path   string `json:"path"`
+	Branch string `json:"branch,omitempty"`
+	HEAD   string `json:"head,omitempty"`
+	// ID is the worktree's directory under .git/worktrees; empty for the main worktree
+	ID string `json:"id,omitempty"`
+	// Bare is set for the main worktree of a bare repository, and Detached for
+	// a worktree whose HEAD is not a branch
+	Bare     bool `json:"bare,omitempty"`
+	Detached bool `json:"detached,omitempty"`
+	// Locked is set when the worktree is locked with git worktree lock
+	Locked     bool   `json:"locked,omitempty"`
+	LockReason string `json:"lock_reason,omitempty"`
+	// Prunable is set when git worktree prune would remove the worktree's
+	// registration, usually because its directory is gone
+	Prunable       bool   `json:"prunable,omitempty"`
+	PrunableReason string `json:"prunable_reason,omitempty"`
+	// Config holds the worktree's own settings from its config.worktree, when
+	// extensions.worktreeConfig is enabled
+	Config map[string]string `json:"config,omitempty"`
+
+	// Backend, Created and BaseCommit come from coworktree's metadata. A linked
+	// worktree without any was made by git worktree add, so its Backend is
+	// FallbackGit, as add reports it, and its BaseCommit is empty. Created
+	// falls back to when git registered the worktree.
+	Backend    string    `json:"backend,omitempty"`
+	Created    time.Time `json:"created,omitzero"`
+	BaseCommit string    `json:"base_commit,omitempty"`
+
+	// Upstream, Dirty, Ahead and Behind are filled in by ListOptions.Status:
+	// the branch's upstream, the number of changed and untracked paths, and
+	// the commits HEAD is ahead of and behind the upstream
+	Upstream string `json:"upstream,omitempty"`
+	Dirty    int    `json:"dirty"`
+	Ahead    int    `json:"ahead"`
+	Behind   int    `json:"behind"`
+	// DiskUsage is filled in by ListOptions.DiskUsage for linked worktrees:
+	// the bytes freed by removing it (see Worktree.DiskUsage)
+	DiskUsage int64 `json:"disk_usage"`
+}
+
+// ListOptions selects the details of ListWorktreesWithOptions that need more
+// than git's worktree list
+type ListOptions struct {
+	// Status runs git status in each worktree
+	Status bool
+	// DiskUsage measures each linked worktree's disk usage, walking all of its
+	// files; it is slow for large worktrees, so callers ask for it
+	DiskUsage bool
+}
+
+// ListWorktrees returns a list of all worktrees in the repository
+func ListWorktrees(repoPath string) ([]WorktreeInfo, error) {
+	return ListWorktreesWithOptions(repoPath, ListOptions{})
+}
+
+// ListWorktreesWithOptions returns all worktrees in the repository, the main
+// worktree first, with the details selected by opts
+func ListWorktreesWithOptions(repoPath string, opts ListOptions) ([]WorktreeInfo, error) {
+	repo, err := DiscoverRepository(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	output, err := repo.gitCommand("worktree", "list", "--porcelain", "-z").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list worktrees: %w", err)
+	}
+	worktrees := parseWorktreeList(string(output))
+
+	// The first entry is always the main worktree, which has no id
+	for i := 1; i < len(worktrees); i++ {
+		worktrees[i].ID = worktreeIDForPath(repo.CommonDir, worktrees[i].Path)
+	}
+
+	worktreeConfig := false
+	if enabled, _ := repo.gitCommand("config", "--bool", "--get", "extensions.worktreeConfig").Output(); strings.TrimSpace(string(enabled)) == "true" {
+		worktreeConfig = true
+	}
+	for i := range worktrees {
+		wt := &worktrees[i]
+		gitDir := repo.CommonDir
+		if i > 0 {
+			gitDir = filepath.Join(repo.CommonDir, "worktrees", wt.ID)
+			wt.readMetadata(gitDir)
+		}
+		if worktreeConfig {
+			wt.Config = readWorktreeConfig(gitDir)
+		}
+
+		if wt.Bare || wt.Prunable {
+			continue
+		}
+		if opts.Status {
+			if err := wt.readStatus(); err != nil {
+				return nil, err
+			}
+		}
+		if opts.DiskUsage && i > 0 {
+			if usage, err := NewWorktree(repo.Root, wt.Path, wt.Branch).DiskUsage(); err == nil {
+				wt.DiskUsage = usage
+			}
+		}
+	}
+
+	return worktrees, nil
+}
+
+// parseWorktreeList parses git worktree list --porcelain -z, where each
+// attribute ends with a NUL and each worktree with an extra one
+func parseWorktreeList(output string) []WorktreeInfo {
+	var worktrees []WorktreeInfo
+	var current WorktreeInfo
+	for _, line := range strings.Split(output, "\x00") {
+		name, value, _ := strings.Cut(line, " ")
+		switch name {
+		case "worktree":
+			if current.Path != "" {
+				worktrees = append(worktrees, current)
+			}
+			current = WorktreeInfo{Path: value}
+		case "HEAD":
+			current.HEAD = value
+		case "branch":
+			current.Branch = strings.TrimPrefix(value, "refs/heads/")
+		case "bare":
+			current.Bare = true
+		case "detached":
+			current.Detached = true
+		case "locked":
+			current.Locked = true
+			current.LockReason = value
+		case "prunable":
+			current.Prunable = true
+			current.PrunableReason = value
+		}
+	}
+	if current.Path != "" {
+		worktrees = append(worktrees, current)
+	}
+	return worktrees
+}
+
+// readMetadata fills in what coworktree recorded when it created the
+// worktree, and otherwise when git registered it
+func (wt *WorktreeInfo) readMetadata(gitDir string) {
+	if meta, err := readWorktreeMetadata(gitDir); err == nil {
+		wt.Backend = meta.Backend
+		wt.Created = meta.Created
+		wt.BaseCommit = meta.BaseCommit
+	} else {
+		wt.Backend = FallbackGit
+	}
+	if wt.Created.IsZero() {
+		// git writes commondir once, when the worktree is added
+		if info, err := os.Stat(filepath.Join(gitDir, "commondir")); err == nil {
+			wt.Created = info.ModTime().UTC()
+		}
+	}
+}
+
+// readStatus fills in Upstream, Dirty, Ahead and Behind from git status
+func (wt *WorktreeInfo) readStatus() error {
+	output, err := gitCommand(wt.Path, "status", "--porcelain=v2", "--branch", "-z").Output()
+	if err != nil {
+		return fmt.Errorf("failed to get status of %s: %w", wt.Path, err)
+	}
+
+	entries := strings.Split(strings.TrimSuffix(string(output), "\x00"), "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		switch {
+		case entry == "":
+		case strings.HasPrefix(entry, "# branch.upstream "):
+			wt.Upstream = strings.TrimPrefix(entry, "# branch.upstream ")
+		case strings.HasPrefix(entry, "# branch.ab "):
+			fields := strings.Fields(strings.TrimPrefix(entry, "# branch.ab "))
+			if len(fields) == 2 {
+				wt.Ahead, _ = strconv.Atoi(strings.TrimPrefix(fields[0], "+"))
+				wt.Behind, _ = strconv.Atoi(strings.TrimPrefix(fields[1], "-"))
+			}
+		case strings.HasPrefix(entry, "#"):
+		default:
+			wt.Dirty++
+			// Renames and copies are followed by their original path
+			if strings.HasPrefix(entry, "2 ") {
+				i++
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package cowgit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListWorktreesWithOptions(t *testing.T) {
+	tempDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to resolve temp dir: %v", err)
+	}
+	repoDir := filepath.Join(tempDir, "repo")
+	setupCompatRepo(t, repoDir)
+	head := revParse(t, repoDir, "HEAD")
+
+	manager, err := NewManager(repoDir)
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	create := func(opts CreateOptions) string {
+		t.Helper()
+		opts.WorktreePath = filepath.Join(tempDir, opts.BranchName)
+		opts.Backend = BackendCopy
+		opts.Fallback = FallbackCopy
+		if _, err := manager.Create(opts); err != nil {
+			t.Fatalf("Create %s failed: %v", opts.BranchName, err)
+		}
+		return opts.WorktreePath
+	}
+	start := time.Now().Add(-time.Second)
+
+	// One changed and one untracked path, two commits ahead of its upstream
+	// and one behind it
+	feature := create(CreateOptions{BranchName: "feature"})
+	if err := os.WriteFile(filepath.Join(feature, "test.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatalf("Failed to modify test.txt: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(feature, "scratch.txt"), []byte("notes"), 0644); err != nil {
+		t.Fatalf("Failed to write scratch.txt: %v", err)
+	}
+	for _, args := range [][]string{
+		{"git", "branch", "upstream", head},
+		{"git", "branch", "--set-upstream-to=upstream", "feature"},
+		{"git", "commit", "-q", "--allow-empty", "-m", "one"},
+		{"git", "commit", "-q", "--allow-empty", "-m", "two"},
+	} {
+		if err := runCommand(feature, args[0], args[1:]...); err != nil {
+			t.Fatalf("%v failed: %v", args, err)
+		}
+	}
+	if err := runCommand(repoDir, "git", "commit", "-q", "--allow-empty", "-m", "upstream"); err != nil {
+		t.Fatalf("git commit failed: %v", err)
+	}
+	if err := runCommand(repoDir, "git", "branch", "-f", "upstream", "HEAD"); err != nil {
+		t.Fatalf("git branch failed: %v", err)
+	}
+
+	detached := create(CreateOptions{BranchName: "detached", Detach: true})
+	if err := runCommand(repoDir, "git", "worktree", "lock", "--reason", "agent running", detached); err != nil {
+		t.Fatalf("git worktree lock failed: %v", err)
+	}
+	gone := create(CreateOptions{BranchName: "gone"})
+	if err := os.RemoveAll(gone); err != nil {
+		t.Fatalf("Failed to delete worktree: %v", err)
+	}
+
+	plain := filepath.Join(tempDir, "plain")
+	if err := runCommand(repoDir, "git", "worktree", "add", "-q", plain); err != nil {
+		t.Fatalf("git worktree add failed: %v", err)
+	}
+
+	infos, err := ListWorktreesWithOptions(repoDir, ListOptions{Status: true, DiskUsage: true})
+	if err != nil {
+		t.Fatalf("ListWorktreesWithOptions failed: %v", err)
+	}
+	if len(infos) != 5 {
+		t.Fatalf("ListWorktreesWithOptions returned %d worktrees, want 5: %+v", len(infos), infos)
+	}
+	byPath := make(map[string]WorktreeInfo)
+	for _, info := range infos {
+		byPath[info.Path] = info
+	}
+
+	main := infos[0]
+	if main.Path != repoDir || main.Backend != "" || main.DiskUsage != 0 || main.Dirty != 0 {
+		t.Errorf("main worktree = %+v", main)
+	}
+
+	info := byPath[feature]
+	if info.Backend != BackendCopy || info.BaseCommit != head {
+		t.Errorf("feature metadata: backend %q, base %q; want %q, %q", info.Backend, info.BaseCommit, BackendCopy, head)
+	}
+	if info.Created.Before(start) || info.Created.After(time.Now()) {
+		t.Errorf("feature created at %v, want after %v", info.Created, start)
+	}
+	if info.Upstream != "upstream" {
+		t.Errorf("feature upstream = %q", info.Upstream)
+	}
+	if info.Dirty != 2 || info.Ahead != 2 || info.Behind != 1 {
+		t.Errorf("feature status: dirty %d, ahead %d, behind %d; want 2, 2, 1", info.Dirty, info.Ahead, info.Behind)
+	}
+	if info.DiskUsage <= 0 {
+		t.Errorf("feature disk usage = %d", info.DiskUsage)
+	}
+
+	info = byPath[detached]
+	if !info.Detached || info.Branch != "" || !info.Locked || info.LockReason != "agent running" {
+		t.Errorf("detached worktree = %+v", info)
+	}
+	if info.Dirty != 0 || info.Created.IsZero() {
+		t.Errorf("detached worktree status = %+v", info)
+	}
+
+	info = byPath[plain]
+	if info.Backend != FallbackGit || info.BaseCommit != "" {
+		t.Errorf("git worktree add metadata: backend %q, base %q; want %q, none", info.Backend, info.BaseCommit, FallbackGit)
+	}
+
+	info = byPath[gone]
+	if !info.Prunable || info.PrunableReason == "" {
+		t.Errorf("deleted worktree is not prunable: %+v", info)
+	}
+}
+
+func TestParseWorktreeList(t *testing.T) {
+	output := "worktree /repo.git\x00bare\x00\x00" +
+		"worktree /wt\x00HEAD abc\x00branch refs/heads/topic\x00locked\x00prunable gitdir file points to non-existent location\x00\x00"
+	infos := parseWorktreeList(output)
+	if len(infos) != 2 {
+		t.Fatalf("parseWorktreeList returned %d worktrees: %+v", len(infos), infos)
+	}
+	if !infos[0].Bare || infos[0].Path != "/repo.git" {
+		t.Errorf("bare worktree = %+v", infos[0])
+	}
+	wt := infos[1]
+	if wt.Branch != "topic" || wt.HEAD != "abc" || !wt.Locked || wt.LockReason != "" || !wt.Prunable || wt.PrunableReason != "gitdir file points to non-existent location" {
+		t.Errorf("linked worktree = %+v", wt)
+	}
+}
@@ -0,0 +1,193 @@
+package cowgit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Repository lock files live in the common git directory, shared by every
+// worktree of the repository
+const (
+	// lockFileName serializes changes to refs, config and .git/worktrees
+	lockFileName = "coworktree.lock"
+	// pendingDirName holds a reservation for each worktree being created
+	pendingDirName = "coworktree-pending"
+)
+
+// DefaultLockTimeout is how long to wait for another coworktree process to
+// release the repository lock when LockTimeout is zero
+const DefaultLockTimeout = time.Minute
+
+// lockPollInterval is how often a waiting process retries the lock
+const lockPollInterval = 20 * time.Millisecond
+
+// ErrLockTimeout is returned when the repository lock is still held by another
+// process after the lock timeout
+var ErrLockTimeout = errors.New("timed out waiting for the repository lock")
+
+// errLockHeld is returned by tryLockFile when another open file holds the lock
+var errLockHeld = errors.New("lock is held")
+
+// repoLock is an advisory lock on the repository, taken around every change to
+// refs, config and worktree metadata so concurrent coworktree processes don't
+// race. It can be released and reacquired, leaving slow work such as cloning
+// outside the lock.
+type repoLock struct {
+	file *os.File
+	held bool
+}
+
+// openRepoLock opens the lock file in commonDir without taking the lock
+func openRepoLock(commonDir string) (*repoLock, error) {
+	file, err := os.OpenFile(filepath.Join(commonDir, lockFileName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository lock: %w", err)
+	}
+	return &repoLock{file: file}, nil
+}
+
+// acquire takes the lock, waiting up to timeout for other processes to release it
+func (l *repoLock) acquire(timeout time.Duration) error {
+	if l.held {
+		return nil
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err := tryLockFile(l.file)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errLockHeld) {
+			return fmt.Errorf("failed to lock %s: %w", l.file.Name(), err)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w %s after %v%s", ErrLockTimeout, l.file.Name(), timeout, l.holder())
+		}
+		time.Sleep(lockPollInterval)
+	}
+	l.held = true
+
+	// Record the holder so a timed out process can say who it waited for
+	if err := l.file.Truncate(0); err == nil {
+		l.file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return nil
+}
+
+// holder describes the process that last took the lock, if it is known
+func (l *repoLock) holder() string {
+	content := make([]byte, 32)
+	n, _ := l.file.ReadAt(content, 0)
+	if pid := strings.TrimSpace(string(content[:n])); pid != "" {
+		return fmt.Sprintf(" (held by pid %s)", pid)
+	}
+	return ""
+}
+
+// release lets other processes take the lock
+func (l *repoLock) release() error {
+	if !l.held {
+		return nil
+	}
+	l.held = false
+	if err := unlockFile(l.file); err != nil {
+		return fmt.Errorf("failed to unlock %s: %w", l.file.Name(), err)
+	}
+	return nil
+}
+
+// close releases the lock and closes the lock file
+func (l *repoLock) close() error {
+	err := l.release()
+	l.file.Close()
+	return err
+}
+
+// lockTimeout returns LockTimeout, or DefaultLockTimeout if it isn't set
+func (w *Worktree) lockTimeout() time.Duration {
+	if w.LockTimeout > 0 {
+		return w.LockTimeout
+	}
+	return DefaultLockTimeout
+}
+
+// lockRepository opens and takes the repository lock for the source repository
+func (w *Worktree) lockRepository() (*repoLock, error) {
+	if _, err := w.repository(); err != nil {
+		return nil, err
+	}
+	lock, err := openRepoLock(w.commonDir())
+	if err != nil {
+		return nil, err
+	}
+	if err := lock.acquire(w.lockTimeout()); err != nil {
+		lock.close()
+		return nil, err
+	}
+	return lock, nil
+}
+
+// targetReservation claims a target path while its worktree is cloned outside
+// the repository lock, so a concurrent creation can't pick the same path. The
+// reservation is itself flocked, so one left behind by a killed process is
+// recognized as stale.
+type targetReservation struct {
+	file *os.File
+}
+
+// reservationPath returns the reservation file for a canonical target path
+func reservationPath(commonDir, target string) string {
+	sum := sha256.Sum256([]byte(target))
+	return filepath.Join(commonDir, pendingDirName, hex.EncodeToString(sum[:8]))
+}
+
+// reserveTarget reserves target, which must be canonical; the repository lock
+// must be held
+func reserveTarget(commonDir, target string) (*targetReservation, error) {
+	path := reservationPath(commonDir, target)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve %s: %w", target, err)
+	}
+	if err := tryLockFile(file); err != nil {
+		file.Close()
+		if errors.Is(err, errLockHeld) {
+			return nil, fmt.Errorf("%w: %s is being created by another process", ErrTargetExists, target)
+		}
+		return nil, fmt.Errorf("failed to reserve %s: %w", target, err)
+	}
+	file.WriteAt([]byte(target+"\n"), 0)
+	return &targetReservation{file: file}, nil
+}
+
+// targetReserved reports whether another creation holds a reservation for target
+func targetReserved(commonDir, target string) bool {
+	file, err := os.Open(reservationPath(commonDir, target))
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	if err := tryLockFile(file); err != nil {
+		return errors.Is(err, errLockHeld)
+	}
+	unlockFile(file)
+	return false
+}
+
+// release drops the reservation; the repository lock must be held
+func (r *targetReservation) release() {
+	os.Remove(r.file.Name())
+	os.Remove(filepath.Dir(r.file.Name()))
+	unlockFile(r.file)
+	r.file.Close()
+}
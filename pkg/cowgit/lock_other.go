@@ -0,0 +1,16 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package cowgit
+
+import "os"
+
+// tryLockFile always succeeds: advisory locks need flock, so concurrent
+// creations aren't serialized on this platform
+func tryLockFile(f *os.File) error {
+	return nil
+}
+
+// unlockFile has nothing to release
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,166 @@
+package cowgit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentCreate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping stress test in short mode")
+	}
+
+	tempDir := t.TempDir()
+	repoDir := filepath.Join(tempDir, "repo")
+	setupCompatRepo(t, repoDir)
+
+	type request struct {
+		path, branch string
+	}
+	var requests []request
+	// Distinct paths with the same basename all compete for the "dup" id
+	for i := 0; i < 32; i++ {
+		requests = append(requests, request{filepath.Join(tempDir, fmt.Sprintf("agent%d", i), "dup"), fmt.Sprintf("agent-%d", i)})
+	}
+	// Only one creation may win a shared path, and only one a shared branch
+	for i := 0; i < 6; i++ {
+		requests = append(requests, request{filepath.Join(tempDir, "shared"), fmt.Sprintf("shared-%d", i)})
+		requests = append(requests, request{filepath.Join(tempDir, fmt.Sprintf("same%d", i)), "same"})
+	}
+
+	errs := make([]error, len(requests))
+	worktrees := make([]*Worktree, len(requests))
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, req := range requests {
+		worktree := NewWorktreeWithOptions(repoDir, req.path, req.branch, true)
+		worktree.Backend = BackendCopy
+		worktree.Fallback = FallbackCopy
+		worktrees[i] = worktree
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs[i] = worktrees[i].CreateCoWWorktree()
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	ids := make(map[string]string)
+	sharedWins, sameWins := 0, 0
+	for i, req := range requests {
+		switch {
+		case strings.HasPrefix(req.branch, "agent-"):
+			if errs[i] != nil {
+				t.Errorf("Create %s failed: %v", req.path, errs[i])
+				continue
+			}
+		case req.branch == "same":
+			if errs[i] == nil {
+				sameWins++
+			} else if !strings.Contains(errs[i].Error(), "already exists") {
+				t.Errorf("Create %s on the shared branch: unexpected error %v", req.path, errs[i])
+			}
+		default:
+			if errs[i] == nil {
+				sharedWins++
+			} else if !errors.Is(errs[i], ErrTargetExists) {
+				t.Errorf("Create on the shared path with %s: unexpected error %v", req.branch, errs[i])
+			}
+		}
+		if errs[i] != nil {
+			continue
+		}
+
+		if other, ok := ids[worktrees[i].ID]; ok {
+			t.Errorf("ID %s allocated to both %s and %s", worktrees[i].ID, other, req.path)
+		}
+		ids[worktrees[i].ID] = req.path
+		if branch := currentBranch(t, req.path); branch != req.branch {
+			t.Errorf("%s is on %q, want %q", req.path, branch, req.branch)
+		}
+		if status := gitStatus(t, req.path); status != "" {
+			t.Errorf("%s is not clean:\n%s", req.path, status)
+		}
+	}
+	if sharedWins != 1 || sameWins != 1 {
+		t.Errorf("shared path created %d times and shared branch %d times, want once each", sharedWins, sameWins)
+	}
+
+	// git agrees with what was created, and nothing is left locked or reserved
+	if output, err := exec.Command("git", "-C", repoDir, "worktree", "prune").CombinedOutput(); err != nil {
+		t.Fatalf("git worktree prune failed: %v: %s", err, output)
+	}
+	infos, err := ListWorktrees(repoDir)
+	if err != nil {
+		t.Fatalf("ListWorktrees failed: %v", err)
+	}
+	if want := len(ids) + 1; len(infos) != want {
+		t.Errorf("ListWorktrees returned %d worktrees, want %d", len(infos), want)
+	}
+	for id := range ids {
+		if _, err := os.Stat(filepath.Join(repoDir, ".git", "worktrees", id, lockedFileName)); !os.IsNotExist(err) {
+			t.Errorf("worktree %s is still locked", id)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(repoDir, ".git", pendingDirName)); !os.IsNotExist(err) {
+		t.Errorf("reservations left behind")
+	}
+	if output, err := exec.Command("git", "-C", repoDir, "fsck", "--no-progress").CombinedOutput(); err != nil {
+		t.Errorf("git fsck failed: %v: %s", err, output)
+	}
+}
+
+func TestLockTimeout(t *testing.T) {
+	tempDir := t.TempDir()
+	repoDir := filepath.Join(tempDir, "repo")
+	setupCompatRepo(t, repoDir)
+
+	// Another process holds the lock
+	held, err := openRepoLock(filepath.Join(repoDir, ".git"))
+	if err != nil {
+		t.Fatalf("openRepoLock failed: %v", err)
+	}
+	defer held.close()
+	if err := held.acquire(time.Second); err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+
+	worktree := NewWorktreeWithOptions(repoDir, filepath.Join(tempDir, "wt"), "waiting", true)
+	worktree.Backend = BackendCopy
+	worktree.Fallback = FallbackCopy
+	worktree.LockTimeout = 100 * time.Millisecond
+
+	started := time.Now()
+	err = worktree.CreateCoWWorktree()
+	if !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("CreateCoWWorktree error = %v, want ErrLockTimeout", err)
+	}
+	if waited := time.Since(started); waited < worktree.LockTimeout {
+		t.Errorf("gave up after %v, before the %v timeout", waited, worktree.LockTimeout)
+	}
+	if !strings.Contains(err.Error(), fmt.Sprintf("pid %d", os.Getpid())) {
+		t.Errorf("error %q doesn't name the holder", err)
+	}
+	if _, err := os.Stat(worktree.WorktreePath); !os.IsNotExist(err) {
+		t.Error("worktree created without the lock")
+	}
+
+	// Once the lock is released, a waiting creation goes ahead
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		held.release()
+	}()
+	worktree.LockTimeout = 10 * time.Second
+	if err := worktree.CreateCoWWorktree(); err != nil {
+		t.Fatalf("CreateCoWWorktree after release failed: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package cowgit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// discardLogger is used wherever no Logger is set
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// loggerOrDiscard returns logger, or discardLogger if it is nil
+func loggerOrDiscard(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return discardLogger
+	}
+	return logger
+}
+
+// logger returns the worktree's Logger, or discardLogger if it has none
+func (w *Worktree) logger() *slog.Logger {
+	return loggerOrDiscard(w.Logger)
+}
+
+// logCommand runs cmd with run, cmd.Output or cmd.CombinedOutput, and logs it
+// at debug level with its duration and, when it fails, its stderr
+func logCommand(logger *slog.Logger, cmd *exec.Cmd, run func() ([]byte, error)) ([]byte, error) {
+	start := time.Now()
+	output, err := run()
+	if !logger.Enabled(context.Background(), slog.LevelDebug) {
+		return output, err
+	}
+
+	attrs := []any{"args", cmd.Args[1:], "dir", cmd.Dir, "duration", time.Since(start)}
+	if err != nil {
+		var stderr []byte
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			stderr = exitErr.Stderr
+		} else if cmd.Stderr != nil && cmd.Stderr == cmd.Stdout {
+			// CombinedOutput interleaves stderr with the output
+			stderr = output
+		}
+		attrs = append(attrs, "error", err, "stderr", strings.TrimSpace(string(stderr)))
+	}
+	logger.Debug("git", attrs...)
+	return output, err
+}
+
+// logProbe logs at debug level whether a backend can clone a checkout
+func logProbe(logger *slog.Logger, backend string, supported bool, err error) {
+	if err != nil {
+		logger.Debug("probed backend", "backend", backend, "supported", supported, "error", err)
+		return
+	}
+	logger.Debug("probed backend", "backend", backend, "supported", supported)
+}
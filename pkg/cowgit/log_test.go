@@ -0,0 +1,66 @@
+package cowgit
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWorktreeLogger(t *testing.T) {
+	tempDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to resolve temp dir: %v", err)
+	}
+	repoDir := filepath.Join(tempDir, "repo")
+	setupCompatRepo(t, repoDir)
+
+	var buf bytes.Buffer
+	worktree := NewWorktree(repoDir, filepath.Join(tempDir, "logged"), "logged")
+	worktree.Backend = BackendCopy
+	worktree.Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	if err := worktree.CreateCoWWorktree(); err != nil {
+		t.Fatalf("CreateCoWWorktree failed: %v", err)
+	}
+	// A failing command is logged with its stderr
+	if _, err := worktree.runGitCommand(repoDir, "rev-parse", "--verify", "missing"); err == nil {
+		t.Fatal("rev-parse of a missing ref succeeded")
+	}
+
+	var commands, selected, failed int
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record struct {
+			Msg      string   `json:"msg"`
+			Args     []string `json:"args"`
+			Duration *int64   `json:"duration"`
+			Stderr   string   `json:"stderr"`
+			Backend  string   `json:"backend"`
+		}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Failed to parse log line %q: %v", line, err)
+		}
+		switch record.Msg {
+		case "git":
+			if len(record.Args) == 0 || record.Duration == nil {
+				t.Errorf("git record without args or duration: %s", line)
+			}
+			commands++
+			if strings.Join(record.Args, " ") == "rev-parse --verify missing" {
+				failed++
+				if !strings.Contains(record.Stderr, "fatal") {
+					t.Errorf("failed command logged without its stderr: %s", line)
+				}
+			}
+		case "selected backend":
+			selected++
+			if record.Backend != BackendCopy {
+				t.Errorf("selected backend = %q, want %s", record.Backend, BackendCopy)
+			}
+		}
+	}
+	if commands == 0 || selected != 1 || failed != 1 {
+		t.Errorf("logged %d git commands, %d backend selections and %d failures; want some, 1 and 1:\n%s", commands, selected, failed, buf.String())
+	}
+}
@@ -2,23 +2,31 @@ package cowgit
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
-	"path/filepath"
+	"time"
 )
 
 // Manager provides high-level operations for managing CoW worktrees
 type Manager struct {
 	RepoPath string
+	// LockTimeout is how long operations wait for the repository lock held by
+	// concurrent coworktree processes; zero means DefaultLockTimeout
+	LockTimeout time.Duration
+	// Logger is given to the worktrees the manager creates and removes; nil
+	// discards their logs
+	Logger *slog.Logger
 }
 
-// NewManager creates a new Manager for the given repository path
+// NewManager creates a new Manager for the repository containing repoPath,
+// which may be a subdirectory or a linked worktree
 func NewManager(repoPath string) (*Manager, error) {
-	// Verify it's a git repository
-	if _, err := os.Stat(filepath.Join(repoPath, ".git")); err != nil {
-		return nil, fmt.Errorf("not a git repository: %s", repoPath)
+	repo, err := DiscoverRepository(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("not a git repository: %w", err)
 	}
-	
-	return &Manager{RepoPath: repoPath}, nil
+
+	return &Manager{RepoPath: repo.Root}, nil
 }
 
 // CreateOptions holds options for creating a worktree
@@ -29,10 +37,45 @@ type CreateOptions struct {
 	NoCoW         bool
 	NoRewrite     bool
 	Prefix        string
+	// Backend names the CloneBackend to use; empty or BackendAuto picks one
+	Backend       string
+	// Fallback is FallbackGit (default) or FallbackCopy, used when Backend isn't supported
+	Fallback      string
+	// HardlinkPaths are the globs the hardlink backend links instead of cloning
+	HardlinkPaths []string
+	// ResetBranch, Detach, Track, NoTrack, GuessRemote and NoCheckout mirror
+	// the git worktree add options of the same names (see Worktree)
+	ResetBranch bool
+	Detach      bool
+	Track       bool
+	NoTrack     bool
+	GuessRemote bool
+	NoCheckout  bool
+	// ExistingBranch checks out BranchName instead of creating it
+	ExistingBranch bool
+	// Carry is CarryCopy (default), CarryNone or CarryMove
+	Carry string
+	// Force replaces an existing file, directory or worktree at WorktreePath
+	Force bool
+	// Sparse and SparseNoCone give the worktree its own sparse-checkout set (see Worktree)
+	Sparse       []string
+	SparseNoCone bool
+	// Config holds settings for the new worktree only (see Worktree)
+	Config map[string]string
 }
 
 // Create creates a new CoW worktree with the given options
 func (m *Manager) Create(opts CreateOptions) (*Worktree, error) {
+	if err := ValidateFallback(opts.Fallback); err != nil {
+		return nil, err
+	}
+	if err := ValidateCarry(opts.Carry); err != nil {
+		return nil, err
+	}
+	if err := ValidateConfig(opts.Config); err != nil {
+		return nil, err
+	}
+
 	branchName := opts.BranchName
 	if opts.Prefix != "" {
 		branchName = opts.Prefix + branchName
@@ -51,19 +94,44 @@ func (m *Manager) Create(opts CreateOptions) (*Worktree, error) {
 
 	// Create worktree instance
 	worktree := NewWorktreeWithOptions(m.RepoPath, worktreePath, branchName, opts.NoRewrite)
+	worktree.Backend = opts.Backend
+	worktree.Fallback = opts.Fallback
+	worktree.HardlinkPaths = opts.HardlinkPaths
+	worktree.FromCommit = opts.FromCommit
+	worktree.ResetBranch = opts.ResetBranch
+	worktree.Detach = opts.Detach
+	worktree.Track = opts.Track
+	worktree.NoTrack = opts.NoTrack
+	worktree.GuessRemote = opts.GuessRemote
+	worktree.NoCheckout = opts.NoCheckout
+	worktree.ExistingBranch = opts.ExistingBranch
+	worktree.Carry = opts.Carry
+	worktree.Force = opts.Force
+	worktree.Sparse = opts.Sparse
+	worktree.SparseNoCone = opts.SparseNoCone
+	worktree.Config = opts.Config
+	worktree.LockTimeout = m.LockTimeout
+	worktree.Logger = m.Logger
 
 	// Create the worktree
 	if !opts.NoCoW {
-		// Check if CoW is supported
-		if supported, err := IsCoWSupported(m.RepoPath); err == nil && supported {
-			if err := worktree.CreateCoWWorktree(); err == nil {
-				return worktree, nil
+		// Check if the requested backend (or the copy fallback) is supported
+		if _, err := selectBackendWithFallback(opts.Backend, opts.Fallback, m.RepoPath, worktree.logger()); err != nil {
+			if explicitBackend(opts.Backend) {
+				return nil, err
 			}
+			worktree.logger().Info("backend not supported, using git worktree add", "backend", opts.Backend, "reason", err)
+		} else {
+			// CreateCoWWorktree falls back to git worktree add itself when that's allowed
+			if err := worktree.CreateCoWWorktree(); err != nil {
+				return nil, err
+			}
+			return worktree, nil
 		}
 	}
 
 	// Fall back to regular worktree
-	if err := m.createRegularWorktree(worktree); err != nil {
+	if err := worktree.CreateRegularWorktree(); err != nil {
 		return nil, err
 	}
 
@@ -82,6 +150,8 @@ func (m *Manager) CreateFromBranch(branchName, worktreePath string) (*Worktree,
 	}
 
 	worktree := NewWorktree(m.RepoPath, worktreePath, branchName)
+	worktree.LockTimeout = m.LockTimeout
+	worktree.Logger = m.Logger
 	if err := worktree.CreateFromExistingBranch(); err != nil {
 		return nil, err
 	}
@@ -101,12 +171,10 @@ func (m *Manager) ListCoW() ([]WorktreeInfo, error) {
 		return nil, err
 	}
 
+	// The main worktree always comes first, even when RepoPath is a linked worktree
 	var cowWorktrees []WorktreeInfo
-	for _, wt := range worktrees {
-		// Include worktrees that are not the main repo
-		if wt.Path != m.RepoPath {
-			cowWorktrees = append(cowWorktrees, wt)
-		}
+	if len(worktrees) > 1 {
+		cowWorktrees = append(cowWorktrees, worktrees[1:]...)
 	}
 
 	return cowWorktrees, nil
@@ -134,6 +202,8 @@ func (m *Manager) Remove(branchName string, keepBranch bool) error {
 	}
 
 	worktree := NewWorktree(m.RepoPath, worktreePath, branchName)
+	worktree.LockTimeout = m.LockTimeout
+	worktree.Logger = m.Logger
 
 	if keepBranch {
 		return worktree.Remove()
@@ -146,17 +216,3 @@ func (m *Manager) Remove(branchName string, keepBranch bool) error {
 func (m *Manager) IsCoWSupported() (bool, error) {
 	return IsCoWSupported(m.RepoPath)
 }
-
-// createRegularWorktree creates a regular git worktree
-func (m *Manager) createRegularWorktree(worktree *Worktree) error {
-	if err := os.MkdirAll(filepath.Dir(worktree.WorktreePath), 0755); err != nil {
-		return fmt.Errorf("failed to create worktree directory: %w", err)
-	}
-
-	// Use git worktree add directly
-	if _, err := worktree.runGitCommand(m.RepoPath, "worktree", "add", "-b", worktree.BranchName, worktree.WorktreePath); err != nil {
-		return fmt.Errorf("failed to create regular worktree: %w", err)
-	}
-
-	return nil
-}
\ No newline at end of file
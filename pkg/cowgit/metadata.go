@@ -0,0 +1,64 @@
+package cowgit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// metadataFileName is coworktree's record inside git's per-worktree directory.
+// Keeping it next to HEAD and gitdir means git worktree prune cleans it up too.
+const metadataFileName = "coworktree.json"
+
+// worktreeMetadata records how coworktree created a worktree
+type worktreeMetadata struct {
+	Backend string `json:"backend"`
+	// Created is when the worktree was registered
+	Created time.Time `json:"created,omitzero"`
+	// BaseCommit is the commit the worktree was created at
+	BaseCommit string `json:"base_commit,omitempty"`
+}
+
+// writeWorktreeMetadata saves metadata into a worktree's git directory
+func writeWorktreeMetadata(worktreeGitDir string, meta worktreeMetadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(worktreeGitDir, metadataFileName), append(data, '\n'), 0644)
+}
+
+// readWorktreeMetadata loads metadata from a worktree's git directory
+func readWorktreeMetadata(worktreeGitDir string) (worktreeMetadata, error) {
+	var meta worktreeMetadata
+	data, err := os.ReadFile(filepath.Join(worktreeGitDir, metadataFileName))
+	if err != nil {
+		return meta, err
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, fmt.Errorf("failed to parse worktree metadata: %w", err)
+	}
+	return meta, nil
+}
+
+// resolveWorktreeGitDir follows a linked worktree's .git file to its directory in .git/worktrees
+func resolveWorktreeGitDir(worktreePath string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(worktreePath, ".git"))
+	if err != nil {
+		return "", err
+	}
+
+	line := strings.TrimSpace(string(content))
+	if !strings.HasPrefix(line, "gitdir: ") {
+		return "", fmt.Errorf("%s/.git is not a gitdir file", worktreePath)
+	}
+
+	gitDir := strings.TrimPrefix(line, "gitdir: ")
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(worktreePath, gitDir)
+	}
+	return filepath.Clean(gitDir), nil
+}
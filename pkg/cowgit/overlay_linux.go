@@ -0,0 +1,340 @@
+//go:build linux
+
+package cowgit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+func init() {
+	RegisterBackend(overlayBackend{})
+}
+
+// overlayBackend mounts an overlay at the worktree path instead of copying files
+type overlayBackend struct{}
+
+// Name returns the backend name
+func (overlayBackend) Name() string {
+	return BackendOverlay
+}
+
+// Probe checks that the current user can mount overlays
+func (overlayBackend) Probe(path string) (bool, error) {
+	return IsOverlaySupported()
+}
+
+// Clone mounts an overlay of src at dst. The lower .git is always masked;
+// other exclusions are not supported since the lower layer is shared as a whole.
+func (overlayBackend) Clone(src, dst string, _ CloneOptions) error {
+	return mountOverlay(src, dst)
+}
+
+// Cleanup unmounts the overlay at dst and deletes its upper and work directories
+func (overlayBackend) Cleanup(dst string) error {
+	return unmountOverlay(dst)
+}
+
+// Displace unmounts the overlay at dst, which can't be renamed while mounted,
+// and moves its state aside so a new overlay at dst starts empty. The returned
+// functions remount it from the moved state or delete that state.
+func (overlayBackend) Displace(dst string) (restore, release func() error, err error) {
+	stateDir, err := overlayStateDir(dst)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, mounted, err := mountType(dst)
+	if err != nil {
+		return nil, nil, err
+	}
+	lower, lowerErr := os.ReadFile(filepath.Join(stateDir, overlayLowerFile))
+	if mounted {
+		if lowerErr != nil {
+			return nil, nil, fmt.Errorf("overlay at %s can't be remounted if replacing it fails: %w", dst, lowerErr)
+		}
+		if err := unmountOverlayAt(dst); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	upperDir, workDir := filepath.Join(stateDir, "upper"), filepath.Join(stateDir, "work")
+	aside, err := os.MkdirTemp(filepath.Dir(stateDir), filepath.Base(stateDir)+".replaced-*")
+	if err == nil {
+		err = os.Rename(stateDir, filepath.Join(aside, "state"))
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	if err != nil {
+		if aside != "" {
+			os.Remove(aside)
+		}
+		if mounted {
+			mountOverlayLayers(string(lower), upperDir, workDir, dst)
+		}
+		return nil, nil, fmt.Errorf("failed to move overlay state aside: %w", err)
+	}
+
+	restore = func() error {
+		os.RemoveAll(stateDir)
+		if err := os.Rename(filepath.Join(aside, "state"), stateDir); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to restore overlay state (it is kept in %s): %w", aside, err)
+		}
+		os.Remove(aside)
+		if !mounted {
+			return nil
+		}
+		return mountOverlayLayers(string(lower), upperDir, workDir, dst)
+	}
+	release = func() error {
+		if err := os.RemoveAll(aside); err != nil {
+			return fmt.Errorf("failed to remove overlay state: %w", err)
+		}
+		return nil
+	}
+	return restore, release, nil
+}
+
+// DiskUsage measures the overlay's upper and work directories, which hold
+// everything written to the worktree; the lower layer is the main checkout
+func (overlayBackend) DiskUsage(dst string) (int64, error) {
+	stateDir, err := overlayStateDir(dst)
+	if err != nil {
+		return 0, err
+	}
+	return DiskUsage(stateDir)
+}
+
+// IsOverlaySupported checks if overlay worktrees can be mounted by the current user.
+// Root uses the kernel overlay filesystem directly. Mounts made inside an
+// unprivileged user namespace disappear together with the namespace, so other
+// users need fuse-overlayfs to get a mount that outlives the coworktree process.
+func IsOverlaySupported() (bool, error) {
+	if os.Geteuid() == 0 {
+		return kernelHasOverlay()
+	}
+
+	if _, err := exec.LookPath("fuse-overlayfs"); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+// kernelHasOverlay checks /proc/filesystems for the overlay filesystem
+func kernelHasOverlay() (bool, error) {
+	file, err := os.Open("/proc/filesystems")
+	if err != nil {
+		return false, fmt.Errorf("failed to read supported filesystems: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && fields[len(fields)-1] == "overlay" {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// overlayLowerFile records an overlay's lower layer in its state directory, so
+// it can be mounted again after being displaced
+const overlayLowerFile = "lower"
+
+// mountOverlay mounts an overlay at target with lower as the read-only layer.
+// The upperdir and workdir live in a per-worktree state directory, so the main
+// checkout is never written to. Changes made to lower while the overlay is
+// mounted are only partially visible through it, as with any overlayfs mount.
+func mountOverlay(lower, target string) error {
+	stateDir, err := overlayStateDir(target)
+	if err != nil {
+		return err
+	}
+	upperDir := filepath.Join(stateDir, "upper")
+	workDir := filepath.Join(stateDir, "work")
+
+	for _, dir := range []string{upperDir, workDir, target} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create overlay directory: %w", err)
+		}
+	}
+
+	// A non-directory in upperdir masks the lower entry of the same name. This hides
+	// the main checkout's .git directory so registration can replace it with a .git
+	// file instead of deleting the whole git directory through the mount.
+	if err := os.WriteFile(filepath.Join(upperDir, ".git"), nil, 0644); err != nil {
+		os.RemoveAll(stateDir)
+		return fmt.Errorf("failed to mask .git in overlay: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(stateDir, overlayLowerFile), []byte(lower), 0644); err != nil {
+		os.RemoveAll(stateDir)
+		return fmt.Errorf("failed to record overlay lower layer: %w", err)
+	}
+
+	if err := mountOverlayLayers(lower, upperDir, workDir, target); err != nil {
+		os.RemoveAll(stateDir)
+		return err
+	}
+	return nil
+}
+
+// mountOverlayLayers mounts an overlay of existing layer directories at target
+func mountOverlayLayers(lower, upperDir, workDir, target string) error {
+	var err error
+	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s",
+		escapeOverlayPath(lower), escapeOverlayPath(upperDir), escapeOverlayPath(workDir))
+
+	if os.Geteuid() == 0 {
+		err = unix.Mount("overlay", target, "overlay", 0, options)
+	} else if fuseOverlay, lookErr := exec.LookPath("fuse-overlayfs"); lookErr == nil {
+		if output, runErr := exec.Command(fuseOverlay, "-o", options, target).CombinedOutput(); runErr != nil {
+			err = fmt.Errorf("%w: %s", runErr, strings.TrimSpace(string(output)))
+		}
+	} else {
+		err = errors.New("overlay worktrees need root or fuse-overlayfs")
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to mount overlay at %s: %w", target, err)
+	}
+
+	return nil
+}
+
+// unmountOverlay unmounts an overlay worktree and deletes its upperdir and workdir
+func unmountOverlay(target string) error {
+	if err := unmountOverlayAt(target); err != nil {
+		return err
+	}
+
+	stateDir, err := overlayStateDir(target)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(stateDir); err != nil {
+		return fmt.Errorf("failed to remove overlay state: %w", err)
+	}
+
+	return nil
+}
+
+// unmountOverlayAt unmounts the overlay at target, if one is mounted there
+func unmountOverlayAt(target string) error {
+	fstype, mounted, err := mountType(target)
+	if err != nil || !mounted {
+		return err
+	}
+
+	if fstype == "overlay" {
+		err = unix.Unmount(target, 0)
+	} else {
+		err = fuseUnmount(target)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to unmount overlay at %s: %w", target, err)
+	}
+	return nil
+}
+
+// fuseUnmount unmounts a fuse-overlayfs mount without needing root
+func fuseUnmount(target string) error {
+	for _, tool := range []string{"fusermount3", "fusermount"} {
+		if path, err := exec.LookPath(tool); err == nil {
+			if output, err := exec.Command(path, "-u", target).CombinedOutput(); err != nil {
+				return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+			}
+			return nil
+		}
+	}
+	return unix.Unmount(target, 0)
+}
+
+// isOverlayMount reports whether path is the mount point of an overlay worktree
+func isOverlayMount(path string) bool {
+	_, mounted, err := mountType(path)
+	return err == nil && mounted
+}
+
+// mountType looks up path in /proc/self/mountinfo and returns its overlay filesystem type
+func mountType(path string) (string, bool, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read mount table: %w", err)
+	}
+	defer file.Close()
+
+	path = filepath.Clean(path)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Format: id parent major:minor root mountpoint options [optional...] - fstype source superoptions
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || unescapeMountPath(fields[4]) != path {
+			continue
+		}
+		for i := 5; i < len(fields)-1; i++ {
+			if fields[i] != "-" {
+				continue
+			}
+			fstype := fields[i+1]
+			if fstype == "overlay" || fstype == "fuse.fuse-overlayfs" {
+				return fstype, true, nil
+			}
+			break
+		}
+	}
+
+	return "", false, scanner.Err()
+}
+
+// overlayStateDir returns the directory holding the upperdir and workdir for target.
+// It is derived from the target path alone so removal can find it without extra metadata.
+func overlayStateDir(target string) (string, error) {
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to locate state directory: %w", err)
+		}
+		stateHome = filepath.Join(home, ".local", "state")
+	}
+
+	sum := sha256.Sum256([]byte(filepath.Clean(target)))
+	key := filepath.Base(target) + "-" + hex.EncodeToString(sum[:])[:12]
+	return filepath.Join(stateHome, "coworktree", "overlay", key), nil
+}
+
+// escapeOverlayPath escapes the characters overlayfs treats as option separators
+func escapeOverlayPath(path string) string {
+	return strings.NewReplacer(`\`, `\\`, `,`, `\,`, `:`, `\:`).Replace(path)
+}
+
+// unescapeMountPath decodes the octal escapes used for spaces and tabs in mountinfo
+func unescapeMountPath(path string) string {
+	if !strings.Contains(path, `\`) {
+		return path
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if code, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				sb.WriteByte(byte(code))
+				i += 3
+				continue
+			}
+		}
+		sb.WriteByte(path[i])
+	}
+	return sb.String()
+}
@@ -0,0 +1,196 @@
+package cowgit
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOverlayWorktree(t *testing.T) {
+	if supported, err := IsOverlaySupported(); err != nil || !supported {
+		t.Skip("Overlay mounts not supported for this user")
+	}
+
+	tempDir, err := os.MkdirTemp("", "coworktree-overlay-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Keep overlay upper/work directories inside the test's temp dir
+	t.Setenv("XDG_STATE_HOME", filepath.Join(tempDir, "state"))
+
+	repoDir := filepath.Join(tempDir, "repo")
+	if err := os.MkdirAll(repoDir, 0755); err != nil {
+		t.Fatalf("Failed to create repo dir: %v", err)
+	}
+	setupGitRepo(t, repoDir)
+
+	// Create a gitignored artifact that must show up through the overlay
+	if err := os.WriteFile(filepath.Join(repoDir, ".gitignore"), []byte("build/\n"), 0644); err != nil {
+		t.Fatalf("Failed to create .gitignore: %v", err)
+	}
+	if err := runCommand(repoDir, "git", "add", ".gitignore"); err != nil {
+		t.Fatalf("Failed to add .gitignore: %v", err)
+	}
+	if err := runCommand(repoDir, "git", "commit", "-m", "Add gitignore"); err != nil {
+		t.Fatalf("Failed to commit .gitignore: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(repoDir, "build"), 0755); err != nil {
+		t.Fatalf("Failed to create build dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repoDir, "build", "out.bin"), []byte("artifact"), 0644); err != nil {
+		t.Fatalf("Failed to create artifact: %v", err)
+	}
+
+	worktreePath := filepath.Join(tempDir, "overlay-wt")
+	worktree := NewWorktree(repoDir, worktreePath, "overlay-branch")
+	worktree.Backend = BackendOverlay
+
+	if err := worktree.CreateCoWWorktree(); err != nil {
+		t.Fatalf("Failed to create overlay worktree: %v", err)
+	}
+	if !isOverlayMount(worktreePath) {
+		t.Fatalf("Worktree was not created as an overlay mount")
+	}
+
+	if content, err := os.ReadFile(filepath.Join(worktreePath, "build", "out.bin")); err != nil {
+		t.Errorf("Gitignored artifact not visible through overlay: %v", err)
+	} else if string(content) != "artifact" {
+		t.Errorf("Artifact content mismatch: got %s", string(content))
+	}
+
+	// Writes through the overlay must not reach the main checkout
+	if err := os.WriteFile(filepath.Join(worktreePath, "test.txt"), []byte("changed in overlay"), 0644); err != nil {
+		t.Fatalf("Failed to write through overlay: %v", err)
+	}
+	if content, err := os.ReadFile(filepath.Join(repoDir, "test.txt")); err != nil {
+		t.Fatalf("Failed to read source file: %v", err)
+	} else if string(content) != "initial content" {
+		t.Errorf("Overlay write leaked into the main checkout: %s", string(content))
+	}
+
+	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
+	cmd.Dir = worktreePath
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git does not recognize the overlay worktree: %v", err)
+	}
+	if branch := strings.TrimSpace(string(output)); branch != "overlay-branch" {
+		t.Errorf("Overlay worktree HEAD = %s, want overlay-branch", branch)
+	}
+
+	// The main checkout's .git directory must still be intact
+	if info, err := os.Stat(filepath.Join(repoDir, ".git")); err != nil || !info.IsDir() {
+		t.Fatalf("Main repository .git directory damaged: %v", err)
+	}
+
+	stateDir, err := overlayStateDir(worktreePath)
+	if err != nil {
+		t.Fatalf("Failed to locate overlay state: %v", err)
+	}
+
+	// A fresh Worktree value must still detect the mount when removing
+	if err := NewWorktree(repoDir, worktreePath, "overlay-branch").Remove(); err != nil {
+		t.Fatalf("Failed to remove overlay worktree: %v", err)
+	}
+	if isOverlayMount(worktreePath) {
+		t.Error("Overlay still mounted after removal")
+	}
+	if _, err := os.Stat(worktreePath); !os.IsNotExist(err) {
+		t.Error("Worktree path still exists after removal")
+	}
+	if _, err := os.Stat(stateDir); !os.IsNotExist(err) {
+		t.Error("Overlay state directory still exists after removal")
+	}
+	if _, err := os.Stat(filepath.Join(repoDir, "build", "out.bin")); err != nil {
+		t.Errorf("Removing the overlay touched the main checkout: %v", err)
+	}
+}
+
+func TestForceReplacesOverlayWorktree(t *testing.T) {
+	if supported, err := IsOverlaySupported(); err != nil || !supported {
+		t.Skip("Overlay mounts not supported for this user")
+	}
+	defer func() { transactionHook = nil }()
+
+	tempDir := t.TempDir()
+	stateHome := filepath.Join(tempDir, "state")
+	t.Setenv("XDG_STATE_HOME", stateHome)
+	repoDir := filepath.Join(tempDir, "repo")
+	if err := os.MkdirAll(repoDir, 0755); err != nil {
+		t.Fatalf("Failed to create repo dir: %v", err)
+	}
+	setupGitRepo(t, repoDir)
+	target := filepath.Join(tempDir, "overlay-wt")
+
+	create := func(branch string) error {
+		worktree := NewWorktree(repoDir, target, branch)
+		worktree.Backend = BackendOverlay
+		worktree.Force = true
+		return worktree.CreateCoWWorktree()
+	}
+
+	if err := create("first"); err != nil {
+		t.Fatalf("Failed to create overlay worktree: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(target, "scratch.txt"), []byte("scratch"), 0644); err != nil {
+		t.Fatalf("Failed to write through overlay: %v", err)
+	}
+
+	// A failed replacement remounts the old overlay with its contents
+	injected := errors.New("injected failure")
+	transactionHook = func(step string) error {
+		if step == "HEAD" {
+			return injected
+		}
+		return nil
+	}
+	err := create("second")
+	transactionHook = nil
+	if !errors.Is(err, injected) {
+		t.Fatalf("failed replacement error = %v, want injected failure", err)
+	}
+	if !isOverlayMount(target) {
+		t.Fatal("old overlay not remounted after a failed replacement")
+	}
+	if content, err := os.ReadFile(filepath.Join(target, "scratch.txt")); err != nil || string(content) != "scratch" {
+		t.Errorf("old overlay contents not restored: %q, %v", content, err)
+	}
+	if branch := currentBranch(t, target); branch != "first" {
+		t.Errorf("restored worktree is on %q, want first", branch)
+	}
+
+	if err := create("second"); err != nil {
+		t.Fatalf("Failed to replace overlay worktree: %v", err)
+	}
+	if !isOverlayMount(target) {
+		t.Fatal("replacement is not an overlay mount")
+	}
+	if _, err := os.Stat(filepath.Join(target, "scratch.txt")); !os.IsNotExist(err) {
+		t.Error("old overlay's files survived the replacement")
+	}
+	if branch := currentBranch(t, target); branch != "second" {
+		t.Errorf("replaced worktree is on %q, want second", branch)
+	}
+
+	// The old overlay's state is released, leaving only the new one's
+	entries, err := os.ReadDir(filepath.Join(stateHome, "coworktree", "overlay"))
+	if err != nil {
+		t.Fatalf("Failed to read overlay state: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("overlay state directories = %v, want only the new worktree's", entries)
+	}
+	matches, _ := filepath.Glob(filepath.Join(tempDir, ".coworktree-replaced-*"))
+	if len(matches) > 0 {
+		t.Errorf("replaced worktree left behind: %v", matches)
+	}
+
+	if err := NewWorktree(repoDir, target, "second").Remove(); err != nil {
+		t.Fatalf("Failed to remove overlay worktree: %v", err)
+	}
+}
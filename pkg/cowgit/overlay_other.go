@@ -0,0 +1,8 @@
+//go:build !linux
+
+package cowgit
+
+// IsOverlaySupported reports that overlay worktrees are only available on Linux
+func IsOverlaySupported() (bool, error) {
+	return false, nil
+}
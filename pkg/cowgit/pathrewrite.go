@@ -3,7 +3,9 @@ package cowgit
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
@@ -167,13 +169,17 @@ func isValidText(content []byte) bool {
 	return true
 }
 
-// rewriteAbsolutePathsWithProgress rewrites absolute paths with detailed progress tracking
-func rewriteAbsolutePathsWithProgress(srcDir, dstDir string, progress *ProgressTracker) error {
+// rewriteAbsolutePathsWithProgress rewrites absolute paths with detailed progress tracking,
+// logging the worker pool's scaling to logger, and returns the final statistics.
+// Cancelling ctx stops the walk and the workers, leaving the remaining files as cloned.
+func rewriteAbsolutePathsWithProgress(ctx context.Context, srcDir, dstDir string, progress *ProgressTracker, logger *slog.Logger) (PathRewriteStats, error) {
 	gitignore := parseGitignore(srcDir)
 	
 	// Create adaptive worker pool
 	pool := NewWorkerPool(srcDir, dstDir, gitignore)
+	pool.ctx = ctx
 	controller := NewPoolController(pool)
+	controller.logger = logger
 	
 	// Start pool and controller
 	pool.Start()
@@ -210,6 +216,9 @@ func rewriteAbsolutePathsWithProgress(srcDir, dstDir string, progress *ProgressT
 	
 	// Submit all files for processing
 	walkErr := filepath.Walk(dstDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil {
+			err = ctx.Err()
+		}
 		if err == nil && !info.IsDir() {
 			pool.Submit(path)
 		}
@@ -225,9 +234,8 @@ func rewriteAbsolutePathsWithProgress(srcDir, dstDir string, progress *ProgressT
 	close(pool.errChan)
 	
 	// Get final statistics
+	finalStats := pool.GetDetailedStats()
 	if progress != nil {
-		finalStats := pool.GetDetailedStats()
-		
 		// Update progress with final detailed info
 		if finalStats.ModifiedFiles > 0 {
 			info := fmt.Sprintf("%d of %d files modified (%d gitignored, %d text, %d binary skipped)", 
@@ -240,5 +248,5 @@ func rewriteAbsolutePathsWithProgress(srcDir, dstDir string, progress *ProgressT
 		}
 	}
 	
-	return walkErr
+	return finalStats, walkErr
 }
\ No newline at end of file
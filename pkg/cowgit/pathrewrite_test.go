@@ -65,13 +65,13 @@ __pycache__/
 }
 
 func TestPathRewriting(t *testing.T) {
-	// Skip if not on APFS (since we need CoW for full test)
-	result, err := isAPFS(".")
+	// Skip if not on a CoW-capable filesystem (since we need CoW for full test)
+	result, err := IsCoWSupported(".")
 	if err != nil {
 		t.Fatalf("Failed to check filesystem: %v", err)
 	}
 	if !result {
-		t.Skip("Skipping path rewriting test - not on APFS filesystem")
+		t.Skip("Skipping path rewriting test - filesystem does not support copy-on-write")
 	}
 
 	// Create temporary directories
@@ -0,0 +1,320 @@
+package cowgit
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Plan describes what creating a worktree would do. Worktree.Plan computes it
+// without changing the repository or the filesystem.
+type Plan struct {
+	// Path is the absolute, symlink-free path the worktree would be created at
+	Path       string `json:"path"`
+	Branch     string `json:"branch,omitempty"`
+	Detached   bool   `json:"detached"`
+	BaseCommit string `json:"base_commit"`
+	// Replaces is set when Force would replace what is at Path
+	Replaces bool `json:"replaces,omitempty"`
+
+	// Backend is the clone backend that would be used, FallbackGit when the
+	// worktree would be created with git worktree add, or empty when
+	// NoCheckout leaves nothing to clone. FallbackReason says why no clone
+	// backend would be used.
+	Backend        string `json:"backend"`
+	FallbackReason string `json:"fallback_reason,omitempty"`
+
+	// Files, Dirs and Bytes count what would be cloned or, with git worktree
+	// add, checked out. Hardlinked is how many of the files the hardlink
+	// backend would link instead of cloning.
+	Files      int64 `json:"files"`
+	Dirs       int64 `json:"dirs"`
+	Bytes      int64 `json:"bytes"`
+	Hardlinked int64 `json:"hardlinked,omitempty"`
+	// Excluded lists the paths, relative to the source checkout, that would not be cloned
+	Excluded []string `json:"excluded,omitempty"`
+	// ChangedPaths lists the tracked paths that would be updated from the
+	// cloned checkout to BaseCommit
+	ChangedPaths []string `json:"changed_paths,omitempty"`
+
+	// Rewrites lists the ignored files path rewriting would modify, and
+	// RewriteStats summarizes the scan; both are empty when it is disabled
+	Rewrites     []PlannedRewrite  `json:"rewrites,omitempty"`
+	RewriteStats *PathRewriteStats `json:"rewrite_stats,omitempty"`
+
+	// Refs lists the branches that would be created or reset
+	Refs []PlannedRef `json:"refs,omitempty"`
+	// MetadataFiles lists the git and coworktree files that would be written
+	MetadataFiles []string `json:"metadata_files"`
+}
+
+// PlannedRewrite is a file path rewriting would modify
+type PlannedRewrite struct {
+	// Path is relative to the worktree
+	Path string `json:"path"`
+	// Matches counts the occurrences of the source checkout's path in the file
+	Matches int `json:"matches"`
+}
+
+// PlannedRef is a branch creation would create or reset
+type PlannedRef struct {
+	Name string `json:"name"`
+	// Action is "create" or "reset"
+	Action string `json:"action"`
+	Commit string `json:"commit"`
+	// StartPoint is the commit-ish the branch would start from, when it is
+	// not just Commit, such as a remote-tracking branch
+	StartPoint string `json:"start_point,omitempty"`
+}
+
+// Plan works out what CreateCoWWorktree would do, without changing anything:
+// the backend it would use, or why it would fall back to git worktree add,
+// what the clone would hold, which files path rewriting would modify, and the
+// branches and metadata files it would create. It fails for the same invalid
+// options and unsafe targets as creation.
+func (w *Worktree) Plan() (*Plan, error) {
+	return w.plan(false)
+}
+
+// PlanRegular is Plan for CreateRegularWorktree
+func (w *Worktree) PlanRegular() (*Plan, error) {
+	return w.plan(true)
+}
+
+// plan computes a Plan on a copy of the worktree, so resolving commits and
+// the backend leaves w as it was
+func (w *Worktree) plan(regular bool) (*Plan, error) {
+	c := *w
+	if err := c.Preflight(); err != nil {
+		return nil, err
+	}
+	if err := c.resolveCommits(); err != nil {
+		return nil, err
+	}
+	target, err := canonicalPath(c.WorktreePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", c.WorktreePath, err)
+	}
+
+	plan := &Plan{Path: target, Detached: c.Detach, BaseCommit: c.BaseCommit}
+	if !c.Detach {
+		plan.Branch = c.BranchName
+	}
+	if c.Force {
+		_, err := os.Lstat(target)
+		plan.Replaces = err == nil || c.replaceRegistered
+	}
+
+	plan.Backend = FallbackGit
+	if !regular {
+		if backend, err := selectBackendWithFallback(c.Backend, c.Fallback, c.RepoPath, c.logger()); err != nil {
+			if explicitBackend(c.Backend) {
+				return nil, err
+			}
+			plan.FallbackReason = err.Error()
+		} else if c.NoCheckout {
+			plan.Backend = ""
+		} else {
+			plan.Backend = backend.Name()
+		}
+	}
+	if c.Carry == CarryMove && plan.Backend == BackendOverlay {
+		return nil, fmt.Errorf("--carry=%s is not supported with the %s backend", CarryMove, BackendOverlay)
+	}
+
+	switch {
+	case plan.Backend == FallbackGit:
+		if !c.NoCheckout {
+			err = c.planCheckout(plan)
+		}
+	case plan.Backend != "":
+		err = c.planClone(plan)
+		if err == nil && c.BaseCommit != c.sourceCommit {
+			err = c.planChangedPaths(plan)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.Detach && !c.ExistingBranch {
+		ref := PlannedRef{Name: "refs/heads/" + c.BranchName, Action: "create", Commit: c.BaseCommit}
+		if c.ResetBranch && c.branchExists() {
+			ref.Action = "reset"
+		}
+		if start := c.startPoint(); start != c.BaseCommit {
+			ref.StartPoint = start
+		}
+		plan.Refs = append(plan.Refs, ref)
+	}
+	plan.MetadataFiles = c.plannedMetadataFiles(plan)
+	return plan, nil
+}
+
+// planClone walks the source checkout the way the clone would, counting what
+// it would hold and, unless NoRewrite is set, running the path rewrite's
+// worker pool read-only over it
+func (w *Worktree) planClone(plan *Plan) error {
+	exclude := w.repo.cloneExcludes()
+	var hardlinks []string
+	if plan.Backend == BackendHardlink {
+		hardlinks = w.HardlinkPaths
+		if len(hardlinks) == 0 {
+			hardlinks = DefaultHardlinkPaths
+		}
+	}
+
+	var pool *WorkerPool
+	if !w.NoRewrite {
+		pool = newPlanningPool(w.RepoPath, plan.Path, parseGitignore(w.RepoPath))
+		controller := NewPoolController(pool)
+		controller.logger = w.logger()
+		pool.Start()
+		controller.Start()
+		defer controller.Stop()
+	}
+
+	walkErr := filepath.WalkDir(w.RepoPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(w.RepoPath, path)
+		if err != nil || relPath == "." {
+			return err
+		}
+		slashPath := filepath.ToSlash(relPath)
+
+		if matchAnyGlob(exclude, slashPath) {
+			plan.Excluded = append(plan.Excluded, slashPath)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			plan.Dirs++
+			return nil
+		}
+
+		plan.Files++
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			plan.Bytes += info.Size()
+			if matchAnyGlob(hardlinks, slashPath) {
+				plan.Hardlinked++
+			}
+		}
+		if pool != nil {
+			pool.Submit(path)
+		}
+		return nil
+	})
+
+	if pool != nil {
+		pool.Stop()
+		stats := pool.GetDetailedStats()
+		plan.RewriteStats = &stats
+		plan.Rewrites = pool.plannedRewrites()
+	}
+	if walkErr != nil {
+		return fmt.Errorf("failed to scan %s: %w", w.RepoPath, walkErr)
+	}
+	return nil
+}
+
+// planCheckout counts the tracked files git worktree add would check out at BaseCommit
+func (w *Worktree) planCheckout(plan *Plan) error {
+	output, err := w.runGitCommand(w.RepoPath, "ls-tree", "-r", "-l", "-z", plan.BaseCommit)
+	if err != nil {
+		return fmt.Errorf("failed to list files at %s: %w", shortCommit(plan.BaseCommit), err)
+	}
+
+	dirs := make(map[string]bool)
+	for _, entry := range strings.Split(string(output), "\x00") {
+		// <mode> <type> <object> <size>\t<path>
+		meta, name, ok := strings.Cut(entry, "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) != 4 {
+			continue
+		}
+		for dir := path.Dir(name); dir != "." && !dirs[dir]; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+		if fields[1] != "blob" {
+			// Submodules are checked out by git submodule update, not worktree add
+			continue
+		}
+		plan.Files++
+		if size, err := strconv.ParseInt(fields[3], 10, 64); err == nil {
+			plan.Bytes += size
+		}
+	}
+	plan.Dirs = int64(len(dirs))
+	return nil
+}
+
+// planChangedPaths lists the tracked paths reconcileToBaseCommit would update
+func (w *Worktree) planChangedPaths(plan *Plan) error {
+	output, err := w.runGitCommand(w.RepoPath, "diff", "--name-only", "--no-renames", "-z", w.sourceCommit, w.BaseCommit)
+	if err != nil {
+		return fmt.Errorf("failed to diff %s against %s: %w", shortCommit(w.sourceCommit), shortCommit(w.BaseCommit), err)
+	}
+	for _, path := range strings.Split(string(output), "\x00") {
+		if path != "" {
+			plan.ChangedPaths = append(plan.ChangedPaths, path)
+		}
+	}
+	return nil
+}
+
+// plannedMetadataFiles lists the files registration, config and
+// sparse-checkout setup would write, in the shared git directory, the new
+// worktree's git directory and the worktree itself
+func (w *Worktree) plannedMetadataFiles(plan *Plan) []string {
+	commonDir := w.commonDir()
+	gitDir := filepath.Join(commonDir, "worktrees", nextWorktreeID(commonDir, filepath.Base(plan.Path)))
+
+	var files []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+
+	for _, name := range []string{"commondir", "gitdir", "HEAD"} {
+		add(filepath.Join(gitDir, name))
+	}
+	if plan.Backend != FallbackGit {
+		add(filepath.Join(gitDir, metadataFileName))
+	}
+	if !w.NoCheckout {
+		add(filepath.Join(gitDir, "index"))
+	}
+
+	worktreeConfig := w.sourceConfig("extensions.worktreeConfig", "--bool") == "true"
+	sparse := w.sourceConfig("core.sparseCheckout", "--bool") == "true"
+	if len(w.Config) > 0 || w.Sparse != nil {
+		if !worktreeConfig {
+			add(filepath.Join(commonDir, "config"))
+		}
+		add(filepath.Join(gitDir, worktreeConfigFileName))
+	}
+	if w.Sparse != nil || sparse {
+		add(filepath.Join(gitDir, "info", "sparse-checkout"))
+		if sparse && worktreeConfig {
+			add(filepath.Join(gitDir, worktreeConfigFileName))
+		}
+	}
+
+	add(filepath.Join(plan.Path, ".git"))
+	return files
+}
@@ -0,0 +1,101 @@
+package cowgit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlanChangesNothing(t *testing.T) {
+	tempDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to resolve temp dir: %v", err)
+	}
+	repoDir := filepath.Join(tempDir, "repo")
+	setupCompatRepo(t, repoDir)
+
+	// An ignored file with the checkout's absolute path in it twice
+	if err := os.WriteFile(filepath.Join(repoDir, ".gitignore"), []byte("venv/\n"), 0644); err != nil {
+		t.Fatalf("Failed to write .gitignore: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(repoDir, "venv"), 0755); err != nil {
+		t.Fatalf("Failed to create venv: %v", err)
+	}
+	cfg := "home = " + repoDir + "/venv\nprefix = " + repoDir + "\n"
+	if err := os.WriteFile(filepath.Join(repoDir, "venv", "pyvenv.cfg"), []byte(cfg), 0644); err != nil {
+		t.Fatalf("Failed to write pyvenv.cfg: %v", err)
+	}
+
+	path := filepath.Join(tempDir, "planned")
+	worktree := NewWorktreeWithOptions(repoDir, path, "planned", false)
+	worktree.Backend = BackendCopy
+	worktree.FromCommit = "existing"
+	worktree.Config = map[string]string{"user.email": "planned@example.com"}
+	plan, err := worktree.Plan()
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	if plan.Path != path || plan.Branch != "planned" || plan.Backend != BackendCopy {
+		t.Errorf("Plan = %+v", plan)
+	}
+	if plan.BaseCommit != revParse(t, repoDir, "existing") {
+		t.Errorf("BaseCommit = %s, want the commit of existing", plan.BaseCommit)
+	}
+	// test.txt, second.txt, .gitignore and venv/pyvenv.cfg
+	if plan.Files != 4 || plan.Dirs != 1 {
+		t.Errorf("Files, Dirs = %d, %d; want 4, 1", plan.Files, plan.Dirs)
+	}
+	if len(plan.Excluded) != 1 || plan.Excluded[0] != ".git" {
+		t.Errorf("Excluded = %v, want [.git]", plan.Excluded)
+	}
+	if len(plan.ChangedPaths) != 2 {
+		t.Errorf("ChangedPaths = %v, want test.txt and second.txt", plan.ChangedPaths)
+	}
+	if len(plan.Rewrites) != 1 || plan.Rewrites[0] != (PlannedRewrite{Path: "venv/pyvenv.cfg", Matches: 2}) {
+		t.Errorf("Rewrites = %+v", plan.Rewrites)
+	}
+	if len(plan.Refs) != 1 || plan.Refs[0].Name != "refs/heads/planned" || plan.Refs[0].Action != "create" || plan.Refs[0].StartPoint != "existing" {
+		t.Errorf("Refs = %+v", plan.Refs)
+	}
+	gitDir := filepath.Join(repoDir, ".git", "worktrees", "planned")
+	want := map[string]bool{
+		filepath.Join(gitDir, metadataFileName):       true,
+		filepath.Join(gitDir, worktreeConfigFileName): true,
+		filepath.Join(repoDir, ".git", "config"):      true,
+		filepath.Join(path, ".git"):                   true,
+	}
+	for _, file := range plan.MetadataFiles {
+		delete(want, file)
+	}
+	if len(want) > 0 {
+		t.Errorf("MetadataFiles = %v, missing %v", plan.MetadataFiles, want)
+	}
+
+	// Planning leaves the worktree, its branch and its registration uncreated,
+	// and the files to rewrite as they were
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("worktree path was created: %v", err)
+	}
+	if branchExists(t, repoDir, "planned") {
+		t.Error("branch planned was created")
+	}
+	if _, err := os.Lstat(filepath.Join(repoDir, ".git", "worktrees")); !os.IsNotExist(err) {
+		t.Errorf("worktrees directory was created: %v", err)
+	}
+	if content, err := os.ReadFile(filepath.Join(repoDir, "venv", "pyvenv.cfg")); err != nil || string(content) != cfg {
+		t.Errorf("pyvenv.cfg changed: %q, %v", content, err)
+	}
+	if worktree.BaseCommit != "" || worktree.Backend != BackendCopy {
+		t.Errorf("Plan changed the worktree: BaseCommit %q, Backend %q", worktree.BaseCommit, worktree.Backend)
+	}
+
+	// git worktree add checks out tracked files only, and rewrites nothing
+	regular, err := worktree.PlanRegular()
+	if err != nil {
+		t.Fatalf("PlanRegular failed: %v", err)
+	}
+	if regular.Backend != FallbackGit || regular.Files != 1 || len(regular.Rewrites) != 0 {
+		t.Errorf("PlanRegular = %+v, want git with the one file at existing", regular)
+	}
+}
@@ -0,0 +1,236 @@
+package cowgit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Errors returned when the target path of a new worktree is unsafe to use
+var (
+	// ErrTargetExists means the target is a file, a non-empty directory or a
+	// registered worktree; Force replaces it
+	ErrTargetExists = errors.New("target path already exists")
+	// ErrTargetInsideSource means the target is the source checkout or inside it
+	// or its git directory
+	ErrTargetInsideSource = errors.New("target path is inside the source repository")
+	// ErrTargetContainsSource means the source checkout is inside the target
+	ErrTargetContainsSource = errors.New("target path contains the source repository")
+	// ErrTargetOtherRepository means the target is a checkout of another
+	// repository, which is never replaced, even with Force
+	ErrTargetOtherRepository = errors.New("target path is a worktree of another repository")
+)
+
+// Preflight checks that the worktree can be created at WorktreePath without
+// destroying anything: the target must be missing or an empty directory,
+// unrelated to the source checkout, and not another repository's checkout.
+// With Force, existing files and worktrees of this repository at the target
+// are replaced.
+func (w *Worktree) Preflight() error {
+	repo, err := w.repository()
+	if err != nil {
+		return err
+	}
+	target, err := canonicalPath(w.WorktreePath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", w.WorktreePath, err)
+	}
+	source, err := canonicalPath(w.RepoPath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", w.RepoPath, err)
+	}
+
+	if isWithin(target, source) || isWithin(target, repo.GitDir) || isWithin(target, repo.CommonDir) {
+		return fmt.Errorf("%w: %s", ErrTargetInsideSource, w.WorktreePath)
+	}
+	if isWithin(source, target) || isWithin(repo.CommonDir, target) {
+		return fmt.Errorf("%w: %s", ErrTargetContainsSource, w.WorktreePath)
+	}
+
+	// A worktree another process is creating right now is never replaced
+	if targetReserved(repo.CommonDir, target) {
+		return fmt.Errorf("%w: %s is being created by another process", ErrTargetExists, w.WorktreePath)
+	}
+
+	// A worktree of this repository, possibly with its directory gone
+	worktrees, err := ListWorktrees(w.RepoPath)
+	if err != nil {
+		return err
+	}
+	for _, info := range worktrees {
+		if registered, err := canonicalPath(info.Path); err == nil && registered == target {
+			if !w.Force {
+				return fmt.Errorf("%w: %s is already a registered worktree (use --force to replace it)", ErrTargetExists, w.WorktreePath)
+			}
+			w.replaceRegistered = true
+			return nil
+		}
+	}
+
+	info, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to inspect %s: %w", w.WorktreePath, err)
+	}
+
+	if info.IsDir() {
+		if _, err := os.Lstat(filepath.Join(target, ".git")); err == nil {
+			return fmt.Errorf("%w: %s", ErrTargetOtherRepository, w.WorktreePath)
+		}
+		entries, err := os.ReadDir(target)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", w.WorktreePath, err)
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+	}
+
+	if !w.Force {
+		return fmt.Errorf("%w: %s is not an empty directory (use --force to replace it)", ErrTargetExists, w.WorktreePath)
+	}
+	return nil
+}
+
+// canonicalPath returns the absolute, symlink-free form of path, resolving
+// the longest existing prefix when path itself doesn't exist yet
+func canonicalPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	var missing []string
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...), nil
+		}
+		if filepath.Dir(dir) == dir {
+			return abs, nil
+		}
+		missing = append([]string{filepath.Base(dir)}, missing...)
+	}
+}
+
+// isWithin reports whether path is dir or inside it; both must be canonical
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// displacedTarget is what was at a worktree's target path before Force replaced it
+type displacedTarget struct {
+	// aside is the hidden sibling directory the target was moved into
+	aside string
+	// path is the original target path
+	path string
+	// gitDir is the replaced worktree's directory under .git/worktrees, and
+	// gitDirAside where it was moved
+	gitDir      string
+	gitDirAside string
+	// backend is the replaced worktree's backend, cleaned up when it is
+	// discarded; restoreBackend and releaseBackend are set instead when the
+	// backend had to detach it from its path first
+	backend        CloneBackend
+	restoreBackend func() error
+	releaseBackend func() error
+}
+
+// displaceTarget moves whatever is at WorktreePath, and the registration of a
+// worktree being replaced, out of the way so the new worktree can take its
+// place. A backend that ties the replaced worktree to its path, like an
+// overlay mount, detaches it first. Nothing is deleted until discard, so
+// restore can put it all back.
+func (w *Worktree) displaceTarget() (*displacedTarget, error) {
+	displaced := &displacedTarget{path: w.WorktreePath}
+
+	if w.replaceRegistered {
+		commonDir := w.commonDir()
+		if id := worktreeIDForPath(commonDir, w.WorktreePath); id != "" {
+			displaced.gitDir = filepath.Join(commonDir, "worktrees", id)
+			displaced.gitDirAside = filepath.Join(commonDir, ".coworktree-replaced-"+id)
+			// The backend it was created with may hold state for the path, like a mount
+			if meta, err := readWorktreeMetadata(displaced.gitDir); err == nil {
+				displaced.backend, _ = LookupBackend(meta.Backend)
+			}
+			if err := os.Rename(displaced.gitDir, displaced.gitDirAside); err != nil {
+				return nil, fmt.Errorf("failed to unregister worktree at %s: %w", w.WorktreePath, err)
+			}
+		}
+	}
+
+	if backend, ok := displaced.backend.(displaceableBackend); ok {
+		restore, release, err := backend.Displace(w.WorktreePath)
+		if err != nil {
+			displaced.restore()
+			return nil, fmt.Errorf("failed to detach %s: %w", w.WorktreePath, err)
+		}
+		displaced.restoreBackend, displaced.releaseBackend = restore, release
+	}
+
+	if _, err := os.Lstat(w.WorktreePath); err == nil {
+		aside, err := os.MkdirTemp(filepath.Dir(w.WorktreePath), ".coworktree-replaced-*")
+		if err == nil {
+			displaced.aside = aside
+			err = os.Rename(w.WorktreePath, filepath.Join(aside, filepath.Base(w.WorktreePath)))
+		}
+		if err != nil {
+			displaced.restore()
+			return nil, fmt.Errorf("failed to move %s aside: %w", w.WorktreePath, err)
+		}
+	}
+	return displaced, nil
+}
+
+// restore puts the displaced target and registration back
+func (d *displacedTarget) restore() error {
+	var errs []error
+	if d.aside != "" {
+		moved := filepath.Join(d.aside, filepath.Base(d.path))
+		if _, err := os.Lstat(moved); err == nil {
+			if err := os.Rename(moved, d.path); err != nil {
+				errs = append(errs, fmt.Errorf("failed to restore %s (it is kept in %s): %w", d.path, d.aside, err))
+			}
+		}
+		os.Remove(d.aside)
+	}
+	if d.restoreBackend != nil {
+		if err := d.restoreBackend(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if d.gitDir != "" {
+		if err := os.Rename(d.gitDirAside, d.gitDir); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore %s: %w", d.gitDir, err))
+		}
+	}
+	return combineErrors(errs)
+}
+
+// discard deletes the displaced target and registration for good
+func (d *displacedTarget) discard() error {
+	var errs []error
+	if d.releaseBackend != nil {
+		if err := d.releaseBackend(); err != nil {
+			errs = append(errs, err)
+		}
+	} else if d.backend != nil && d.aside != "" {
+		if err := d.backend.Cleanup(filepath.Join(d.aside, filepath.Base(d.path))); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if d.aside != "" {
+		if err := os.RemoveAll(d.aside); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if d.gitDir != "" {
+		if err := os.RemoveAll(d.gitDirAside); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return combineErrors(errs)
+}
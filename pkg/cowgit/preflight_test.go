@@ -0,0 +1,187 @@
+package cowgit
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestPreflightRefusesUnsafeTargets(t *testing.T) {
+	tempDir := t.TempDir()
+	repoDir := filepath.Join(tempDir, "repo")
+	setupCompatRepo(t, repoDir)
+
+	otherRepo := filepath.Join(tempDir, "other")
+	setupCompatRepo(t, otherRepo)
+
+	nonEmpty := filepath.Join(tempDir, "non-empty")
+	if err := os.MkdirAll(nonEmpty, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(nonEmpty, "keep.txt"), []byte("precious"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	file := filepath.Join(tempDir, "file")
+	if err := os.WriteFile(file, []byte("precious"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	empty := filepath.Join(tempDir, "empty")
+	if err := os.MkdirAll(empty, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	// A symlink must not hide that the target is inside the source
+	link := filepath.Join(tempDir, "link")
+	if err := os.Symlink(repoDir, link); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		force  bool
+		want   error
+		intact string
+	}{
+		{"missing", filepath.Join(tempDir, "new", "wt"), false, nil, ""},
+		{"empty dir", empty, false, nil, ""},
+		{"non-empty dir", nonEmpty, false, ErrTargetExists, filepath.Join(nonEmpty, "keep.txt")},
+		{"file", file, false, ErrTargetExists, file},
+		{"non-empty dir with force", nonEmpty, true, nil, filepath.Join(nonEmpty, "keep.txt")},
+		{"source itself", repoDir, true, ErrTargetInsideSource, ""},
+		{"inside source", filepath.Join(repoDir, "sub", "wt"), false, ErrTargetInsideSource, ""},
+		{"inside source through symlink", filepath.Join(link, "wt"), false, ErrTargetInsideSource, ""},
+		{"contains source", tempDir, true, ErrTargetContainsSource, ""},
+		{"other repository", otherRepo, false, ErrTargetOtherRepository, ""},
+		{"other repository with force", otherRepo, true, ErrTargetOtherRepository, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			worktree := NewWorktree(repoDir, tt.path, "preflight")
+			worktree.Force = tt.force
+			err := worktree.Preflight()
+			if tt.want == nil && err != nil {
+				t.Errorf("Preflight() = %v, want success", err)
+			} else if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("Preflight() = %v, want %v", err, tt.want)
+			}
+			// Preflight never touches the target
+			if tt.intact != "" {
+				if content, err := os.ReadFile(tt.intact); err != nil || string(content) != "precious" {
+					t.Errorf("%s was modified: %q, %v", tt.intact, content, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateRefusesExistingTarget(t *testing.T) {
+	tempDir := t.TempDir()
+	repoDir := filepath.Join(tempDir, "repo")
+	setupCompatRepo(t, repoDir)
+
+	target := filepath.Join(tempDir, "wt")
+	if err := os.MkdirAll(target, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(target, "keep.txt"), []byte("precious"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	// Neither creation path may delete the directory
+	for _, noCoW := range []bool{false, true} {
+		manager, err := NewManager(repoDir)
+		if err != nil {
+			t.Fatalf("NewManager failed: %v", err)
+		}
+		_, err = manager.Create(CreateOptions{BranchName: "refused", WorktreePath: target, NoCoW: noCoW, Backend: BackendCopy, Fallback: FallbackCopy})
+		if !errors.Is(err, ErrTargetExists) {
+			t.Errorf("Create(NoCoW=%t) error = %v, want ErrTargetExists", noCoW, err)
+		}
+		if content, err := os.ReadFile(filepath.Join(target, "keep.txt")); err != nil || string(content) != "precious" {
+			t.Errorf("Create(NoCoW=%t) modified the target: %q, %v", noCoW, content, err)
+		}
+		if exec.Command("git", "-C", repoDir, "show-ref", "--verify", "--quiet", "refs/heads/refused").Run() == nil {
+			t.Errorf("Create(NoCoW=%t) created the branch", noCoW)
+		}
+	}
+}
+
+func TestForceReplacesRegisteredWorktree(t *testing.T) {
+	defer func() { transactionHook = nil }()
+
+	tempDir := t.TempDir()
+	repoDir := filepath.Join(tempDir, "repo")
+	setupCompatRepo(t, repoDir)
+	target := filepath.Join(tempDir, "wt")
+
+	create := func(branch string, force bool) (*Worktree, error) {
+		worktree := NewWorktreeWithOptions(repoDir, target, branch, true)
+		worktree.Backend = BackendCopy
+		worktree.Fallback = FallbackCopy
+		worktree.Force = force
+		return worktree, worktree.CreateCoWWorktree()
+	}
+
+	first, err := create("first", false)
+	if err != nil {
+		t.Fatalf("Failed to create first worktree: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(target, "scratch.txt"), []byte("scratch"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	if _, err := create("second", false); !errors.Is(err, ErrTargetExists) {
+		t.Fatalf("create without force error = %v, want ErrTargetExists", err)
+	}
+
+	// A failed replacement puts the old worktree and its registration back
+	injected := errors.New("injected failure")
+	transactionHook = func(step string) error {
+		if step == "HEAD" {
+			return injected
+		}
+		return nil
+	}
+	_, err = create("second", true)
+	transactionHook = nil
+	if !errors.Is(err, injected) {
+		t.Fatalf("failed replacement error = %v, want injected failure", err)
+	}
+	if content, err := os.ReadFile(filepath.Join(target, "scratch.txt")); err != nil || string(content) != "scratch" {
+		t.Errorf("old worktree not restored: %q, %v", content, err)
+	}
+	if branch := currentBranch(t, target); branch != "first" {
+		t.Errorf("restored worktree is on %q, want first", branch)
+	}
+
+	second, err := create("second", true)
+	if err != nil {
+		t.Fatalf("Failed to replace worktree: %v", err)
+	}
+	if second.ID != first.ID {
+		t.Errorf("replacement ID = %q, want the freed id %q", second.ID, first.ID)
+	}
+	if _, err := os.Stat(filepath.Join(target, "scratch.txt")); !os.IsNotExist(err) {
+		t.Error("old worktree's files survived the replacement")
+	}
+	if branch := currentBranch(t, target); branch != "second" {
+		t.Errorf("replaced worktree is on %q, want second", branch)
+	}
+
+	// Nothing is left aside, and git sees exactly one worktree at the path
+	matches, _ := filepath.Glob(filepath.Join(tempDir, ".coworktree-replaced-*"))
+	gitMatches, _ := filepath.Glob(filepath.Join(repoDir, ".git", ".coworktree-replaced-*"))
+	if len(matches)+len(gitMatches) > 0 {
+		t.Errorf("replaced state left behind: %v %v", matches, gitMatches)
+	}
+	infos, err := ListWorktrees(repoDir)
+	if err != nil {
+		t.Fatalf("ListWorktrees failed: %v", err)
+	}
+	if len(infos) != 2 {
+		t.Errorf("ListWorktrees returned %d worktrees, want 2: %+v", len(infos), infos)
+	}
+}
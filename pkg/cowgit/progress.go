@@ -14,7 +14,15 @@ type ProgressTracker struct {
 	spinner     *spinner.Spinner
 	startTime   time.Time
 	showSpinner bool
+	quiet       bool
 	stage       string
+	stages      []StageTiming
+}
+
+// StageTiming records how long a finished stage took
+type StageTiming struct {
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"duration_ns"`
 }
 
 // NewProgressTracker creates a new progress tracker
@@ -50,6 +58,7 @@ func (p *ProgressTracker) UpdateStage(info string) {
 // FinishStage completes the current stage and shows timing
 func (p *ProgressTracker) FinishStage() {
 	elapsed := time.Since(p.startTime)
+	p.stages = append(p.stages, StageTiming{Name: p.stage, Duration: elapsed})
 	
 	if p.showSpinner {
 		p.spinner.Stop()
@@ -66,6 +75,7 @@ func (p *ProgressTracker) FinishStage() {
 // FinishStageWithInfo completes the stage with additional info
 func (p *ProgressTracker) FinishStageWithInfo(info string) {
 	elapsed := time.Since(p.startTime)
+	p.stages = append(p.stages, StageTiming{Name: p.stage, Duration: elapsed})
 	
 	if p.showSpinner {
 		p.spinner.Stop()
@@ -86,14 +96,23 @@ func (p *ProgressTracker) Error(err error) {
 		p.spinner.Stop()
 	}
 	
+	if p.quiet {
+		return
+	}
 	red := color.New(color.FgRed).SprintFunc()
 	fmt.Printf("✗ %s %s\n", red("Error:"), err.Error())
 }
 
-// SetQuiet disables spinner output
+// Stages returns the stages finished so far, in order, with their durations
+func (p *ProgressTracker) Stages() []StageTiming {
+	return p.stages
+}
+
+// SetQuiet disables spinner output and error messages; stage timings are still recorded
 func (p *ProgressTracker) SetQuiet(quiet bool) {
 	if quiet {
 		p.showSpinner = false
+		p.quiet = true
 	}
 	// Don't re-enable if it was disabled - let the constructor logic handle the initial state
 }
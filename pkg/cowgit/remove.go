@@ -0,0 +1,290 @@
+package cowgit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrDirtyWorktree is returned when a worktree to remove has uncommitted
+	// changes or untracked files
+	ErrDirtyWorktree = errors.New("worktree has uncommitted changes")
+	// ErrUnpushedCommits is returned when a worktree to remove has commits
+	// that are neither on its upstream nor, without one, on any other branch or tag
+	ErrUnpushedCommits = errors.New("worktree has unpushed commits")
+	// ErrBranchNotMerged is returned when the branch to delete is not merged
+	// into its upstream or, without one, into the current HEAD
+	ErrBranchNotMerged = errors.New("branch is not fully merged")
+	// ErrWorktreeLocked is returned when a worktree to remove is locked with git worktree lock
+	ErrWorktreeLocked = errors.New("worktree is locked")
+	// ErrWorktreeNotFound is returned when no linked worktree matches a path or branch
+	ErrWorktreeNotFound = errors.New("worktree not found")
+	// ErrMainWorktree is returned when asked to remove the main worktree
+	ErrMainWorktree = errors.New("the main worktree cannot be removed")
+)
+
+// RemoveOptions controls how a worktree is removed
+type RemoveOptions struct {
+	// Force removes the worktree despite uncommitted or unpushed work or a
+	// lock, and deletes its branch even if it is not merged
+	Force bool
+	// DeleteBranch deletes the branch checked out in the worktree once it is removed
+	DeleteBranch bool
+}
+
+// RemoveResult describes a removed worktree
+type RemoveResult struct {
+	// Branch is the branch that was checked out in the worktree; empty if detached
+	Branch string
+	// BranchDeleted is set when Branch was deleted
+	BranchDeleted bool
+	// Reclaimed estimates the disk space freed in bytes (see Worktree.DiskUsage)
+	Reclaimed int64
+}
+
+// FindWorktree returns the linked worktree at path target, or else the one
+// with branch target checked out
+func (m *Manager) FindWorktree(target string) (WorktreeInfo, error) {
+	worktrees, err := ListWorktrees(m.RepoPath)
+	if err != nil {
+		return WorktreeInfo{}, fmt.Errorf("failed to list worktrees: %w", err)
+	}
+
+	// git lists absolute, symlink-free paths
+	path, err := filepath.Abs(target)
+	if err == nil {
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			path = resolved
+		}
+	}
+	for _, byPath := range []bool{true, false} {
+		for i, wt := range worktrees {
+			if (byPath && wt.Path == path) || (!byPath && wt.Branch == target) {
+				if i == 0 {
+					return WorktreeInfo{}, fmt.Errorf("%w: %s", ErrMainWorktree, wt.Path)
+				}
+				return wt, nil
+			}
+		}
+	}
+	return WorktreeInfo{}, fmt.Errorf("%w: no worktree at %s or with branch %s checked out", ErrWorktreeNotFound, path, target)
+}
+
+// RemoveWorktree removes the linked worktree at path target or with branch
+// target checked out, refusing if it has work that would be lost (see
+// Worktree.RemoveWithOptions)
+func (m *Manager) RemoveWorktree(target string, opts RemoveOptions) (*RemoveResult, error) {
+	info, err := m.FindWorktree(target)
+	if err != nil {
+		return nil, err
+	}
+
+	worktree := NewWorktree(m.RepoPath, info.Path, info.Branch)
+	worktree.LockTimeout = m.LockTimeout
+	worktree.Logger = m.Logger
+	return worktree.RemoveWithOptions(opts)
+}
+
+// CheckRemove reports why RemoveWithOptions would refuse to remove the
+// worktree, or nil if it would go ahead. BranchName is set to the branch
+// checked out in the worktree.
+func (w *Worktree) CheckRemove(opts RemoveOptions) error {
+	_, err := w.checkRemove(opts)
+	return err
+}
+
+// RemoveWithOptions removes the worktree after checking that no work would be
+// lost: it refuses when the worktree has uncommitted changes or untracked
+// files, commits that are not on its upstream (or, without one, on any other
+// branch or tag), or a lock, unless opts.Force is set. Backend state such as
+// an overlay mount is released first. With opts.DeleteBranch the branch is
+// deleted too, if it is merged into its upstream or the current HEAD.
+func (w *Worktree) RemoveWithOptions(opts RemoveOptions) (*RemoveResult, error) {
+	lock, err := w.lockRepository()
+	if err != nil {
+		return nil, err
+	}
+	defer lock.close()
+
+	info, err := w.checkRemove(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RemoveResult{Branch: w.BranchName}
+	if usage, err := w.DiskUsage(); err == nil {
+		result.Reclaimed = usage
+	}
+
+	if info.Locked {
+		if output, err := w.runGitCommandCombined(w.RepoPath, "worktree", "unlock", w.WorktreePath); err != nil {
+			return nil, fmt.Errorf("failed to unlock worktree: %w: %s", err, strings.TrimSpace(string(output)))
+		}
+	}
+	if _, err := os.Stat(w.WorktreePath); err == nil {
+		if err := w.removeWorktree(); err != nil {
+			return nil, err
+		}
+	} else if err := w.prune(); err != nil {
+		return nil, err
+	}
+
+	if opts.DeleteBranch && w.BranchName != "" {
+		if output, err := w.runGitCommandCombined(w.RepoPath, "branch", "-D", w.BranchName); err != nil {
+			return result, fmt.Errorf("failed to delete branch %s: %w: %s", w.BranchName, err, strings.TrimSpace(string(output)))
+		}
+		result.BranchDeleted = true
+	}
+	return result, nil
+}
+
+// checkRemove finds the worktree's registration and applies the checks of
+// RemoveWithOptions
+func (w *Worktree) checkRemove(opts RemoveOptions) (WorktreeInfo, error) {
+	info, err := w.registration()
+	if err != nil {
+		return info, err
+	}
+	w.WorktreePath = info.Path
+	w.BranchName = info.Branch
+
+	if isWithin(w.RepoPath, w.WorktreePath) {
+		return info, fmt.Errorf("cannot remove %s from inside it", w.WorktreePath)
+	}
+	if opts.Force {
+		return info, nil
+	}
+
+	if info.Locked {
+		return info, fmt.Errorf("%w: %s", ErrWorktreeLocked, w.WorktreePath)
+	}
+	if _, err := os.Stat(w.WorktreePath); err == nil {
+		output, err := w.runGitCommand(w.WorktreePath, "status", "--porcelain", "--ignore-submodules=none")
+		if err != nil {
+			return info, fmt.Errorf("failed to check worktree status: %w", err)
+		}
+		if changed := strings.Count(string(output), "\n"); changed > 0 {
+			return info, fmt.Errorf("%w: %d changed or untracked paths in %s", ErrDirtyWorktree, changed, w.WorktreePath)
+		}
+	}
+
+	if info.HEAD != "" {
+		count, base, err := w.unpushedCommits(info.HEAD)
+		if err != nil {
+			return info, err
+		}
+		if count > 0 {
+			return info, fmt.Errorf("%w: %d commits in %s are not on %s", ErrUnpushedCommits, count, w.WorktreePath, base)
+		}
+	}
+
+	if opts.DeleteBranch && w.BranchName != "" {
+		target := w.upstream()
+		if target == "" {
+			target = "HEAD"
+		}
+		if _, err := w.runGitCommand(w.RepoPath, "merge-base", "--is-ancestor", "refs/heads/"+w.BranchName, target); err != nil {
+			return info, fmt.Errorf("%w: %s is not merged into %s", ErrBranchNotMerged, w.BranchName, target)
+		}
+	}
+	return info, nil
+}
+
+// registration returns the worktree's entry in git worktree list
+func (w *Worktree) registration() (WorktreeInfo, error) {
+	if _, err := w.repository(); err != nil {
+		return WorktreeInfo{}, err
+	}
+	worktrees, err := ListWorktrees(w.RepoPath)
+	if err != nil {
+		return WorktreeInfo{}, err
+	}
+
+	path, err := filepath.Abs(w.WorktreePath)
+	if err != nil {
+		return WorktreeInfo{}, fmt.Errorf("failed to resolve absolute path for %s: %w", w.WorktreePath, err)
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	for i, wt := range worktrees {
+		if wt.Path == path {
+			if i == 0 {
+				return wt, fmt.Errorf("%w: %s", ErrMainWorktree, path)
+			}
+			return wt, nil
+		}
+	}
+	return WorktreeInfo{}, fmt.Errorf("%w: %s is not a worktree of this repository", ErrWorktreeNotFound, path)
+}
+
+// unpushedCommits counts the commits reachable from head that are not on the
+// branch's upstream or, without one, on any other branch, remote-tracking
+// branch or tag, and returns what they were compared against
+func (w *Worktree) unpushedCommits(head string) (int, string, error) {
+	args := []string{"rev-list", "--count", head, "--not"}
+	base := w.upstream()
+	if base != "" {
+		args = append(args, base)
+	} else {
+		if w.BranchName != "" {
+			// --branches matches --exclude patterns against names without refs/heads/
+			args = append(args, "--exclude="+w.BranchName)
+		}
+		args = append(args, "--branches", "--remotes", "--tags")
+		base = "any other branch or tag"
+	}
+
+	output, err := w.runGitCommand(w.RepoPath, args...)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to count unpushed commits: %w", err)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to parse commit count %q: %w", output, err)
+	}
+	return count, base, nil
+}
+
+// upstream returns the full ref name of the branch's upstream, or an empty
+// string if it is detached, has none, or the upstream is gone
+func (w *Worktree) upstream() string {
+	if w.BranchName == "" {
+		return ""
+	}
+	output, err := w.runGitCommand(w.RepoPath, "rev-parse", "--verify", "--quiet", "--symbolic-full-name", w.BranchName+"@{upstream}")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// DiskUsage estimates the disk space the worktree uses on its own: its files
+// and its git directory, leaving out files hardlinked from elsewhere and, on
+// Linux, blocks still shared with the source through reflinks. Backends that
+// keep a clone's data outside the worktree, like overlay, report that instead.
+// clonefile clones on macOS are counted in full, so they free less than this
+// until they diverge.
+func (w *Worktree) DiskUsage() (int64, error) {
+	var usage int64
+	var err error
+	if reporter, ok := w.recordedBackend().(diskUsageReporter); ok {
+		usage, err = reporter.DiskUsage(w.WorktreePath)
+	} else {
+		usage, err = DiskUsage(w.WorktreePath)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if gitDir, err := resolveWorktreeGitDir(w.WorktreePath); err == nil {
+		if gitDirUsage, err := DiskUsage(gitDir); err == nil {
+			usage += gitDirUsage
+		}
+	}
+	return usage, nil
+}
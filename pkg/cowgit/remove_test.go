@@ -0,0 +1,218 @@
+package cowgit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoveWorktreeRefusesToLoseWork(t *testing.T) {
+	tempDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to resolve temp dir: %v", err)
+	}
+	repoDir := filepath.Join(tempDir, "repo")
+	setupCompatRepo(t, repoDir)
+
+	manager, err := NewManager(repoDir)
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	create := func(branch string) string {
+		t.Helper()
+		path := filepath.Join(tempDir, branch)
+		if _, err := manager.Create(CreateOptions{BranchName: branch, WorktreePath: path, Backend: BackendCopy, Fallback: FallbackCopy}); err != nil {
+			t.Fatalf("Create %s failed: %v", branch, err)
+		}
+		return path
+	}
+
+	// Uncommitted changes and untracked files
+	dirty := create("dirty")
+	if err := os.WriteFile(filepath.Join(dirty, "scratch.txt"), []byte("notes"), 0644); err != nil {
+		t.Fatalf("Failed to write scratch.txt: %v", err)
+	}
+	if _, err := manager.RemoveWorktree(dirty, RemoveOptions{}); !errors.Is(err, ErrDirtyWorktree) {
+		t.Errorf("RemoveWorktree of a dirty worktree = %v, want ErrDirtyWorktree", err)
+	}
+
+	// Commits that are on no other branch
+	committed := create("committed")
+	if err := runCommand(committed, "git", "commit", "-q", "--allow-empty", "-m", "wip"); err != nil {
+		t.Fatalf("git commit failed: %v", err)
+	}
+	if _, err := manager.RemoveWorktree("committed", RemoveOptions{}); !errors.Is(err, ErrUnpushedCommits) {
+		t.Errorf("RemoveWorktree with unpushed commits = %v, want ErrUnpushedCommits", err)
+	}
+	// Once they are on the upstream they are safe, and the branch counts as merged
+	if err := runCommand(repoDir, "git", "branch", "pushed", "committed"); err != nil {
+		t.Fatalf("git branch failed: %v", err)
+	}
+	if err := runCommand(repoDir, "git", "branch", "--set-upstream-to=pushed", "committed"); err != nil {
+		t.Fatalf("git branch --set-upstream-to failed: %v", err)
+	}
+	check := NewWorktree(repoDir, committed, "")
+	if err := check.CheckRemove(RemoveOptions{DeleteBranch: true}); err != nil {
+		t.Errorf("CheckRemove with commits on the upstream = %v", err)
+	}
+	if check.BranchName != "committed" {
+		t.Errorf("CheckRemove BranchName = %q", check.BranchName)
+	}
+	// Without an upstream the commits are still on another branch, but the
+	// branch is not merged into HEAD
+	if err := runCommand(repoDir, "git", "branch", "--unset-upstream", "committed"); err != nil {
+		t.Fatalf("git branch --unset-upstream failed: %v", err)
+	}
+	if err := check.CheckRemove(RemoveOptions{}); err != nil {
+		t.Errorf("CheckRemove with commits on another branch = %v", err)
+	}
+	if _, err := manager.RemoveWorktree("committed", RemoveOptions{DeleteBranch: true}); !errors.Is(err, ErrBranchNotMerged) {
+		t.Errorf("RemoveWorktree deleting an unmerged branch = %v, want ErrBranchNotMerged", err)
+	}
+
+	locked := create("locked")
+	if err := runCommand(repoDir, "git", "worktree", "lock", locked); err != nil {
+		t.Fatalf("git worktree lock failed: %v", err)
+	}
+	if _, err := manager.RemoveWorktree(locked, RemoveOptions{}); !errors.Is(err, ErrWorktreeLocked) {
+		t.Errorf("RemoveWorktree of a locked worktree = %v, want ErrWorktreeLocked", err)
+	}
+
+	// Every refusal leaves the worktree and its branch in place
+	for _, path := range []string{dirty, committed, locked} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s was removed: %v", path, err)
+		}
+	}
+
+	// Force overrides them all
+	for _, target := range []string{dirty, "committed", locked} {
+		result, err := manager.RemoveWorktree(target, RemoveOptions{Force: true, DeleteBranch: true})
+		if err != nil {
+			t.Fatalf("forced RemoveWorktree %s failed: %v", target, err)
+		}
+		if !result.BranchDeleted {
+			t.Errorf("branch %s was not deleted", result.Branch)
+		}
+		if branchExists(t, repoDir, result.Branch) {
+			t.Errorf("branch %s still exists", result.Branch)
+		}
+	}
+	infos, err := ListWorktrees(repoDir)
+	if err != nil {
+		t.Fatalf("ListWorktrees failed: %v", err)
+	}
+	if len(infos) != 1 {
+		t.Errorf("worktrees left after removal: %+v", infos)
+	}
+}
+
+func TestRemoveWorktreeDeletesMergedBranch(t *testing.T) {
+	tempDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to resolve temp dir: %v", err)
+	}
+	repoDir := filepath.Join(tempDir, "repo")
+	setupCompatRepo(t, repoDir)
+	if err := os.WriteFile(filepath.Join(repoDir, ".gitignore"), []byte("build/\n"), 0644); err != nil {
+		t.Fatalf("Failed to write .gitignore: %v", err)
+	}
+	if err := runCommand(repoDir, "git", "add", ".gitignore"); err != nil {
+		t.Fatalf("git add failed: %v", err)
+	}
+	if err := runCommand(repoDir, "git", "commit", "-q", "-m", "Ignore build"); err != nil {
+		t.Fatalf("git commit failed: %v", err)
+	}
+
+	manager, err := NewManager(repoDir)
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	path := filepath.Join(tempDir, "merged")
+	if _, err := manager.Create(CreateOptions{BranchName: "merged", WorktreePath: path, Backend: BackendCopy, Fallback: FallbackCopy}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	// Ignored build output doesn't make the worktree dirty, and is reclaimed
+	if err := os.MkdirAll(filepath.Join(path, "build"), 0755); err != nil {
+		t.Fatalf("Failed to create build: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(path, "build", "out.bin"), make([]byte, 1<<20), 0644); err != nil {
+		t.Fatalf("Failed to write build output: %v", err)
+	}
+
+	if _, err := manager.FindWorktree(repoDir); !errors.Is(err, ErrMainWorktree) {
+		t.Errorf("FindWorktree of the main worktree = %v", err)
+	}
+	if info, err := manager.FindWorktree("merged"); err != nil || info.Path != path {
+		t.Errorf("FindWorktree by branch = %+v, %v", info, err)
+	}
+
+	result, err := manager.RemoveWorktree(path, RemoveOptions{DeleteBranch: true})
+	if err != nil {
+		t.Fatalf("RemoveWorktree failed: %v", err)
+	}
+	if result.Branch != "merged" || !result.BranchDeleted {
+		t.Errorf("RemoveWorktree result = %+v", result)
+	}
+	if result.Reclaimed < 1<<20 {
+		t.Errorf("Reclaimed = %d, want at least the build output", result.Reclaimed)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("worktree still exists: %v", err)
+	}
+	if branchExists(t, repoDir, "merged") {
+		t.Error("branch merged still exists")
+	}
+}
+
+func TestDiskUsageSkipsSharedHardlinks(t *testing.T) {
+	tempDir := t.TempDir()
+	root := filepath.Join(tempDir, "root")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		t.Fatalf("Failed to create root: %v", err)
+	}
+	const size = 64 << 10
+	for _, name := range []string{"own", "shared", "inside"} {
+		if err := os.WriteFile(filepath.Join(root, name), make([]byte, size), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	base, err := DiskUsage(root)
+	if err != nil {
+		t.Fatalf("DiskUsage failed: %v", err)
+	}
+	if base < 3*size {
+		t.Fatalf("DiskUsage = %d, want at least %d", base, 3*size)
+	}
+
+	// A file also linked from outside root isn't freed by deleting root; one
+	// linked twice inside it is counted once
+	info, err := os.Stat(filepath.Join(root, "shared"))
+	if err != nil {
+		t.Fatalf("Failed to stat shared: %v", err)
+	}
+	if _, _, _, ok := diskStat(info); !ok {
+		t.Skip("link counts not available on this platform")
+	}
+	if err := os.Link(filepath.Join(root, "shared"), filepath.Join(tempDir, "outside")); err != nil {
+		t.Skipf("hardlinks not supported: %v", err)
+	}
+	if err := os.Link(filepath.Join(root, "inside"), filepath.Join(root, "inside2")); err != nil {
+		t.Fatalf("Failed to link inside: %v", err)
+	}
+	usage, err := DiskUsage(root)
+	if err != nil {
+		t.Fatalf("DiskUsage failed: %v", err)
+	}
+	if usage != base-size {
+		t.Errorf("DiskUsage with hardlinks = %d, want %d", usage, base-size)
+	}
+}
+
+// branchExists reports whether branch exists in the repository at dir
+func branchExists(t *testing.T, dir, branch string) bool {
+	t.Helper()
+	return runCommand(dir, "git", "show-ref", "--verify", "--quiet", "refs/heads/"+branch) == nil
+}
@@ -0,0 +1,143 @@
+package cowgit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Repository locates a checkout and the git directories behind it, as git
+// itself finds them
+type Repository struct {
+	// Root is the top-level directory of the checkout, which may be the main
+	// worktree or a linked one
+	Root string
+	// GitDir is the checkout's own git directory: .git for the main worktree,
+	// .git/worktrees/<id> for a linked one, or wherever GIT_DIR points
+	GitDir string
+	// CommonDir is the git directory shared by all worktrees, holding refs,
+	// objects, config and the worktree registrations
+	CommonDir string
+}
+
+// ErrNotRepository is returned when no git checkout contains the given path
+var ErrNotRepository = errors.New("not in a git work tree")
+
+// repositoryEnvVars are the variables that point git at a particular
+// repository instead of the one it discovers from its working directory
+var repositoryEnvVars = []string{
+	"GIT_DIR",
+	"GIT_WORK_TREE",
+	"GIT_COMMON_DIR",
+	"GIT_INDEX_FILE",
+	"GIT_PREFIX",
+	"GIT_IMPLICIT_WORK_TREE",
+}
+
+// DiscoverRepository finds the checkout containing path the way git does: from
+// a subdirectory, from inside a linked worktree, or through GIT_DIR and
+// GIT_WORK_TREE, which take precedence over path as they do for git
+func DiscoverRepository(path string) (*Repository, error) {
+	cmd := exec.Command("git", "-C", path, "rev-parse", "--path-format=absolute", "--show-toplevel", "--git-dir", "--git-common-dir")
+	output, err := cmd.Output()
+	if err != nil {
+		message := err.Error()
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			message = strings.TrimSpace(string(exitErr.Stderr))
+		}
+		return nil, fmt.Errorf("%w: %s: %s", ErrNotRepository, path, message)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) != 3 {
+		return nil, fmt.Errorf("failed to parse git rev-parse output for %s: %q", path, output)
+	}
+	return &Repository{
+		Root:      filepath.Clean(lines[0]),
+		GitDir:    filepath.Clean(lines[1]),
+		CommonDir: filepath.Clean(lines[2]),
+	}, nil
+}
+
+// IsLinkedWorktree reports whether the checkout is a linked worktree rather than the main one
+func (r *Repository) IsLinkedWorktree() bool {
+	return r.GitDir != r.CommonDir
+}
+
+// gitCommand prepares a git command that runs in the checkout and is pinned to
+// its git directory, which need not be Root/.git
+func (r *Repository) gitCommand(args ...string) *exec.Cmd {
+	cmd := gitCommand(r.Root, args...)
+	cmd.Env = append(cmd.Env, "GIT_DIR="+r.GitDir, "GIT_WORK_TREE="+r.Root)
+	return cmd
+}
+
+// cloneExcludes returns the clone exclude set, extended with the git
+// directories when GIT_DIR keeps them inside Root under another name
+func (r *Repository) cloneExcludes() []string {
+	excludes := append([]string(nil), DefaultCloneExclude...)
+	for _, dir := range []string{r.GitDir, r.CommonDir} {
+		if rel, err := filepath.Rel(r.Root, dir); err == nil && isWithin(dir, r.Root) && rel != "." && rel != ".git" {
+			excludes = append(excludes, filepath.ToSlash(rel))
+		}
+	}
+	return excludes
+}
+
+// gitCommand prepares a git command that runs in dir and discovers the
+// repository from there, ignoring any GIT_DIR or GIT_WORK_TREE coworktree
+// itself was started with
+func gitCommand(dir string, args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	for _, env := range os.Environ() {
+		name, _, _ := strings.Cut(env, "=")
+		if !isRepositoryEnvVar(name) {
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+	return cmd
+}
+
+// isRepositoryEnvVar reports whether name is one of repositoryEnvVars
+func isRepositoryEnvVar(name string) bool {
+	for _, v := range repositoryEnvVars {
+		if name == v {
+			return true
+		}
+	}
+	return false
+}
+
+// repository discovers the source checkout from RepoPath the first time it is
+// needed and normalizes RepoPath to the checkout's top-level directory
+func (w *Worktree) repository() (*Repository, error) {
+	if w.repo == nil {
+		repo, err := DiscoverRepository(w.RepoPath)
+		if err != nil {
+			return nil, err
+		}
+		w.repo = repo
+		w.RepoPath = repo.Root
+	}
+	return w.repo, nil
+}
+
+// gitDir returns the source checkout's own git directory
+func (w *Worktree) gitDir() string {
+	if w.repo != nil {
+		return w.repo.GitDir
+	}
+	return filepath.Join(w.RepoPath, ".git")
+}
+
+// commonDir returns the git directory shared by all of the repository's worktrees
+func (w *Worktree) commonDir() string {
+	if w.repo != nil {
+		return w.repo.CommonDir
+	}
+	return filepath.Join(w.RepoPath, ".git")
+}
@@ -0,0 +1,205 @@
+package cowgit
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiscoverRepository(t *testing.T) {
+	tempDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to resolve temp dir: %v", err)
+	}
+	repoDir := filepath.Join(tempDir, "repo")
+	setupCompatRepo(t, repoDir)
+	if err := os.MkdirAll(filepath.Join(repoDir, "sub", "dir"), 0755); err != nil {
+		t.Fatalf("Failed to create subdirectory: %v", err)
+	}
+	linked := filepath.Join(tempDir, "linked")
+	if output, err := exec.Command("git", "-C", repoDir, "worktree", "add", "-q", linked).CombinedOutput(); err != nil {
+		t.Fatalf("git worktree add failed: %v: %s", err, output)
+	}
+	commonDir := filepath.Join(repoDir, ".git")
+
+	tests := []struct {
+		name string
+		path string
+		want Repository
+	}{
+		{"root", repoDir, Repository{Root: repoDir, GitDir: commonDir, CommonDir: commonDir}},
+		{"subdirectory", filepath.Join(repoDir, "sub", "dir"), Repository{Root: repoDir, GitDir: commonDir, CommonDir: commonDir}},
+		{"linked worktree", linked, Repository{Root: linked, GitDir: filepath.Join(commonDir, "worktrees", "linked"), CommonDir: commonDir}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, err := DiscoverRepository(tt.path)
+			if err != nil {
+				t.Fatalf("DiscoverRepository failed: %v", err)
+			}
+			if *repo != tt.want {
+				t.Errorf("DiscoverRepository = %+v, want %+v", *repo, tt.want)
+			}
+			if repo.IsLinkedWorktree() != (tt.name == "linked worktree") {
+				t.Errorf("IsLinkedWorktree = %t", repo.IsLinkedWorktree())
+			}
+		})
+	}
+
+	if _, err := DiscoverRepository(tempDir); err == nil {
+		t.Error("DiscoverRepository outside a repository succeeded")
+	}
+
+	// GIT_DIR and GIT_WORK_TREE win over the path, as they do for git
+	t.Setenv("GIT_DIR", commonDir)
+	t.Setenv("GIT_WORK_TREE", repoDir)
+	repo, err := DiscoverRepository(tempDir)
+	if err != nil {
+		t.Fatalf("DiscoverRepository with GIT_DIR failed: %v", err)
+	}
+	if repo.Root != repoDir || repo.GitDir != commonDir {
+		t.Errorf("DiscoverRepository with GIT_DIR = %+v", *repo)
+	}
+}
+
+func TestCreateFromLinkedWorktree(t *testing.T) {
+	tempDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to resolve temp dir: %v", err)
+	}
+	repoDir := filepath.Join(tempDir, "repo")
+	setupCompatRepo(t, repoDir)
+
+	first := NewWorktreeWithOptions(repoDir, filepath.Join(tempDir, "first"), "first", true)
+	first.Backend = BackendCopy
+	first.Fallback = FallbackCopy
+	if err := first.CreateCoWWorktree(); err != nil {
+		t.Fatalf("Failed to create first worktree: %v", err)
+	}
+
+	// Give the linked worktree state of its own: a new commit, a staged file
+	// and an ignored build artifact
+	if err := os.MkdirAll(filepath.Join(first.WorktreePath, "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create sub: %v", err)
+	}
+	files := map[string]string{
+		"committed.txt": "committed in first",
+		"staged.txt":    "staged in first",
+		"build.log":     "ignored",
+		".gitignore":    "*.log\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(first.WorktreePath, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	for _, args := range [][]string{{"add", "committed.txt", ".gitignore"}, {"commit", "-q", "-m", "first"}, {"add", "staged.txt"}} {
+		if output, err := exec.Command("git", append([]string{"-C", first.WorktreePath}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, output)
+		}
+	}
+
+	// Started from a subdirectory of the linked worktree, the clone is of that
+	// worktree, and registration goes to the shared git directory
+	second := NewWorktreeWithOptions(repoDir, filepath.Join(tempDir, "second"), "second", true)
+	second.RepoPath = filepath.Join(first.WorktreePath, "sub")
+	second.Backend = BackendCopy
+	second.Fallback = FallbackCopy
+	if err := second.CreateCoWWorktree(); err != nil {
+		t.Fatalf("Failed to create worktree from linked worktree: %v", err)
+	}
+	if second.RepoPath != first.WorktreePath {
+		t.Errorf("RepoPath = %s, want the linked worktree %s", second.RepoPath, first.WorktreePath)
+	}
+
+	for name, content := range files {
+		if got, err := os.ReadFile(filepath.Join(second.WorktreePath, name)); err != nil || string(got) != content {
+			t.Errorf("%s = %q, %v; want %q", name, got, err, content)
+		}
+	}
+	if status := gitStatus(t, second.WorktreePath); status != "A  staged.txt" {
+		t.Errorf("git status = %q, want the staged file only", status)
+	}
+	want, _ := exec.Command("git", "-C", first.WorktreePath, "rev-parse", "HEAD").Output()
+	got, _ := exec.Command("git", "-C", second.WorktreePath, "rev-parse", "HEAD").Output()
+	if string(got) != string(want) {
+		t.Errorf("second HEAD = %s, want first's HEAD %s", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(repoDir, ".git", "worktrees", second.ID, "HEAD")); err != nil {
+		t.Errorf("second is not registered in the common git directory: %v", err)
+	}
+
+	infos, err := ListWorktrees(second.WorktreePath)
+	if err != nil {
+		t.Fatalf("ListWorktrees failed: %v", err)
+	}
+	if len(infos) != 3 || infos[0].Path != repoDir {
+		t.Errorf("ListWorktrees = %+v, want main, first and second", infos)
+	}
+}
+
+func TestCreateWithGitDirEnvironment(t *testing.T) {
+	tempDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to resolve temp dir: %v", err)
+	}
+
+	// A work tree whose git directory lives elsewhere, as with dotfile setups
+	gitDir := filepath.Join(tempDir, "dots.git")
+	home := filepath.Join(tempDir, "home")
+	if err := os.MkdirAll(home, 0755); err != nil {
+		t.Fatalf("Failed to create work tree: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".rc"), []byte("first"), 0644); err != nil {
+		t.Fatalf("Failed to write .rc: %v", err)
+	}
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"--git-dir=" + gitDir, "--work-tree=" + home}, args...)...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, output)
+		}
+	}
+	git("init", "-q")
+	git("config", "user.email", "test@example.com")
+	git("config", "user.name", "Test User")
+	git("add", ".rc")
+	git("commit", "-q", "-m", "first")
+	git("tag", "v1")
+	if err := os.WriteFile(filepath.Join(home, ".rc"), []byte("second"), 0644); err != nil {
+		t.Fatalf("Failed to write .rc: %v", err)
+	}
+	git("commit", "-q", "-a", "-m", "second")
+
+	// Commands run in the new worktree must not inherit GIT_DIR: checking out
+	// v1 there would otherwise rewrite the source checkout
+	t.Setenv("GIT_DIR", gitDir)
+	t.Setenv("GIT_WORK_TREE", home)
+	worktree := NewWorktreeWithOptions(home, filepath.Join(tempDir, "wt"), "from-v1", true)
+	worktree.Backend = BackendCopy
+	worktree.Fallback = FallbackCopy
+	worktree.FromCommit = "v1"
+	err = worktree.CreateCoWWorktree()
+	os.Unsetenv("GIT_DIR")
+	os.Unsetenv("GIT_WORK_TREE")
+	if err != nil {
+		t.Fatalf("CreateCoWWorktree with GIT_DIR failed: %v", err)
+	}
+
+	if content, _ := os.ReadFile(filepath.Join(home, ".rc")); string(content) != "second" {
+		t.Errorf("source .rc = %q, want it untouched", content)
+	}
+	if content, _ := os.ReadFile(filepath.Join(worktree.WorktreePath, ".rc")); string(content) != "first" {
+		t.Errorf("worktree .rc = %q, want v1's content", content)
+	}
+	if status := gitStatus(t, worktree.WorktreePath); status != "" {
+		t.Errorf("worktree is not clean:\n%s", status)
+	}
+	if _, err := os.Stat(filepath.Join(gitDir, "worktrees", worktree.ID)); err != nil {
+		t.Errorf("worktree not registered in %s: %v", gitDir, err)
+	}
+	if branch := currentBranch(t, worktree.WorktreePath); branch != "from-v1" {
+		t.Errorf("worktree is on %q, want from-v1", branch)
+	}
+}
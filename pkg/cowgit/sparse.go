@@ -0,0 +1,67 @@
+package cowgit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sparseConfigKeys are the settings that make a checkout sparse. With
+// extensions.worktreeConfig they are usually per worktree, in config.worktree.
+var sparseConfigKeys = []string{"core.sparseCheckout", "core.sparseCheckoutCone", "index.sparse"}
+
+// setupSparseCheckout makes the new worktree sparse the way the source is,
+// or with the Sparse set instead. Without it, git sees a cloned index full of
+// skip-worktree entries but no patterns, and the first command that updates
+// the worktree materializes the whole tree.
+func (w *Worktree) setupSparseCheckout() error {
+	if w.Sparse != nil {
+		return w.applySparseSet()
+	}
+	return w.copySparseCheckout()
+}
+
+// copySparseCheckout copies the source's sparse-checkout patterns into the
+// worktree's git directory. Settings in the shared config already apply to
+// the new worktree; per-worktree ones are copied into its config.worktree.
+func (w *Worktree) copySparseCheckout() error {
+	if w.sourceConfig("core.sparseCheckout", "--bool") != "true" {
+		return nil
+	}
+
+	patterns := filepath.Join("info", "sparse-checkout")
+	if err := os.MkdirAll(filepath.Join(w.registeredGitDir, "info"), 0755); err != nil {
+		return fmt.Errorf("failed to create info directory: %w", err)
+	}
+	if err := copyGitState(filepath.Join(w.gitDir(), patterns), filepath.Join(w.registeredGitDir, patterns)); err != nil {
+		return fmt.Errorf("failed to copy sparse-checkout patterns: %w", err)
+	}
+
+	if w.sourceConfig("extensions.worktreeConfig", "--bool") != "true" {
+		return nil
+	}
+	for _, key := range sparseConfigKeys {
+		if value := w.sourceConfig(key); value != "" {
+			if err := w.setWorktreeConfig(key, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applySparseSet runs git sparse-checkout set in the worktree, which enables
+// extensions.worktreeConfig so the set stays specific to it, and checks out or
+// removes tracked files to match
+func (w *Worktree) applySparseSet() error {
+	args := []string{"sparse-checkout", "set", "--cone"}
+	if w.SparseNoCone {
+		args[2] = "--no-cone"
+	}
+	args = append(append(args, "--"), w.Sparse...)
+	if output, err := w.runGitCommandCombined(w.WorktreePath, args...); err != nil {
+		return fmt.Errorf("failed to set sparse-checkout patterns: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package cowgit
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupSparseRepo creates a repository with top-level directories a, b and c
+func setupSparseRepo(t *testing.T, repoDir string) {
+	t.Helper()
+	setupCompatRepo(t, repoDir)
+	for _, dir := range []string{"a", "b", "c"} {
+		if err := os.MkdirAll(filepath.Join(repoDir, dir), 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+		if err := os.WriteFile(filepath.Join(repoDir, dir, "file.txt"), []byte(dir), 0644); err != nil {
+			t.Fatalf("Failed to write %s/file.txt: %v", dir, err)
+		}
+	}
+	for _, args := range [][]string{{"add", "."}, {"commit", "-q", "-m", "Add directories"}} {
+		if output, err := exec.Command("git", append([]string{"-C", repoDir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, output)
+		}
+	}
+}
+
+// sparseList returns git sparse-checkout list in dir
+func sparseList(t *testing.T, dir string) string {
+	t.Helper()
+	output, err := exec.Command("git", "-C", dir, "sparse-checkout", "list").CombinedOutput()
+	if err != nil {
+		t.Fatalf("git sparse-checkout list in %s failed: %v: %s", dir, err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// checkedOut reports which of the directories a, b and c exist in dir
+func checkedOut(dir string) string {
+	var present []string
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := os.Stat(filepath.Join(dir, name, "file.txt")); err == nil {
+			present = append(present, name)
+		}
+	}
+	return strings.Join(present, ",")
+}
+
+func TestCreatePropagatesSparseCheckout(t *testing.T) {
+	setups := []struct {
+		name  string
+		setup [][]string
+		want  string
+	}{
+		// git sparse-checkout keeps its settings in config.worktree
+		{"per-worktree", [][]string{{"sparse-checkout", "set", "a"}}, "a"},
+		// Older setups enable it for every worktree in the shared config
+		{"shared config", [][]string{{"config", "core.sparseCheckout", "true"}, {"read-tree", "-mu", "HEAD"}}, "/*.txt\n/a/"},
+	}
+
+	for _, setup := range setups {
+		t.Run(setup.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			repoDir := filepath.Join(tempDir, "repo")
+			setupSparseRepo(t, repoDir)
+			if err := os.WriteFile(filepath.Join(repoDir, ".git", "info", "sparse-checkout"), []byte("/*.txt\n/a/\n"), 0644); err != nil {
+				t.Fatalf("Failed to write patterns: %v", err)
+			}
+			for _, args := range setup.setup {
+				if output, err := exec.Command("git", append([]string{"-C", repoDir}, args...)...).CombinedOutput(); err != nil {
+					t.Fatalf("git %v failed: %v: %s", args, err, output)
+				}
+			}
+			if got := checkedOut(repoDir); got != "a" {
+				t.Fatalf("source has %q checked out, want a", got)
+			}
+
+			worktree := NewWorktreeWithOptions(repoDir, filepath.Join(tempDir, "wt"), "sparse", true)
+			worktree.Backend = BackendCopy
+			worktree.Fallback = FallbackCopy
+			if err := worktree.CreateCoWWorktree(); err != nil {
+				t.Fatalf("CreateCoWWorktree failed: %v", err)
+			}
+
+			if status := gitStatus(t, worktree.WorktreePath); status != "" {
+				t.Errorf("worktree is not clean:\n%s", status)
+			}
+			if got := sparseList(t, worktree.WorktreePath); got != setup.want {
+				t.Errorf("sparse-checkout list = %q, want %q", got, setup.want)
+			}
+			// Updating the worktree keeps to the sparse set instead of materializing everything
+			if err := runCommand(worktree.WorktreePath, "git", "read-tree", "-mu", "HEAD"); err != nil {
+				t.Fatalf("git read-tree failed: %v", err)
+			}
+			if got := checkedOut(worktree.WorktreePath); got != "a" {
+				t.Errorf("worktree has %q checked out, want a", got)
+			}
+		})
+	}
+}
+
+func TestCreateWithOwnSparseSet(t *testing.T) {
+	tempDir := t.TempDir()
+	repoDir := filepath.Join(tempDir, "repo")
+	setupSparseRepo(t, repoDir)
+
+	// From a full checkout, only the new worktree becomes sparse
+	narrow := NewWorktreeWithOptions(repoDir, filepath.Join(tempDir, "narrow"), "narrow", true)
+	narrow.Backend = BackendCopy
+	narrow.Fallback = FallbackCopy
+	narrow.Sparse = []string{"b", "c"}
+	if err := narrow.CreateCoWWorktree(); err != nil {
+		t.Fatalf("CreateCoWWorktree failed: %v", err)
+	}
+	if got := checkedOut(narrow.WorktreePath); got != "b,c" {
+		t.Errorf("worktree has %q checked out, want b,c", got)
+	}
+	if status := gitStatus(t, narrow.WorktreePath); status != "" {
+		t.Errorf("worktree is not clean:\n%s", status)
+	}
+	if err := runCommand(repoDir, "git", "read-tree", "-mu", "HEAD"); err != nil {
+		t.Fatalf("git read-tree failed: %v", err)
+	}
+	if got := checkedOut(repoDir); got != "a,b,c" {
+		t.Errorf("source has %q checked out, want a,b,c", got)
+	}
+
+	// From a sparse checkout, the new set replaces the source's
+	if output, err := exec.Command("git", "-C", repoDir, "sparse-checkout", "set", "a").CombinedOutput(); err != nil {
+		t.Fatalf("git sparse-checkout set failed: %v: %s", err, output)
+	}
+	other := NewWorktreeWithOptions(repoDir, filepath.Join(tempDir, "other"), "other", true)
+	other.Backend = BackendCopy
+	other.Fallback = FallbackCopy
+	other.Sparse = []string{"/c/"}
+	other.SparseNoCone = true
+	if err := other.CreateCoWWorktree(); err != nil {
+		t.Fatalf("CreateCoWWorktree failed: %v", err)
+	}
+	if got := checkedOut(other.WorktreePath); got != "c" {
+		t.Errorf("worktree has %q checked out, want c", got)
+	}
+	if got := sparseList(t, repoDir); got != "a" {
+		t.Errorf("source sparse-checkout list = %q, want a", got)
+	}
+}
@@ -0,0 +1,33 @@
+//go:build linux
+
+package cowgit
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// indexStat extracts the stat fields git caches in its index
+func indexStat(info os.FileInfo) (indexStatData, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return indexStatData{}, false
+	}
+	return indexStatData{
+		ctime: time.Unix(stat.Ctim.Unix()),
+		dev:   uint32(stat.Dev),
+		ino:   uint32(stat.Ino),
+		uid:   stat.Uid,
+		gid:   stat.Gid,
+	}, true
+}
+
+// diskStat returns the disk space a file takes up, its inode and its link count
+func diskStat(info os.FileInfo) (int64, inodeKey, uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.Size(), inodeKey{}, 1, false
+	}
+	return stat.Blocks * 512, inodeKey{uint64(stat.Dev), uint64(stat.Ino)}, uint64(stat.Nlink), true
+}
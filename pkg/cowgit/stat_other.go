@@ -0,0 +1,15 @@
+//go:build !darwin && !linux
+
+package cowgit
+
+import "os"
+
+// indexStat is not implemented on this platform; callers fall back to letting git refresh the index
+func indexStat(info os.FileInfo) (indexStatData, bool) {
+	return indexStatData{}, false
+}
+
+// diskStat falls back to the apparent size on this platform, without hardlink detection
+func diskStat(info os.FileInfo) (int64, inodeKey, uint64, bool) {
+	return info.Size(), inodeKey{}, 1, false
+}
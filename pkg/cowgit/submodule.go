@@ -0,0 +1,256 @@
+package cowgit
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// gitlinkMode is the index mode of a submodule entry
+const gitlinkMode = "160000"
+
+// submoduleStateFiles are copied from a submodule's git directory into the new
+// worktree's, giving it its own HEAD, refs and config; objects are shared
+var submoduleStateFiles = []string{"HEAD", "config", "packed-refs", "shallow", "info", "refs"}
+
+// clonedSubmodule is an initialized submodule carried into a new worktree
+type clonedSubmodule struct {
+	// Path is the submodule's slash-separated path in the worktree
+	Path string
+	// sourceDir and sourceGitDir are the submodule's checkout and git directory in the source
+	sourceDir    string
+	sourceGitDir string
+	// dir and gitDir are the submodule's checkout and git directory in the worktree
+	dir    string
+	gitDir string
+}
+
+// cloneSubmodules turns the submodules copied by the clone into independent
+// checkouts of the new worktree. The copied .git files still name the source's
+// module directories through relative paths, which no longer resolve. Each
+// submodule gets its own git directory under the worktree's, where git keeps
+// submodules of a linked worktree, with its own HEAD, refs, index and
+// core.worktree, borrowing objects from the source's module store through
+// alternates. Nested submodules are handled the same way, parents first.
+func (w *Worktree) cloneSubmodules() error {
+	w.submodules = nil
+	return w.cloneSubmodulesOf(w.RepoPath, w.gitDir(), w.WorktreePath, w.registeredGitDir, "")
+}
+
+// cloneSubmodulesOf clones the initialized submodules of one checkout, recursively
+func (w *Worktree) cloneSubmodulesOf(sourceDir, sourceGitDir, dir, gitDir, prefix string) error {
+	paths, err := w.submodulePaths(sourceDir)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		sub := clonedSubmodule{
+			Path:      prefix + path,
+			sourceDir: filepath.Join(sourceDir, filepath.FromSlash(path)),
+			dir:       filepath.Join(dir, filepath.FromSlash(path)),
+		}
+		sub.sourceGitDir, err = submoduleGitDir(sub.sourceDir)
+		if err != nil {
+			return err
+		}
+		if sub.sourceGitDir == "" {
+			continue // Not initialized in the source, so the clone has an empty directory too
+		}
+
+		// Keep the module's place relative to its parent's git directory, which is
+		// modules/<name>; a submodule whose .git is still a directory goes by path
+		rel, err := filepath.Rel(sourceGitDir, sub.sourceGitDir)
+		if err != nil || !isWithin(sub.sourceGitDir, sourceGitDir) {
+			rel = filepath.Join("modules", filepath.FromSlash(path))
+		}
+		sub.gitDir = filepath.Join(gitDir, rel)
+
+		if err := sub.register(w.lfsVerifier(sub.sourceDir)); err != nil {
+			return fmt.Errorf("failed to set up submodule %s: %w", sub.Path, err)
+		}
+		w.submodules = append(w.submodules, sub)
+
+		if err := w.cloneSubmodulesOf(sub.sourceDir, sub.sourceGitDir, sub.dir, sub.gitDir, sub.Path+"/"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// submodulePaths lists the gitlinks in a checkout's index
+func (w *Worktree) submodulePaths(dir string) ([]string, error) {
+	output, err := w.runGitCommand(dir, "ls-files", "--stage", "-z")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list submodules in %s: %w", dir, err)
+	}
+
+	var paths []string
+	for _, entry := range strings.Split(string(output), "\x00") {
+		info, path, ok := strings.Cut(entry, "\t")
+		// Conflicted gitlinks appear once per stage; only a resolved one has a checkout
+		if ok && strings.HasPrefix(info, gitlinkMode+" ") && strings.HasSuffix(info, " 0") {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// submoduleGitDir returns the git directory of the submodule checked out at dir,
+// or an empty string if it isn't initialized
+func submoduleGitDir(dir string) (string, error) {
+	gitPath := filepath.Join(dir, ".git")
+	info, err := os.Lstat(gitPath)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return gitPath, nil
+	}
+	return resolveWorktreeGitDir(dir)
+}
+
+// register gives the submodule its own git directory in the worktree and
+// points its checkout there
+func (s *clonedSubmodule) register(verify staleEntryVerifier) error {
+	// A module that is itself a linked worktree keeps refs and objects in its common directory
+	commonDir := s.sourceGitDir
+	if content, err := os.ReadFile(filepath.Join(s.sourceGitDir, "commondir")); err == nil {
+		commonDir = strings.TrimSpace(string(content))
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(s.sourceGitDir, commonDir)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Join(s.gitDir, "objects", "info"), 0755); err != nil {
+		return err
+	}
+	for _, name := range submoduleStateFiles {
+		from := filepath.Join(commonDir, name)
+		if name == "HEAD" {
+			from = filepath.Join(s.sourceGitDir, name)
+		}
+		if err := copyGitState(from, filepath.Join(s.gitDir, name)); err != nil {
+			return err
+		}
+	}
+
+	objects, err := filepath.Abs(filepath.Join(commonDir, "objects"))
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(s.gitDir, "objects", "info", "alternates"), []byte(objects+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write alternates: %w", err)
+	}
+
+	// git records these links relative, so they survive moving the checkout and git directory together
+	worktreeRel, err := filepath.Rel(s.gitDir, s.dir)
+	if err != nil {
+		return err
+	}
+	if err := s.setConfig("core.worktree", filepath.ToSlash(worktreeRel)); err != nil {
+		return err
+	}
+
+	// git-lfs keeps its object cache in the git directory, so the new one would
+	// start empty and fetch everything again; point it at the source's instead
+	setting, _ := s.git("config", "lfs.storage").Output()
+	if storage := lfsStorageDir(commonDir, strings.TrimSpace(string(setting))); storage != "" {
+		if err := s.setConfig("lfs.storage", storage); err != nil {
+			return err
+		}
+	}
+
+	gitDirRel, err := filepath.Rel(s.dir, s.gitDir)
+	if err != nil {
+		return err
+	}
+	gitFile := filepath.Join(s.dir, ".git")
+	if err := os.RemoveAll(gitFile); err != nil {
+		return fmt.Errorf("failed to remove copied .git: %w", err)
+	}
+	if err := os.WriteFile(gitFile, []byte("gitdir: "+filepath.ToSlash(gitDirRel)+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write .git file: %w", err)
+	}
+
+	if err := cloneIndex(s.sourceDir, s.sourceGitDir, s.dir, s.gitDir, verify); err != nil {
+		return fmt.Errorf("failed to clone index: %w", err)
+	}
+	return nil
+}
+
+// git prepares a git command for the submodule's new git directory. The
+// copied core.worktree doesn't resolve until it is rewritten, so git is told
+// where both the git directory and the checkout are.
+func (s *clonedSubmodule) git(args ...string) *exec.Cmd {
+	cmd := gitCommand(s.dir, args...)
+	cmd.Env = append(cmd.Env, "GIT_DIR="+s.gitDir, "GIT_WORK_TREE="+s.dir)
+	return cmd
+}
+
+// setConfig sets a key in the submodule's new git directory
+func (s *clonedSubmodule) setConfig(key, value string) error {
+	if output, err := s.git("config", key, value).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to set %s: %w: %s", key, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// copyGitState copies a file or directory of git state, skipping it if it doesn't exist
+func copyGitState(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == src {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if entry.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, content, 0644)
+	})
+}
+
+// updateChangedSubmodules checks out the commits BaseCommit records for
+// submodules that differ from the source's. Like a checkout with
+// --recurse-submodules, it fails if a recorded commit was never fetched,
+// rather than leaving the submodule at the source's commit.
+func (w *Worktree) updateChangedSubmodules() error {
+	changed := make(map[string]bool, len(w.ChangedPaths))
+	for _, path := range w.ChangedPaths {
+		changed[path] = true
+	}
+	args := []string{"submodule", "update", "--no-fetch", "--recursive", "--"}
+	n := len(args)
+	for _, sub := range w.submodules {
+		if changed[sub.Path] {
+			args = append(args, sub.Path)
+		}
+	}
+	if len(args) == n {
+		return nil
+	}
+	if output, err := w.runGitCommandCombined(w.WorktreePath, args...); err != nil {
+		return fmt.Errorf("failed to update submodules: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
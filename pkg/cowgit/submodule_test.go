@@ -0,0 +1,201 @@
+package cowgit
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupSubmoduleRepo creates a repository with the submodule libs/lib, which
+// has a nested submodule deep, both initialized the way git submodule update
+// leaves them: .git files pointing into .git/modules
+func setupSubmoduleRepo(t *testing.T, tempDir string) string {
+	t.Helper()
+	deep := filepath.Join(tempDir, "deep-upstream")
+	lib := filepath.Join(tempDir, "lib-upstream")
+	repoDir := filepath.Join(tempDir, "repo")
+	setupCompatRepo(t, deep)
+	setupCompatRepo(t, lib)
+	setupCompatRepo(t, repoDir)
+
+	git := func(dir string, args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "protocol.file.allow=always"}, args...)...)
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, output)
+		}
+	}
+	git(lib, "submodule", "add", "-q", deep, "deep")
+	git(lib, "commit", "-q", "-m", "Add deep")
+	git(repoDir, "submodule", "add", "-q", lib, "libs/lib")
+	git(repoDir, "commit", "-q", "-m", "Add lib")
+	git(repoDir, "submodule", "update", "-q", "--init", "--recursive")
+	return repoDir
+}
+
+// revParse runs git rev-parse in dir and returns its trimmed output
+func revParse(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	output, err := exec.Command("git", append([]string{"-C", dir, "rev-parse"}, args...)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git rev-parse %v in %s failed: %v: %s", args, dir, err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func TestCreateWithNestedSubmodules(t *testing.T) {
+	tempDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to resolve temp dir: %v", err)
+	}
+	repoDir := setupSubmoduleRepo(t, tempDir)
+
+	worktree := NewWorktreeWithOptions(repoDir, filepath.Join(tempDir, "wt"), "with-submodules", true)
+	worktree.Backend = BackendCopy
+	worktree.Fallback = FallbackCopy
+	if err := worktree.CreateCoWWorktree(); err != nil {
+		t.Fatalf("CreateCoWWorktree failed: %v", err)
+	}
+
+	if status := gitStatus(t, worktree.WorktreePath); status != "" {
+		t.Errorf("worktree is not clean:\n%s", status)
+	}
+	output, err := exec.Command("git", "-C", worktree.WorktreePath, "submodule", "status", "--recursive").CombinedOutput()
+	if err != nil {
+		t.Fatalf("git submodule status failed: %v: %s", err, output)
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(output), "\n"), "\n") {
+		if !strings.HasPrefix(line, " ") {
+			t.Errorf("submodule not checked out at the recorded commit: %q", line)
+		}
+	}
+
+	// Each submodule is its own checkout with a git directory under the new
+	// worktree's, not the source's, and borrows the source's objects
+	modulesDir := filepath.Join(repoDir, ".git", "worktrees", worktree.ID, "modules")
+	for _, path := range []string{"libs/lib", "libs/lib/deep"} {
+		dir := filepath.Join(worktree.WorktreePath, filepath.FromSlash(path))
+		if top := revParse(t, dir, "--show-toplevel"); top != dir {
+			t.Errorf("%s: toplevel = %s, want %s", path, top, dir)
+		}
+		gitDir := revParse(t, dir, "--absolute-git-dir")
+		if !isWithin(gitDir, modulesDir) {
+			t.Errorf("%s: git dir = %s, want it under %s", path, gitDir, modulesDir)
+		}
+		source := filepath.Join(repoDir, filepath.FromSlash(path))
+		if got, want := revParse(t, dir, "HEAD"), revParse(t, source, "HEAD"); got != want {
+			t.Errorf("%s: HEAD = %s, want the source's %s", path, got, want)
+		}
+		entries, err := os.ReadDir(filepath.Join(gitDir, "objects"))
+		if err != nil || len(entries) != 1 || entries[0].Name() != "info" {
+			t.Errorf("%s: objects were copied instead of shared: %v, %v", path, entries, err)
+		}
+		if output, err := exec.Command("git", "-C", dir, "fsck", "--no-progress").CombinedOutput(); err != nil {
+			t.Errorf("%s: git fsck failed: %v: %s", path, err, output)
+		}
+	}
+
+	// Committing in the worktree's submodule leaves the source's alone
+	deep := filepath.Join(worktree.WorktreePath, "libs", "lib", "deep")
+	sourceDeep := filepath.Join(repoDir, "libs", "lib", "deep")
+	sourceHead := revParse(t, sourceDeep, "HEAD")
+	if err := os.WriteFile(filepath.Join(deep, "new.txt"), []byte("new"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := runCommand(deep, "git", "add", "new.txt"); err != nil {
+		t.Fatalf("git add failed: %v", err)
+	}
+	if err := runCommand(deep, "git", "-c", "user.name=Test User", "-c", "user.email=test@example.com", "commit", "-q", "-m", "In the worktree"); err != nil {
+		t.Fatalf("git commit failed: %v", err)
+	}
+	commit := revParse(t, deep, "HEAD")
+	if head := revParse(t, sourceDeep, "HEAD"); head != sourceHead {
+		t.Errorf("source submodule HEAD moved to %s", head)
+	}
+	if exec.Command("git", "-C", sourceDeep, "cat-file", "-e", commit).Run() == nil {
+		t.Error("the worktree's commit was written to the source's module store")
+	}
+	if status := gitStatus(t, repoDir); status != "" {
+		t.Errorf("source is not clean:\n%s", status)
+	}
+
+	if err := worktree.Remove(); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if _, err := os.Stat(worktree.WorktreePath); !os.IsNotExist(err) {
+		t.Error("worktree directory left behind")
+	}
+}
+
+func TestCreateWithSubmodulesWithoutCarry(t *testing.T) {
+	tempDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to resolve temp dir: %v", err)
+	}
+	repoDir := setupSubmoduleRepo(t, tempDir)
+
+	// Uncommitted work inside the nested submodule
+	if err := os.WriteFile(filepath.Join(repoDir, "libs", "lib", "deep", "test.txt"), []byte("edited"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repoDir, "libs", "lib", "deep", "scratch.txt"), []byte("scratch"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	copied := NewWorktreeWithOptions(repoDir, filepath.Join(tempDir, "copied"), "copied", true)
+	copied.Backend = BackendCopy
+	copied.Fallback = FallbackCopy
+	if err := copied.CreateCoWWorktree(); err != nil {
+		t.Fatalf("CreateCoWWorktree failed: %v", err)
+	}
+	if status := gitStatus(t, filepath.Join(copied.WorktreePath, "libs", "lib", "deep")); status != "M test.txt\n?? scratch.txt" {
+		t.Errorf("carried submodule status = %q", status)
+	}
+
+	dropped := NewWorktreeWithOptions(repoDir, filepath.Join(tempDir, "dropped"), "dropped", true)
+	dropped.Backend = BackendCopy
+	dropped.Fallback = FallbackCopy
+	dropped.Carry = CarryNone
+	if err := dropped.CreateCoWWorktree(); err != nil {
+		t.Fatalf("CreateCoWWorktree failed: %v", err)
+	}
+	if status := gitStatus(t, dropped.WorktreePath); status != "" {
+		t.Errorf("worktree without carry is not clean:\n%s", status)
+	}
+}
+
+func TestCreateFromCommitWithMissingSubmoduleCommitFails(t *testing.T) {
+	tempDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to resolve temp dir: %v", err)
+	}
+	repoDir := setupSubmoduleRepo(t, tempDir)
+
+	// A commit whose gitlink names a submodule commit that was never fetched
+	missing := strings.Repeat("1", 40)
+	git := func(args ...string) {
+		t.Helper()
+		if output, err := exec.Command("git", append([]string{"-C", repoDir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, output)
+		}
+	}
+	git("update-index", "--cacheinfo", gitlinkMode+","+missing+",libs/lib")
+	git("commit", "-q", "-m", "Point lib at a missing commit")
+	commit := revParse(t, repoDir, "HEAD")
+	git("reset", "-q", "HEAD~1")
+
+	worktree := NewWorktreeWithOptions(repoDir, filepath.Join(tempDir, "wt"), "missing-submodule", true)
+	worktree.Backend = BackendCopy
+	worktree.FromCommit = commit
+	if err := worktree.CreateCoWWorktree(); err == nil {
+		t.Fatal("Expected error checking out a submodule commit that isn't available")
+	} else if !strings.Contains(err.Error(), "failed to update submodules") {
+		t.Errorf("error = %v, want a submodule update failure", err)
+	}
+	if _, err := os.Stat(worktree.WorktreePath); !os.IsNotExist(err) {
+		t.Error("worktree directory left behind")
+	}
+}
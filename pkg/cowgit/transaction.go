@@ -0,0 +1,194 @@
+package cowgit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+)
+
+// ErrInterrupted is returned when worktree creation is stopped by SIGINT or
+// SIGTERM; everything created up to that point has been rolled back
+var ErrInterrupted = errors.New("worktree creation interrupted")
+
+// transactionHook, when set, runs after each journaled step with the step's
+// name. Tests use it to inject failures between steps.
+var transactionHook func(step string) error
+
+// journalStep is a completed (or partially completed) creation step and how to undo it
+type journalStep struct {
+	name string
+	undo func() error
+}
+
+// journal records the steps of a worktree creation so they can be undone in
+// reverse order if a later step fails or the process is interrupted
+type journal struct {
+	steps   []journalStep
+	signals chan os.Signal
+	// ctx is cancelled by the first SIGINT or SIGTERM so long-running steps
+	// like cloning stop early; its cause is the ErrInterrupted to report
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	closed chan struct{}
+}
+
+// newJournal starts a journal and traps SIGINT and SIGTERM until it is closed,
+// so an interrupted creation rolls back instead of leaving partial state
+func newJournal() *journal {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	j := &journal{
+		signals: make(chan os.Signal, 1),
+		ctx:     ctx,
+		cancel:  cancel,
+		closed:  make(chan struct{}),
+	}
+	signal.Notify(j.signals, os.Interrupt, syscall.SIGTERM)
+	go j.trap()
+	return j
+}
+
+// trap cancels the journal's context on the first signal and stops trapping,
+// so a second signal gets the default handler and ends a rollback that hangs
+func (j *journal) trap() {
+	select {
+	case sig := <-j.signals:
+		signal.Stop(j.signals)
+		j.cancel(fmt.Errorf("%w by %v", ErrInterrupted, sig))
+	case <-j.closed:
+	}
+}
+
+// step runs do and records undo, which must also cope with a partially done
+// step, before checking for injected failures and interruption. A step cut
+// short by a signal reports the interruption rather than how it failed.
+func (j *journal) step(name string, do func() error, undo func() error) error {
+	if undo != nil {
+		j.steps = append(j.steps, journalStep{name: name, undo: undo})
+	}
+	if err := do(); err != nil {
+		if interrupted := j.checkInterrupted(); interrupted != nil {
+			return interrupted
+		}
+		return err
+	}
+	if transactionHook != nil {
+		if err := transactionHook(name); err != nil {
+			return err
+		}
+	}
+	return j.checkInterrupted()
+}
+
+// checkInterrupted returns ErrInterrupted if a signal arrived since the journal started
+func (j *journal) checkInterrupted() error {
+	if j.ctx.Err() == nil {
+		return nil
+	}
+	return context.Cause(j.ctx)
+}
+
+// rollback undoes the recorded steps in reverse order and clears the journal
+func (j *journal) rollback() error {
+	var errs []error
+	for i := len(j.steps) - 1; i >= 0; i-- {
+		if err := j.steps[i].undo(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to undo %s: %w", j.steps[i].name, err))
+		}
+	}
+	j.steps = nil
+	return combineErrors(errs)
+}
+
+// close stops trapping signals and releases the journal's context
+func (j *journal) close() {
+	signal.Stop(j.signals)
+	close(j.closed)
+	j.cancel(nil)
+}
+
+// fileSnapshot holds the exact state of a set of files so they can be restored
+// byte for byte, including removing files and directories that didn't exist
+type fileSnapshot struct {
+	files       map[string]*snapshotFile
+	missingDirs []string
+}
+
+// snapshotFile is a saved file, or nil content for a file that didn't exist
+type snapshotFile struct {
+	content []byte
+	mode    os.FileMode
+}
+
+// takeFileSnapshot saves the content of paths and notes which of their parent
+// directories don't exist yet
+func takeFileSnapshot(paths ...string) (*fileSnapshot, error) {
+	snapshot := &fileSnapshot{files: make(map[string]*snapshotFile)}
+	seenDirs := make(map[string]bool)
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			snapshot.files[path] = nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to snapshot %s: %w", path, err)
+		} else {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to snapshot %s: %w", path, err)
+			}
+			snapshot.files[path] = &snapshotFile{content: content, mode: info.Mode().Perm()}
+		}
+
+		for dir := filepath.Dir(path); !seenDirs[dir]; dir = filepath.Dir(dir) {
+			seenDirs[dir] = true
+			if _, err := os.Stat(dir); err == nil {
+				break
+			}
+			snapshot.missingDirs = append(snapshot.missingDirs, dir)
+		}
+	}
+	return snapshot, nil
+}
+
+// restore puts every file back as it was and removes directories created since
+func (s *fileSnapshot) restore() error {
+	var errs []error
+	for path, file := range s.files {
+		if file == nil {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, file.content) {
+			continue
+		}
+		if err := os.WriteFile(path, file.content, file.mode); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Deepest first; directories that still hold other files are left alone
+	dirs := append([]string(nil), s.missingDirs...)
+	sort.Slice(dirs, func(a, b int) bool { return len(dirs[a]) > len(dirs[b]) })
+	for _, dir := range dirs {
+		os.Remove(dir)
+	}
+	return combineErrors(errs)
+}
+
+// missingParents returns the ancestors of path that don't exist yet, deepest first
+func missingParents(path string) []string {
+	var missing []string
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if _, err := os.Lstat(dir); err == nil || filepath.Dir(dir) == dir {
+			return missing
+		}
+		missing = append(missing, dir)
+	}
+}
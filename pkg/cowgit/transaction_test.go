@@ -0,0 +1,262 @@
+package cowgit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// snapshotTree records every file, directory and symlink under root with its
+// mode and content
+func snapshotTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+	tree := make(map[string]string)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			tree[rel] = "symlink " + target
+		case info.IsDir():
+			tree[rel] = fmt.Sprintf("dir %v", info.Mode())
+		default:
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			tree[rel] = fmt.Sprintf("file %v %q", info.Mode(), content)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to snapshot %s: %v", root, err)
+	}
+	return tree
+}
+
+// compareTrees reports differences between two snapshots. New loose objects
+// are ignored: they are unreachable and git gc removes them. So is the
+// repository lock file, which stays in place for the next process to lock.
+func compareTrees(t *testing.T, before, after map[string]string) {
+	t.Helper()
+	for path, state := range before {
+		if after[path] != state {
+			t.Errorf("%s changed:\n before: %.200s\n  after: %.200s", path, state, after[path])
+		}
+	}
+	for path := range after {
+		if _, ok := before[path]; ok || path == filepath.Join(".git", lockFileName) {
+			continue
+		}
+		if !strings.HasPrefix(path, filepath.Join(".git", "objects")+string(filepath.Separator)) {
+			t.Errorf("%s was left behind", path)
+		}
+	}
+}
+
+// setupTransactionRepo creates a repository that exercises every creation
+// step: packed refs, a branch to reset, a remote-tracking start point, an
+// ignored file with an absolute path to rewrite and an untracked file to move
+func setupTransactionRepo(t *testing.T, repoDir string) {
+	setupCompatRepo(t, repoDir)
+
+	if err := os.WriteFile(filepath.Join(repoDir, ".git", "info", "exclude"), []byte("build/\n"), 0644); err != nil {
+		t.Fatalf("Failed to write exclude: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(repoDir, "build"), 0755); err != nil {
+		t.Fatalf("Failed to create build dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repoDir, "build", "paths.txt"), []byte("root="+repoDir+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write build file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repoDir, "notes.txt"), []byte("untracked"), 0644); err != nil {
+		t.Fatalf("Failed to write notes.txt: %v", err)
+	}
+	if err := runCommand(repoDir, "git", "pack-refs", "--all"); err != nil {
+		t.Fatalf("Failed to pack refs: %v", err)
+	}
+	// Refresh the index now so stat data doesn't change under the snapshot
+	runCommand(repoDir, "git", "update-index", "-q", "--refresh")
+}
+
+func TestCreateRollsBackEachStep(t *testing.T) {
+	defer func() { transactionHook = nil }()
+
+	// Each configuration passes through the listed steps
+	configs := []struct {
+		worktree Worktree
+		steps    []string
+	}{
+		{
+			worktree: Worktree{BranchName: "existing", ResetBranch: true, Track: true, FromCommit: "origin/feature", Carry: CarryMove},
+			steps:    []string{"target", "clone", "ref", "metadata", "HEAD", "config", "index", "submodules", "sparse", "checkout", "rewrite", "stash"},
+		},
+		{
+			worktree: Worktree{BranchName: "feature/new", Carry: CarryNone, NoRewrite: true},
+			steps:    []string{"ref", "metadata", "carry"},
+		},
+		{
+			worktree: Worktree{Detach: true, NoCheckout: true, NoRewrite: true},
+			steps:    []string{"clone", "metadata", "HEAD"},
+		},
+		{
+			// Per-worktree config and git sparse-checkout set enable
+			// extensions.worktreeConfig in the shared config
+			worktree: Worktree{Detach: true, Sparse: []string{"build"}, Config: map[string]string{"user.name": "Agent"}},
+			steps:    []string{"config", "sparse", "rewrite"},
+		},
+	}
+
+	for _, config := range configs {
+		for _, failAt := range config.steps {
+			t.Run(fmt.Sprintf("%s/%s", config.steps[len(config.steps)-1], failAt), func(t *testing.T) {
+				tempDir := t.TempDir()
+				repoDir := filepath.Join(tempDir, "repo")
+				setupTransactionRepo(t, repoDir)
+				before := snapshotTree(t, repoDir)
+
+				var reached []string
+				injected := errors.New("injected failure")
+				transactionHook = func(step string) error {
+					reached = append(reached, step)
+					if step == failAt {
+						return injected
+					}
+					return nil
+				}
+
+				worktree := config.worktree
+				worktree.RepoPath = repoDir
+				worktree.WorktreePath = filepath.Join(tempDir, "new", "parent", "wt")
+				worktree.Backend = BackendCopy
+				worktree.Fallback = FallbackCopy
+				err := worktree.CreateCoWWorktree()
+				transactionHook = nil
+
+				if !errors.Is(err, injected) {
+					t.Fatalf("CreateCoWWorktree error = %v, want injected failure at %s (reached %v)", err, failAt, reached)
+				}
+				compareTrees(t, before, snapshotTree(t, repoDir))
+				if _, err := os.Stat(filepath.Join(tempDir, "new")); !os.IsNotExist(err) {
+					t.Errorf("Worktree parent directories left behind")
+				}
+			})
+		}
+	}
+}
+
+func TestCreateRollsBackOnInterrupt(t *testing.T) {
+	defer func() { transactionHook = nil }()
+
+	tempDir := t.TempDir()
+	repoDir := filepath.Join(tempDir, "repo")
+	setupTransactionRepo(t, repoDir)
+	before := snapshotTree(t, repoDir)
+
+	// Deliver SIGINT to ourselves partway through; the journal traps it
+	transactionHook = func(step string) error {
+		if step != "HEAD" {
+			return nil
+		}
+		delivered := make(chan os.Signal, 1)
+		signal.Notify(delivered, os.Interrupt)
+		defer signal.Stop(delivered)
+
+		process, err := os.FindProcess(os.Getpid())
+		if err != nil {
+			return err
+		}
+		if err := process.Signal(os.Interrupt); err != nil {
+			t.Skipf("Cannot signal own process: %v", err)
+		}
+		// Signals are fanned out to every channel at once, so once ours has it
+		// the journal's has it too
+		select {
+		case <-delivered:
+		case <-time.After(5 * time.Second):
+			t.Fatal("SIGINT was not delivered")
+		}
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+
+	worktree := NewWorktreeWithOptions(repoDir, filepath.Join(tempDir, "wt"), "interrupted", true)
+	worktree.Backend = BackendCopy
+	err := worktree.CreateCoWWorktree()
+	transactionHook = nil
+
+	if !errors.Is(err, ErrInterrupted) {
+		t.Fatalf("CreateCoWWorktree error = %v, want ErrInterrupted", err)
+	}
+	compareTrees(t, before, snapshotTree(t, repoDir))
+	if _, err := os.Stat(worktree.WorktreePath); !os.IsNotExist(err) {
+		t.Error("Worktree directory left behind after interrupt")
+	}
+}
+
+// interruptingBackend delivers SIGINT to the process as cloning starts, waits
+// for the journal to cancel the clone's context, and then copies like the copy
+// backend, recording how the copy ended
+type interruptingBackend struct {
+	copyBackend
+	cloneErr error
+}
+
+func (b *interruptingBackend) Name() string {
+	return "interrupting"
+}
+
+func (b *interruptingBackend) Clone(src, dst string, opts CloneOptions) error {
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		return err
+	}
+	if err := process.Signal(os.Interrupt); err != nil {
+		return err
+	}
+	select {
+	case <-opts.context().Done():
+	case <-time.After(5 * time.Second):
+		return errors.New("clone context was not cancelled")
+	}
+	b.cloneErr = CopyDirectoryParallel(src, dst, opts)
+	return b.cloneErr
+}
+
+func TestInterruptStopsClone(t *testing.T) {
+	backend := &interruptingBackend{}
+	RegisterBackend(backend)
+	defer UnregisterBackend(backend.Name())
+
+	tempDir := t.TempDir()
+	repoDir := filepath.Join(tempDir, "repo")
+	setupTransactionRepo(t, repoDir)
+	before := snapshotTree(t, repoDir)
+
+	worktree := NewWorktreeWithOptions(repoDir, filepath.Join(tempDir, "wt"), "interrupted", true)
+	worktree.Backend = backend.Name()
+	err := worktree.CreateCoWWorktree()
+
+	if !errors.Is(err, ErrInterrupted) {
+		t.Fatalf("CreateCoWWorktree error = %v, want ErrInterrupted", err)
+	}
+	if !errors.Is(backend.cloneErr, context.Canceled) {
+		t.Errorf("copy error = %v, want it stopped by the cancelled context", backend.cloneErr)
+	}
+	compareTrees(t, before, snapshotTree(t, repoDir))
+	if _, err := os.Stat(worktree.WorktreePath); !os.IsNotExist(err) {
+		t.Error("Worktree directory left behind after interrupt")
+	}
+}
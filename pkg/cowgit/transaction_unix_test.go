@@ -0,0 +1,42 @@
+//go:build unix
+
+package cowgit
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSecondInterruptKillsProcess(t *testing.T) {
+	// In the helper process: trap the first SIGINT, then send a second one,
+	// which must get the default handler instead of waiting for the rollback
+	if os.Getenv("COWORKTREE_TEST_SECOND_INTERRUPT") == "1" {
+		tx := newJournal()
+		defer tx.close()
+		process, err := os.FindProcess(os.Getpid())
+		if err != nil {
+			os.Exit(2)
+		}
+		process.Signal(os.Interrupt)
+		<-tx.ctx.Done()
+		process.Signal(os.Interrupt)
+		time.Sleep(5 * time.Second)
+		os.Exit(0)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSecondInterruptKillsProcess$")
+	cmd.Env = append(os.Environ(), "COWORKTREE_TEST_SECOND_INTERRUPT=1")
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("helper process error = %v, want it killed by SIGINT", err)
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() || status.Signal() != syscall.SIGINT {
+		t.Errorf("helper process ended with %v, want it killed by SIGINT", exitErr)
+	}
+}
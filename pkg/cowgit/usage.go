@@ -0,0 +1,74 @@
+package cowgit
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// diskUsageReporter is implemented by backends that keep a clone's data
+// outside the worktree directory, so walking it would count the wrong files
+type diskUsageReporter interface {
+	DiskUsage(dst string) (int64, error)
+}
+
+// inodeKey identifies a file across hardlinks
+type inodeKey struct {
+	dev, ino uint64
+}
+
+// DiskUsage returns the disk space used by the files under root, counting a
+// hardlinked file once and only when all of its links are under root, since
+// deleting root frees nothing otherwise. On Linux, blocks a file shares with
+// another through reflinks are left out for the same reason. Symlinks are not
+// followed.
+func DiskUsage(root string) (int64, error) {
+	var usage int64
+	type linked struct {
+		size        int64
+		links, seen uint64
+	}
+	inodes := make(map[inodeKey]*linked)
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path != root {
+				return nil
+			}
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		size, key, links, ok := diskStat(info)
+		if d.Type().IsRegular() && size > 0 {
+			size = unsharedBytes(path, size)
+		}
+		if !ok || links <= 1 || d.IsDir() {
+			usage += size
+			return nil
+		}
+		entry := inodes[key]
+		if entry == nil {
+			entry = &linked{size: size, links: links}
+			inodes[key] = entry
+		}
+		entry.seen++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range inodes {
+		if entry.seen >= entry.links {
+			usage += entry.size
+		}
+	}
+	return usage, nil
+}
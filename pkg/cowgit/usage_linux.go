@@ -0,0 +1,74 @@
+//go:build linux
+
+package cowgit
+
+import (
+	"math"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// FIEMAP ioctl and flags from linux/fiemap.h, which x/sys/unix doesn't define
+const (
+	fsIocFiemap        = 0xc020660b
+	fiemapExtentLast   = 0x1
+	fiemapExtentShared = 0x2000
+	fiemapBatch        = 64
+)
+
+// fiemapExtent is struct fiemap_extent
+type fiemapExtent struct {
+	logical  uint64
+	physical uint64
+	length   uint64
+	_        [2]uint64
+	flags    uint32
+	_        [3]uint32
+}
+
+// fiemap is struct fiemap with room for fiemapBatch extents
+type fiemap struct {
+	start         uint64
+	length        uint64
+	flags         uint32
+	mappedExtents uint32
+	extentCount   uint32
+	_             uint32
+	extents       [fiemapBatch]fiemapExtent
+}
+
+// unsharedBytes returns how much of the allocated bytes of the file at path
+// are in extents no other file shares, so reflinked blocks that deleting the
+// file wouldn't free are left out. Filesystems without FIEMAP count every block.
+func unsharedBytes(path string, allocated int64) int64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return allocated
+	}
+	defer f.Close()
+
+	var unshared int64
+	var start uint64
+	for {
+		m := fiemap{start: start, length: math.MaxUint64, extentCount: fiemapBatch}
+		if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), fsIocFiemap, uintptr(unsafe.Pointer(&m))); errno != 0 {
+			return allocated
+		}
+		if m.mappedExtents == 0 {
+			break
+		}
+		for _, extent := range m.extents[:m.mappedExtents] {
+			if extent.flags&fiemapExtentShared == 0 {
+				unshared += int64(extent.length)
+			}
+		}
+		last := m.extents[m.mappedExtents-1]
+		if last.flags&fiemapExtentLast != 0 {
+			break
+		}
+		start = last.logical + last.length
+	}
+	return min64(unshared, allocated)
+}
@@ -0,0 +1,49 @@
+package cowgit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiskUsageSkipsReflinkedBlocks(t *testing.T) {
+	tempDir := t.TempDir()
+	if supported, err := isReflinkSupported(tempDir); err != nil || !supported {
+		t.Skip("reflinks not supported on this filesystem")
+	}
+
+	src := filepath.Join(tempDir, "src")
+	root := filepath.Join(tempDir, "root")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatalf("Failed to create src: %v", err)
+	}
+	const size = 1 << 20
+	if err := os.WriteFile(filepath.Join(src, "data"), make([]byte, size), 0644); err != nil {
+		t.Fatalf("Failed to write data: %v", err)
+	}
+	if err := cloneTree(context.Background(), src, root, nil); err != nil {
+		t.Fatalf("Failed to reflink src: %v", err)
+	}
+
+	// Deleting a fresh reflink clone frees none of the blocks it shares with the source
+	usage, err := DiskUsage(root)
+	if err != nil {
+		t.Fatalf("DiskUsage failed: %v", err)
+	}
+	if usage >= size {
+		t.Errorf("DiskUsage of a reflink clone = %d, want less than %d", usage, size)
+	}
+
+	// Rewriting the file gives the clone blocks of its own
+	if err := os.WriteFile(filepath.Join(root, "data"), make([]byte, size), 0644); err != nil {
+		t.Fatalf("Failed to rewrite data: %v", err)
+	}
+	usage, err = DiskUsage(root)
+	if err != nil {
+		t.Fatalf("DiskUsage failed: %v", err)
+	}
+	if usage < size {
+		t.Errorf("DiskUsage after rewriting = %d, want at least %d", usage, size)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package cowgit
+
+// unsharedBytes counts every allocated byte on this platform, including blocks
+// a clonefile clone still shares with its source
+func unsharedBytes(path string, allocated int64) int64 {
+	return allocated
+}
@@ -1,16 +1,29 @@
 package cowgit
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 )
 
+// Errors returned when the branch for a new worktree can't be used
+var (
+	// ErrBranchExists means a new branch was requested but one of that name
+	// exists; ResetBranch resets it instead
+	ErrBranchExists = errors.New("branch already exists")
+	// ErrBranchCheckedOut means an existing branch is checked out in another worktree
+	ErrBranchCheckedOut = errors.New("branch is already checked out")
+)
+
 // Worktree represents a git worktree with CoW capabilities
 type Worktree struct {
 	RepoPath      string
@@ -21,6 +34,71 @@ type Worktree struct {
 	ParallelCoW   bool
 	ForceParallel bool
 	ParallelDepth int
+	// Backend names the CloneBackend to use (empty or BackendAuto picks one),
+	// and records the backend that was actually used once the worktree exists,
+	// or FallbackGit if git worktree add created it
+	Backend string
+	// Fallback decides what happens when Backend isn't supported: FallbackGit
+	// (the default) uses git worktree add, FallbackCopy uses the copy backend
+	Fallback string
+	// HardlinkPaths are the globs the hardlink backend links (DefaultHardlinkPaths if empty)
+	HardlinkPaths []string
+	// FromCommit is the commit-ish to base the worktree on; empty means HEAD
+	FromCommit string
+	// ChangedPaths lists the tracked paths that were checked out to move the
+	// clone from the source HEAD to BaseCommit
+	ChangedPaths []string
+	// ResetBranch creates BranchName or resets it if it exists, like git worktree add -B
+	ResetBranch bool
+	// Detach checks out BaseCommit with a detached HEAD instead of a branch
+	Detach bool
+	// Track and NoTrack force or suppress upstream tracking for the new branch,
+	// like --track and --no-track; by default git's branch.autoSetupMerge applies
+	Track   bool
+	NoTrack bool
+	// GuessRemote bases a new branch on the remote-tracking branch of the same
+	// name when FromCommit is empty and exactly one remote has it
+	GuessRemote bool
+	// NoCheckout registers the worktree without populating it, like --no-checkout
+	NoCheckout bool
+	// ExistingBranch checks out BranchName as it is instead of creating it,
+	// like git worktree add <path> <branch>
+	ExistingBranch bool
+	// Carry decides what happens to the source's uncommitted changes: CarryCopy
+	// (the default), CarryNone or CarryMove
+	Carry string
+	// ID names the worktree's directory under .git/worktrees once it is registered
+	ID string
+	// Force replaces a file, non-empty directory or registered worktree of this
+	// repository at WorktreePath instead of refusing, like git worktree add --force
+	Force bool
+	// LockTimeout is how long to wait for concurrent coworktree processes to
+	// release the repository lock; zero means DefaultLockTimeout
+	LockTimeout time.Duration
+	// Logger receives the git commands run (at debug level), backend
+	// decisions and fallbacks, and worker pool scaling; nil discards them
+	Logger *slog.Logger
+	// Sparse gives the worktree its own sparse-checkout set, as for git
+	// sparse-checkout set: directories in cone mode, or patterns with
+	// SparseNoCone. Nil keeps the source checkout's sparse-checkout, if any.
+	Sparse       []string
+	SparseNoCone bool
+	// Config holds settings for this worktree only, such as user.email or
+	// core.hooksPath, written to its config.worktree with
+	// extensions.worktreeConfig enabled
+	Config map[string]string
+	// RewriteStats holds the path rewrite's statistics once it has run
+	RewriteStats *PathRewriteStats
+	// sourceCommit is the commit the cloned checkout is at
+	sourceCommit string
+	// registeredGitDir is the .git/worktrees entry created by registration
+	registeredGitDir string
+	// repo is the source checkout found from RepoPath
+	repo *Repository
+	// replaceRegistered is set by Preflight when Force replaces a registered worktree
+	replaceRegistered bool
+	// submodules are the initialized submodules carried into the worktree
+	submodules []clonedSubmodule
 }
 
 // NewWorktree creates a new Worktree instance
@@ -62,9 +140,57 @@ func (w *Worktree) CreateCoWWorktree() error {
 
 // CreateCoWWorktreeWithProgress creates a new worktree using copy-on-write with progress tracking
 func (w *Worktree) CreateCoWWorktreeWithProgress(progress *ProgressTracker) error {
-	// Clean up any existing worktree first
-	w.runGitCommand(w.RepoPath, "worktree", "remove", "-f", w.WorktreePath) // Ignore error if worktree doesn't exist
+	// Checks and metadata changes happen under the repository lock; setup
+	// releases it while cloning
+	lock, err := w.lockRepository()
+	if err != nil {
+		return err
+	}
+	defer lock.close()
+
+	if err := w.Preflight(); err != nil {
+		return err
+	}
+
+	if err := w.resolveCommits(); err != nil {
+		return err
+	}
+
+	// Claim the target so no concurrent creation clones into it while unlocked
+	target, err := canonicalPath(w.WorktreePath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", w.WorktreePath, err)
+	}
+	reservation, err := reserveTarget(w.commonDir(), target)
+	if err != nil {
+		return err
+	}
+	defer reservation.release()
+
+	// Try copy-on-write first; if the backend was picked automatically, fall
+	// back to a regular worktree when it fails
+	requested := w.Backend
+	if err := w.setupWorktreeWithCoWProgress(progress, lock); err != nil {
+		if explicitBackend(requested) || w.Fallback == FallbackCopy || errors.Is(err, ErrInterrupted) {
+			// The caller asked for this backend or to keep untracked files, so don't silently drop them
+			return err
+		}
+		w.logger().Info("clone failed, falling back to git worktree add", "error", err)
+		if err := lock.acquire(w.lockTimeout()); err != nil {
+			return err
+		}
+		// Nothing from the rolled-back clone describes the git worktree
+		w.ChangedPaths = nil
+		w.RewriteStats = nil
+		return w.addWithGit()
+	}
+
+	return nil
+}
 
+// resolveCommits finds the commit the source checkout is at and the one the
+// new worktree will be at, checking the branch options along the way
+func (w *Worktree) resolveCommits() error {
 	// Get HEAD commit
 	output, err := w.runGitCommand(w.RepoPath, "rev-parse", "HEAD")
 	if err != nil {
@@ -76,179 +202,690 @@ func (w *Worktree) CreateCoWWorktreeWithProgress(progress *ProgressTracker) erro
 		return fmt.Errorf("failed to get HEAD commit hash: %w", err)
 	}
 	headCommit := strings.TrimSpace(string(output))
+	w.sourceCommit = headCommit
 	w.BaseCommit = headCommit
 
-	// Try copy-on-write first, fall back to regular worktree if it fails
-	if err := w.setupWorktreeWithCoWProgress(progress); err != nil {
-		return w.setupRegularWorktree(headCommit)
+	if err := w.validateMode(); err != nil {
+		return err
 	}
 
+	// Without a commit-ish, --guess-remote bases the branch on a matching remote-tracking branch
+	if w.FromCommit == "" && w.GuessRemote && !w.Detach {
+		if remoteBranch := w.guessRemoteBranch(); remoteBranch != "" {
+			w.FromCommit = remoteBranch
+		}
+	}
+
+	// Resolve the requested commit-ish; the clone is reconciled to it after registration
+	commitish := w.FromCommit
+	if w.ExistingBranch {
+		commitish = "refs/heads/" + w.BranchName
+	}
+	if commitish != "" {
+		output, err := w.runGitCommand(w.RepoPath, "rev-parse", "--verify", "--quiet", commitish+"^{commit}")
+		if err != nil {
+			return fmt.Errorf("invalid commit-ish %s: not a valid commit", commitish)
+		}
+		w.BaseCommit = strings.TrimSpace(string(output))
+	}
 	return nil
 }
 
-// CreateFromExistingBranch creates a worktree from an existing branch
-func (w *Worktree) CreateFromExistingBranch() error {
-	// Ensure worktrees directory exists
-	worktreesDir := filepath.Dir(w.WorktreePath)
-	if err := os.MkdirAll(worktreesDir, 0755); err != nil {
-		return fmt.Errorf("failed to create worktrees directory: %w", err)
+// validateMode checks the branch options for conflicts, matching git worktree add's errors
+func (w *Worktree) validateMode() error {
+	if err := ValidateCarry(w.Carry); err != nil {
+		return err
+	}
+	if err := ValidateConfig(w.Config); err != nil {
+		return err
+	}
+	if w.Detach && w.ResetBranch {
+		return fmt.Errorf("options -B and --detach cannot be used together")
+	}
+	if w.Track && w.NoTrack {
+		return fmt.Errorf("options --track and --no-track cannot be used together")
+	}
+	if w.Detach && (w.Track || w.NoTrack) {
+		return fmt.Errorf("--[no-]track can only be used if a new branch is created")
+	}
+	if w.Detach {
+		return nil
 	}
 
-	// Clean up any existing worktree first
-	w.runGitCommand(w.RepoPath, "worktree", "remove", "-f", w.WorktreePath) // Ignore error if worktree doesn't exist
-
-	// Create a new worktree from the existing branch
-	if _, err := w.runGitCommand(w.RepoPath, "worktree", "add", w.WorktreePath, w.BranchName); err != nil {
-		return fmt.Errorf("failed to create worktree from branch %s: %w", w.BranchName, err)
+	if w.ExistingBranch {
+		if w.ResetBranch || w.Track || w.NoTrack || w.FromCommit != "" {
+			return fmt.Errorf("an existing branch is checked out as is: -B, --[no-]track and a commit-ish don't apply")
+		}
+		if !w.branchExists() {
+			return fmt.Errorf("invalid reference: %s", w.BranchName)
+		}
+		if path, err := w.branchCheckedOutAt(); err != nil {
+			return err
+		} else if path != "" {
+			return fmt.Errorf("%w: '%s' at '%s'", ErrBranchCheckedOut, w.BranchName, path)
+		}
+		return nil
 	}
 
+	if w.BranchName == "" {
+		return fmt.Errorf("a branch name is required unless the worktree is detached")
+	}
+	if _, err := w.runGitCommand(w.RepoPath, "check-ref-format", "--branch", w.BranchName); err != nil {
+		return fmt.Errorf("'%s' is not a valid branch name", w.BranchName)
+	}
+	if !w.ResetBranch && w.branchExists() {
+		return fmt.Errorf("%w: '%s'", ErrBranchExists, w.BranchName)
+	}
 	return nil
 }
 
+// branchExists reports whether BranchName exists in the repository
+func (w *Worktree) branchExists() bool {
+	_, err := w.runGitCommand(w.RepoPath, "show-ref", "--verify", "--quiet", "refs/heads/"+w.BranchName)
+	return err == nil
+}
 
-// setupWorktreeWithCoWProgress creates a worktree using copy-on-write with progress tracking
-func (w *Worktree) setupWorktreeWithCoWProgress(progress *ProgressTracker) error {
-	// Remove existing worktree path if it exists
-	if err := os.RemoveAll(w.WorktreePath); err != nil {
-		return fmt.Errorf("failed to remove existing worktree path: %w", err)
+// branchCheckedOutAt returns the path of the worktree that has BranchName
+// checked out, or an empty string if none does
+func (w *Worktree) branchCheckedOutAt() (string, error) {
+	output, err := w.runGitCommand(w.RepoPath, "worktree", "list", "--porcelain")
+	if err != nil {
+		return "", fmt.Errorf("failed to list worktrees: %w", err)
 	}
 
-	// Stage 1: Copy-on-write cloning
-	if progress != nil {
-		if w.ParallelCoW && w.ParallelDepth > 0 {
-			progress.StartStage(fmt.Sprintf("Depth-%d parallel CoW cloning", w.ParallelDepth))
-		} else if w.ParallelCoW && w.ForceParallel {
-			progress.StartStage("Forced parallel CoW cloning")
-		} else if w.ParallelCoW {
-			progress.StartStage("Parallel CoW cloning")
-		} else {
-			progress.StartStage("CoW cloning")
+	var path string
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.HasPrefix(line, "worktree ") {
+			path = strings.TrimPrefix(line, "worktree ")
+		} else if line == "branch refs/heads/"+w.BranchName {
+			return path, nil
 		}
 	}
-	
-	var err error
-	if w.ParallelCoW {
-		if w.ParallelDepth > 0 {
-			err = CloneDirectoryParallelDepth(w.RepoPath, w.WorktreePath, w.ParallelDepth, progress)
-		} else if w.ForceParallel {
-			err = CloneDirectoryParallelForced(w.RepoPath, w.WorktreePath, progress)
-		} else {
-			err = CloneDirectoryParallel(w.RepoPath, w.WorktreePath, progress)
+	return "", nil
+}
+
+// guessRemoteBranch returns <remote>/<BranchName> if exactly one remote has a
+// remote-tracking branch of that name, or an empty string otherwise
+func (w *Worktree) guessRemoteBranch() string {
+	output, err := w.runGitCommand(w.RepoPath, "remote")
+	if err != nil {
+		return ""
+	}
+
+	var matches []string
+	for _, remote := range strings.Fields(string(output)) {
+		ref := fmt.Sprintf("refs/remotes/%s/%s", remote, w.BranchName)
+		if _, err := w.runGitCommand(w.RepoPath, "show-ref", "--verify", "--quiet", ref); err == nil {
+			matches = append(matches, remote+"/"+w.BranchName)
 		}
-	} else {
-		err = CloneDirectory(w.RepoPath, w.WorktreePath)
 	}
-	
-	if err != nil {
+
+	if len(matches) != 1 {
+		return ""
+	}
+	return matches[0]
+}
+
+// createBranch creates or resets BranchName at the start point with git branch,
+// so tracking setup and the refusal to reset a branch checked out elsewhere
+// behave exactly like git worktree add
+func (w *Worktree) createBranch() error {
+	args := []string{"branch"}
+	if w.ResetBranch && w.branchExists() {
+		args = append(args, "-f")
+	}
+	if w.Track {
+		args = append(args, "--track")
+	} else if w.NoTrack {
+		args = append(args, "--no-track")
+	}
+	args = append(args, w.BranchName, w.startPoint())
+
+	if output, err := w.runGitCommandCombined(w.RepoPath, args...); err != nil {
+		return fmt.Errorf("failed to create branch %s: %w: %s", w.BranchName, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// branchFiles lists the files git branch may change for BranchName: the loose
+// ref, its reflog, packed-refs and the config holding tracking settings
+func (w *Worktree) branchFiles() []string {
+	commonDir := w.commonDir()
+	ref := filepath.FromSlash("refs/heads/" + w.BranchName)
+	return []string{
+		filepath.Join(commonDir, ref),
+		filepath.Join(commonDir, "logs", ref),
+		filepath.Join(commonDir, "packed-refs"),
+		filepath.Join(commonDir, "config"),
+	}
+}
+
+// startPoint is the commit-ish new branches start from. The original name is
+// kept (rather than BaseCommit) so git can set up tracking for remote branches.
+func (w *Worktree) startPoint() string {
+	if w.FromCommit != "" {
+		return w.FromCommit
+	}
+	return w.BaseCommit
+}
+
+// gitWorktreeAddArgs builds the git worktree add invocation equivalent to this worktree's options
+func (w *Worktree) gitWorktreeAddArgs() []string {
+	args := []string{"worktree", "add"}
+	switch {
+	case w.ExistingBranch:
+		return append(args, w.WorktreePath, w.BranchName)
+	case w.Detach:
+		args = append(args, "--detach")
+	case w.ResetBranch:
+		args = append(args, "-B", w.BranchName)
+	default:
+		args = append(args, "-b", w.BranchName)
+	}
+	if w.Track {
+		args = append(args, "--track")
+	} else if w.NoTrack {
+		args = append(args, "--no-track")
+	}
+	if w.NoCheckout {
+		args = append(args, "--no-checkout")
+	}
+	args = append(args, w.WorktreePath)
+	if start := w.startPoint(); start != "" {
+		args = append(args, start)
+	}
+	return args
+}
+
+// CreateFromExistingBranch creates a worktree from an existing branch. The
+// current checkout is cloned and only the tracked files that differ between
+// HEAD and the branch are updated, falling back to git worktree add when CoW
+// isn't available.
+func (w *Worktree) CreateFromExistingBranch() error {
+	w.ExistingBranch = true
+	if err := w.CreateCoWWorktree(); err != nil {
+		return fmt.Errorf("failed to create worktree from branch %s: %w", w.BranchName, err)
+	}
+
+	return nil
+}
+
+
+// setupWorktreeWithCoWProgress creates a worktree using copy-on-write with progress tracking.
+// Every step is journaled, and a failure or SIGINT undoes the completed steps in reverse.
+// The repository lock is released while cloning and held again from the branch
+// step on, including during rollback.
+func (w *Worktree) setupWorktreeWithCoWProgress(progress *ProgressTracker, lock *repoLock) (err error) {
+	tx := newJournal()
+	defer tx.close()
+	var displaced *displacedTarget
+	defer func() {
+		if err == nil {
+			// Whatever --force replaced is only deleted once the worktree is complete
+			if displaced != nil {
+				displaced.discard()
+			}
+			return
+		}
+		// Undo ref and registration changes under the lock they were made under
+		if lockErr := lock.acquire(w.lockTimeout()); lockErr != nil {
+			err = fmt.Errorf("%w (rolling back without the repository lock: %v)", err, lockErr)
+		}
+		if rollbackErr := tx.rollback(); rollbackErr != nil {
+			err = fmt.Errorf("%w (rollback incomplete: %v)", err, rollbackErr)
+		}
 		if progress != nil {
 			progress.Error(err)
 		}
-		return fmt.Errorf("failed to clone directory: %w", err)
+	}()
+
+	// Move anything --force replaces out of the way, keeping it until the end
+	err = tx.step("target", func() (err error) {
+		displaced, err = w.displaceTarget()
+		return err
+	}, func() error {
+		if displaced == nil {
+			return nil
+		}
+		return displaced.restore()
+	})
+	if err != nil {
+		return err
 	}
-	if progress != nil {
-		progress.FinishStage()
+
+	// Stage 1: Copy-on-write cloning, or an empty directory for --no-checkout.
+	// Cloning is the slow part, so concurrent creations do it in parallel.
+	if err := lock.release(); err != nil {
+		return err
+	}
+	createdParents := missingParents(w.WorktreePath)
+	err = tx.step("clone", func() error {
+		if w.NoCheckout {
+			w.Backend = ""
+			if err := os.MkdirAll(w.WorktreePath, 0755); err != nil {
+				return fmt.Errorf("failed to create worktree directory: %w", err)
+			}
+			return nil
+		}
+		return w.cloneCheckout(tx.ctx, progress)
+	}, func() error {
+		return w.discardClone(createdParents)
+	})
+	if err != nil {
+		return err
 	}
 
-	// Stage 2: Git worktree registration  
+	// Stage 2: Branch creation and git worktree registration
 	if progress != nil {
 		progress.StartStage("Setting up git worktree")
 	}
+	if err := lock.acquire(w.lockTimeout()); err != nil {
+		return err
+	}
+	// Another process may have taken the branch while the lock was released
+	if err := w.validateMode(); err != nil {
+		return err
+	}
 
-	// Manually register the cloned directory as a proper git worktree
-	if err := w.registerWorktreeManually(); err != nil {
-		// Clean up the clone if worktree registration fails
-		os.RemoveAll(w.WorktreePath)
-		if progress != nil {
-			progress.Error(err)
+	// Create the branch before registration: once the new worktree's HEAD names
+	// it, git would treat the branch as checked out and refuse to reset it
+	if !w.Detach && !w.ExistingBranch {
+		snapshot, err := takeFileSnapshot(w.branchFiles()...)
+		if err != nil {
+			return err
+		}
+		if err := tx.step("ref", w.createBranch, snapshot.restore); err != nil {
+			return err
 		}
+	}
+
+	// Manually register the cloned directory as a proper git worktree
+	worktreesDirExisted := true
+	if _, err := os.Stat(filepath.Join(w.commonDir(), "worktrees")); os.IsNotExist(err) {
+		worktreesDirExisted = false
+	}
+	err = tx.step("metadata", w.registerWorktreeManually, func() error {
+		return w.unregisterWorktree(worktreesDirExisted)
+	})
+	if err != nil {
 		return fmt.Errorf("failed to register worktree: %w", err)
 	}
-	
-	// Stage 3: Set up branch references directly (after worktree registration)
-	// Use git update-ref to directly set the branch reference to preserve commit history
-	branchRef := fmt.Sprintf("refs/heads/%s", w.BranchName)
-	if _, err := w.runGitCommand(w.WorktreePath, "update-ref", branchRef, w.BaseCommit); err != nil {
-		// Clean up the clone if branch reference setup fails
-		os.RemoveAll(w.WorktreePath)
-		if progress != nil {
-			progress.Error(err)
-		}
-		return fmt.Errorf("failed to set branch reference %s to commit %s: %w", branchRef, w.BaseCommit, err)
+	if err := tx.step("HEAD", w.writeWorktreeHEAD, w.removeWorktreeHEAD); err != nil {
+		return fmt.Errorf("failed to register worktree: %w", err)
 	}
-	
-	// Use git symbolic-ref to set HEAD to point to our new branch
-	if _, err := w.runGitCommand(w.WorktreePath, "symbolic-ref", "HEAD", branchRef); err != nil {
-		// Clean up the clone if HEAD setup fails
-		os.RemoveAll(w.WorktreePath)
-		if progress != nil {
-			progress.Error(err)
+
+	// Per-worktree settings go in before anything is checked out under them
+	configSnapshot, err := takeFileSnapshot(w.worktreeConfigFiles()...)
+	if err != nil {
+		return err
+	}
+	if err := tx.step("config", w.applyConfig, configSnapshot.restore); err != nil {
+		return err
+	}
+
+	// Give the worktree a populated index so git doesn't see every tracked file as new.
+	// --no-checkout leaves the index out, like git worktree add.
+	if !w.NoCheckout {
+		err = tx.step("index", func() error {
+			if err := cloneIndex(w.RepoPath, w.gitDir(), w.WorktreePath, w.registeredGitDir, w.lfsVerifier(w.RepoPath)); err != nil {
+				return fmt.Errorf("failed to clone index: %w", err)
+			}
+			return nil
+		}, nil)
+		if err != nil {
+			return err
+		}
+
+		// Submodules' copied .git files point back into the source until they
+		// get git directories of their own
+		if err := tx.step("submodules", w.cloneSubmodules, nil); err != nil {
+			return err
 		}
-		return fmt.Errorf("failed to set HEAD to branch %s: %w", w.BranchName, err)
 	}
-	
+
+	// A sparse set of its own may enable extensions.worktreeConfig in the shared config
+	configSnapshot, err = takeFileSnapshot(w.worktreeConfigFiles()...)
+	if err != nil {
+		return err
+	}
+	if err := tx.step("sparse", w.setupSparseCheckout, configSnapshot.restore); err != nil {
+		return err
+	}
+
 	if progress != nil {
 		progress.FinishStage()
 	}
 
+	if w.NoCheckout {
+		return w.unlockRegistration()
+	}
+
+	// Without carry, the clone goes back to a clean checkout before anything else
+	if w.Carry == CarryNone {
+		if err := tx.step("carry", w.dropCarriedChanges, nil); err != nil {
+			return err
+		}
+	}
+
+	// Stage 3: Check out the requested commit if it differs from the cloned checkout
+	if w.BaseCommit != w.sourceCommit {
+		if progress != nil {
+			progress.StartStage(fmt.Sprintf("Checking out %s", shortCommit(w.BaseCommit)))
+		}
+
+		err = tx.step("checkout", func() error {
+			if err := w.reconcileToBaseCommit(); err != nil {
+				return err
+			}
+			return w.updateChangedSubmodules()
+		}, nil)
+		if err != nil {
+			return err
+		}
+
+		if progress != nil {
+			progress.UpdateStage(fmt.Sprintf("%d tracked paths changed", len(w.ChangedPaths)))
+			progress.FinishStage()
+		}
+	}
+
 	// Stage 4: Path rewriting (if enabled)
 	if !w.NoRewrite {
 		if progress != nil {
 			progress.StartStage("Fixing absolute paths")
 		}
-		
-		if err := w.rewriteAbsolutePathsWithProgress(progress); err != nil {
-			// Log warning but don't fail - path rewriting is best effort
-			if progress != nil {
-				progress.UpdateStage("(skipped due to error)")
+
+		err = tx.step("rewrite", func() error {
+			stats, err := w.rewriteAbsolutePathsWithProgress(tx.ctx, progress)
+			w.RewriteStats = &stats
+			if err != nil {
+				// Log warning but don't fail - path rewriting is best effort
+				w.logger().Warn("path rewriting failed", "error", err)
+				if progress != nil {
+					progress.UpdateStage("(skipped due to error)")
+				}
 			}
+			return nil
+		}, nil)
+		if err != nil {
+			return err
 		}
-		
+
 		if progress != nil {
 			progress.FinishStage()
 		}
 	}
 
+	// The worktree is complete, so moved changes can now leave the source
+	if w.Carry == CarryMove {
+		snapshot, err := takeFileSnapshot(w.stashFiles()...)
+		if err != nil {
+			return err
+		}
+		var stash string
+		err = tx.step("stash", func() (err error) {
+			stash, err = w.stashSourceChanges()
+			return err
+		}, func() error {
+			if err := w.restoreStashedChanges(stash); err != nil {
+				return err
+			}
+			return snapshot.restore()
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.unlockRegistration()
+}
+
+// cloneCheckout materializes the source checkout at WorktreePath with the selected backend,
+// stopping early when ctx is cancelled
+func (w *Worktree) cloneCheckout(ctx context.Context, progress *ProgressTracker) error {
+	// Pick the backend before touching anything so an unsupported choice fails cleanly
+	backend, err := selectBackendWithFallback(w.Backend, w.Fallback, w.RepoPath, w.logger())
+	if err != nil {
+		return err
+	}
+	w.logger().Info("selected backend", "backend", backend.Name(), "requested", w.Backend)
+	w.Backend = backend.Name()
+
+	// Stashing in the source would rewrite the overlay's lower layer under it
+	if w.Carry == CarryMove && w.Backend == BackendOverlay {
+		return fmt.Errorf("--carry=%s is not supported with the %s backend", CarryMove, BackendOverlay)
+	}
+
+	if progress != nil {
+		if w.ParallelCoW && w.ParallelDepth > 0 {
+			progress.StartStage(fmt.Sprintf("Depth-%d parallel CoW cloning (%s)", w.ParallelDepth, w.Backend))
+		} else if w.ParallelCoW && w.ForceParallel {
+			progress.StartStage(fmt.Sprintf("Forced parallel CoW cloning (%s)", w.Backend))
+		} else if w.ParallelCoW {
+			progress.StartStage(fmt.Sprintf("Parallel CoW cloning (%s)", w.Backend))
+		} else if w.Backend == BackendCopy {
+			progress.StartStage("Copying files (copy)")
+		} else if w.Backend == BackendHardlink {
+			progress.StartStage("Hardlinking dependencies (hardlink)")
+		} else {
+			progress.StartStage(fmt.Sprintf("CoW cloning (%s)", w.Backend))
+		}
+	}
+
+	err = backend.Clone(w.RepoPath, w.WorktreePath, CloneOptions{
+		Parallel:      w.ParallelCoW,
+		ForceParallel: w.ForceParallel,
+		ParallelDepth: w.ParallelDepth,
+		Progress:      progress,
+		HardlinkPaths: w.HardlinkPaths,
+		Exclude:       w.repo.cloneExcludes(),
+		Logger:        w.logger(),
+		Context:       ctx,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clone directory: %w", err)
+	}
+	if progress != nil {
+		progress.FinishStage()
+	}
+
+	return nil
+}
+
+// reconcileToBaseCommit moves the cloned checkout from the source commit to BaseCommit.
+// A two-tree read-tree merge rewrites only the tracked paths that differ between
+// the commits, so ignored build artifacts stay in place. Like git checkout, it
+// refuses to overwrite local modifications to those paths.
+func (w *Worktree) reconcileToBaseCommit() error {
+	output, err := w.runGitCommand(w.WorktreePath, "diff", "--name-only", "--no-renames", "-z", w.sourceCommit, w.BaseCommit)
+	if err != nil {
+		return fmt.Errorf("failed to diff %s against %s: %w", shortCommit(w.sourceCommit), shortCommit(w.BaseCommit), err)
+	}
+	var changed []string
+	for _, path := range strings.Split(string(output), "\x00") {
+		if path != "" {
+			changed = append(changed, path)
+		}
+	}
+
+	if output, err := w.runGitCommandCombined(w.WorktreePath, "read-tree", "-m", "-u", w.sourceCommit, w.BaseCommit); err != nil {
+		return fmt.Errorf("failed to check out %s: %w: %s", shortCommit(w.BaseCommit), err, strings.TrimSpace(string(output)))
+	}
+
+	// Only a checkout that happened has changed paths to report
+	w.ChangedPaths = changed
 	return nil
 }
 
-// rewriteAbsolutePathsWithProgress rewrites paths with progress tracking
-func (w *Worktree) rewriteAbsolutePathsWithProgress(progress *ProgressTracker) error {
-	return rewriteAbsolutePathsWithProgress(w.RepoPath, w.WorktreePath, progress)
+// shortCommit abbreviates a commit hash for messages
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
+
+// discardClone deletes a partially set up worktree after releasing its backend
+// state, along with the parent directories created for it
+func (w *Worktree) discardClone(createdParents []string) error {
+	if backend, err := LookupBackend(w.Backend); err == nil {
+		if err := backend.Cleanup(w.WorktreePath); err != nil {
+			return fmt.Errorf("failed to clean up %s backend: %w", backend.Name(), err)
+		}
+	}
+	if err := os.RemoveAll(w.WorktreePath); err != nil {
+		return fmt.Errorf("failed to remove worktree: %w", err)
+	}
+	for _, dir := range createdParents {
+		os.Remove(dir)
+	}
+	return nil
 }
 
+// rewriteAbsolutePathsWithProgress rewrites paths with progress tracking until ctx is cancelled
+func (w *Worktree) rewriteAbsolutePathsWithProgress(ctx context.Context, progress *ProgressTracker) (PathRewriteStats, error) {
+	return rewriteAbsolutePathsWithProgress(ctx, w.RepoPath, w.WorktreePath, progress, w.logger())
+}
+
+
+// CreateRegularWorktree creates the worktree with git worktree add, which
+// checks out tracked files only. It applies the same preflight checks as
+// CreateCoWWorktree.
+func (w *Worktree) CreateRegularWorktree() error {
+	lock, err := w.lockRepository()
+	if err != nil {
+		return err
+	}
+	defer lock.close()
 
-// setupRegularWorktree creates a worktree using the traditional git worktree method
-func (w *Worktree) setupRegularWorktree(headCommit string) error {
-	if _, err := w.runGitCommand(w.RepoPath, "worktree", "add", "-b", w.BranchName, w.WorktreePath, headCommit); err != nil {
-		return fmt.Errorf("failed to create worktree from commit %s: %w", headCommit, err)
+	if err := w.Preflight(); err != nil {
+		return err
+	}
+	// Resolving the commits up front records BaseCommit, which git worktree add checks out
+	if err := w.resolveCommits(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(w.WorktreePath), 0755); err != nil {
+		return fmt.Errorf("failed to create worktrees directory: %w", err)
+	}
+	return w.addWithGit()
+}
+
+// addWithGit runs git worktree add once Preflight has passed, replacing the
+// target only if git succeeds. The repository lock must be held.
+func (w *Worktree) addWithGit() error {
+	displaced, err := w.displaceTarget()
+	if err != nil {
+		return err
+	}
+	if output, err := w.runGitCommandCombined(w.RepoPath, w.gitWorktreeAddArgs()...); err != nil {
+		err = fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+		if restoreErr := displaced.restore(); restoreErr != nil {
+			err = fmt.Errorf("%w (restore incomplete: %v)", err, restoreErr)
+		}
+		return fmt.Errorf("failed to create worktree with git: %w", err)
+	}
+	displaced.discard()
+	w.Backend = FallbackGit
+
+	w.ID = worktreeIDForPath(w.commonDir(), w.WorktreePath)
+	w.registeredGitDir = filepath.Join(w.commonDir(), "worktrees", w.ID)
+	if err := w.applyConfig(); err != nil {
+		return err
+	}
+
+	// git worktree add copies the source's sparse-checkout; a set of its own replaces it
+	if w.Sparse != nil {
+		if err := w.applySparseSet(); err != nil {
+			return err
+		}
+	}
+	if w.Carry == CarryMove && !w.NoCheckout {
+		return w.MoveChangesFromSource()
 	}
 	return nil
 }
 
 // Remove removes the worktree but keeps the branch
 func (w *Worktree) Remove() error {
+	lock, err := w.lockRepository()
+	if err != nil {
+		return err
+	}
+	defer lock.close()
+
+	return w.removeWorktree()
+}
+
+// removeWorktree releases backend state and deletes the worktree directory.
+// Some backends (overlay) must clean up before the files can be deleted, which
+// can leave no .git file for git worktree remove to validate; in that case the
+// directory is deleted directly and the git metadata pruned.
+func (w *Worktree) removeWorktree() error {
+	if backend := w.recordedBackend(); backend != nil {
+		if err := backend.Cleanup(w.WorktreePath); err != nil {
+			return fmt.Errorf("failed to clean up %s backend: %w", backend.Name(), err)
+		}
+	}
+
+	if _, err := os.Lstat(filepath.Join(w.WorktreePath, ".git")); os.IsNotExist(err) {
+		if err := os.RemoveAll(w.WorktreePath); err != nil {
+			return fmt.Errorf("failed to remove worktree: %w", err)
+		}
+		return w.prune()
+	}
+
 	if _, err := w.runGitCommand(w.RepoPath, "worktree", "remove", "-f", w.WorktreePath); err != nil {
 		return fmt.Errorf("failed to remove worktree: %w", err)
 	}
 	return nil
 }
 
+// recordedBackend returns the backend the worktree was created with, or nil if unknown
+func (w *Worktree) recordedBackend() CloneBackend {
+	name := w.Backend
+	if name == "" || name == BackendAuto {
+		gitDir, err := resolveWorktreeGitDir(w.WorktreePath)
+		if err != nil {
+			return nil
+		}
+		meta, err := readWorktreeMetadata(gitDir)
+		if err != nil {
+			return nil
+		}
+		name = meta.Backend
+	}
+
+	backend, err := LookupBackend(name)
+	if err != nil {
+		return nil
+	}
+	return backend
+}
+
 // RemoveWithBranch removes the worktree and associated branch
 func (w *Worktree) RemoveWithBranch() error {
+	lock, err := w.lockRepository()
+	if err != nil {
+		return err
+	}
+	defer lock.close()
+
 	var errs []error
 
 	// Check if worktree path exists before attempting removal
 	if _, err := os.Stat(w.WorktreePath); err == nil {
-		// Remove the worktree using git command
-		if _, err := w.runGitCommand(w.RepoPath, "worktree", "remove", "-f", w.WorktreePath); err != nil {
+		// Remove the worktree, releasing any backend state first
+		if err := w.removeWorktree(); err != nil {
 			errs = append(errs, err)
 		}
 	} else if !os.IsNotExist(err) {
 		errs = append(errs, fmt.Errorf("failed to check worktree path: %w", err))
 	}
 
-	// Open the repository for branch cleanup
-	repo, err := git.PlainOpen(w.RepoPath)
+	// Open the repository for branch cleanup; branches live in the common git directory
+	repo, err := git.PlainOpen(w.commonDir())
 	if err != nil {
 		errs = append(errs, fmt.Errorf("failed to open repository for cleanup: %w", err))
 		return combineErrors(errs)
@@ -266,7 +903,7 @@ func (w *Worktree) RemoveWithBranch() error {
 	}
 
 	// Prune the worktree to clean up any remaining references
-	if err := w.Prune(); err != nil {
+	if err := w.prune(); err != nil {
 		errs = append(errs, err)
 	}
 
@@ -279,102 +916,144 @@ func (w *Worktree) RemoveWithBranch() error {
 
 // Prune removes all working tree administrative files and directories
 func (w *Worktree) Prune() error {
+	lock, err := w.lockRepository()
+	if err != nil {
+		return err
+	}
+	defer lock.close()
+
+	return w.prune()
+}
+
+// prune runs git worktree prune; the repository lock must be held
+func (w *Worktree) prune() error {
 	if _, err := w.runGitCommand(w.RepoPath, "worktree", "prune"); err != nil {
 		return fmt.Errorf("failed to prune worktrees: %w", err)
 	}
 	return nil
 }
 
-// ListWorktrees returns a list of all worktrees in the repository
-func ListWorktrees(repoPath string) ([]WorktreeInfo, error) {
-	cmd := exec.Command("git", "worktree", "list", "--porcelain")
-	cmd.Dir = repoPath
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("failed to list worktrees: %w", err)
-	}
-
-	var worktrees []WorktreeInfo
-	var current WorktreeInfo
-	lines := strings.Split(string(output), "\n")
-	
-	for _, line := range lines {
-		if strings.HasPrefix(line, "worktree ") {
-			if current.Path != "" {
-				worktrees = append(worktrees, current)
-			}
-			current = WorktreeInfo{Path: strings.TrimPrefix(line, "worktree ")}
-		} else if strings.HasPrefix(line, "branch ") {
-			branchPath := strings.TrimPrefix(line, "branch ")
-			current.Branch = strings.TrimPrefix(branchPath, "refs/heads/")
-		} else if strings.HasPrefix(line, "HEAD ") {
-			current.HEAD = strings.TrimPrefix(line, "HEAD ")
-		}
-	}
-	
-	if current.Path != "" {
-		worktrees = append(worktrees, current)
-	}
-
-	return worktrees, nil
+// runGitCommand executes a git command in the specified directory and
+// returns its output, logging it at debug level
+func (w *Worktree) runGitCommand(dir string, args ...string) ([]byte, error) {
+	cmd := w.gitCommand(dir, args...)
+	return logCommand(w.logger(), cmd, cmd.Output)
 }
 
-// WorktreeInfo represents information about a git worktree
-type WorktreeInfo struct {
-	Path   string
-	Branch string
-	HEAD   string
+// runGitCommandCombined is runGitCommand returning stdout and stderr together
+func (w *Worktree) runGitCommandCombined(dir string, args ...string) ([]byte, error) {
+	cmd := w.gitCommand(dir, args...)
+	return logCommand(w.logger(), cmd, cmd.CombinedOutput)
 }
 
-// runGitCommand executes a git command in the specified directory
-func (w *Worktree) runGitCommand(dir string, args ...string) ([]byte, error) {
-	cmd := exec.Command("git", args...)
-	cmd.Dir = dir
-	return cmd.Output()
+// gitCommand prepares a git command in dir, pinned to the discovered source
+// repository when dir is the source checkout
+func (w *Worktree) gitCommand(dir string, args ...string) *exec.Cmd {
+	if w.repo != nil && dir == w.RepoPath {
+		return w.repo.gitCommand(args...)
+	}
+	return gitCommand(dir, args...)
 }
 
-// registerWorktreeManually manually registers a CoW clone as a git worktree
+// registerWorktreeManually creates the worktree's git directory under
+// .git/worktrees: a new id, locked until the worktree is complete, with its
+// commondir, gitdir and coworktree metadata
 func (w *Worktree) registerWorktreeManually() error {
-	// Create worktree name (using the branch name)
-	worktreeName := w.BranchName
-	
-	// Create the worktree metadata directory in main repo
-	worktreeMetaDir := filepath.Join(w.RepoPath, ".git", "worktrees", worktreeName)
-	if err := os.MkdirAll(worktreeMetaDir, 0755); err != nil {
-		return fmt.Errorf("failed to create worktree metadata directory: %w", err)
+	// git records absolute, symlink-free paths in gitdir and .git
+	worktreePath, err := filepath.Abs(w.WorktreePath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve worktree path: %w", err)
 	}
-	
-	// Create HEAD file in worktree metadata pointing to the branch
-	headFile := filepath.Join(worktreeMetaDir, "HEAD")
-	headRef := fmt.Sprintf("ref: refs/heads/%s\n", w.BranchName)
-	if err := os.WriteFile(headFile, []byte(headRef), 0644); err != nil {
-		return fmt.Errorf("failed to write HEAD file: %w", err)
+	if resolved, err := filepath.EvalSymlinks(worktreePath); err == nil {
+		worktreePath = resolved
 	}
-	
+	commonDir := w.commonDir()
+
+	// Allocate the worktree id from the directory name, as git worktree add does
+	id, worktreeMetaDir, err := allocateWorktreeID(commonDir, filepath.Base(worktreePath))
+	if err != nil {
+		return err
+	}
+	w.ID = id
+	w.registeredGitDir = worktreeMetaDir
+
+	// Lock the entry until the worktree is complete so a concurrent git worktree
+	// prune doesn't remove it while the .git file is still missing
+	if err := os.WriteFile(filepath.Join(worktreeMetaDir, lockedFileName), []byte("initializing\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write locked file: %w", err)
+	}
+
 	// Create commondir file pointing to main repo's .git
 	commondirFile := filepath.Join(worktreeMetaDir, "commondir")
 	if err := os.WriteFile(commondirFile, []byte("../..\n"), 0644); err != nil {
 		return fmt.Errorf("failed to write commondir file: %w", err)
 	}
-	
+
 	// Create gitdir file pointing to worktree's .git file
 	gitdirFile := filepath.Join(worktreeMetaDir, "gitdir")
-	worktreeGitFile := filepath.Join(w.WorktreePath, ".git")
+	worktreeGitFile := filepath.Join(worktreePath, ".git")
 	if err := os.WriteFile(gitdirFile, []byte(worktreeGitFile+"\n"), 0644); err != nil {
 		return fmt.Errorf("failed to write gitdir file: %w", err)
 	}
-	
-	// Replace worktree's .git directory with .git file pointing to metadata
-	worktreeGitDir := filepath.Join(w.WorktreePath, ".git")
-	if err := os.RemoveAll(worktreeGitDir); err != nil {
+
+	// Record the backend so removal knows how to tear the worktree down, and
+	// when and from which commit the worktree was created for list
+	meta := worktreeMetadata{Backend: w.Backend, Created: time.Now().UTC().Truncate(time.Second), BaseCommit: w.BaseCommit}
+	if err := writeWorktreeMetadata(worktreeMetaDir, meta); err != nil {
+		return fmt.Errorf("failed to write coworktree metadata: %w", err)
+	}
+
+	return nil
+}
+
+// unregisterWorktree removes the git directory created by registerWorktreeManually,
+// and the worktrees directory if registration created it
+func (w *Worktree) unregisterWorktree(worktreesDirExisted bool) error {
+	if w.registeredGitDir == "" {
+		return nil
+	}
+	if err := os.RemoveAll(w.registeredGitDir); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", w.registeredGitDir, err)
+	}
+	if !worktreesDirExisted {
+		os.Remove(filepath.Dir(w.registeredGitDir))
+	}
+	w.registeredGitDir = ""
+	w.ID = ""
+	return nil
+}
+
+// writeWorktreeHEAD points the worktree's HEAD at the branch, or the commit when
+// detached, and replaces the clone's .git with a file linking it to its git directory
+func (w *Worktree) writeWorktreeHEAD() error {
+	headRef := fmt.Sprintf("ref: refs/heads/%s\n", w.BranchName)
+	if w.Detach {
+		headRef = w.BaseCommit + "\n"
+	}
+	if err := os.WriteFile(filepath.Join(w.registeredGitDir, "HEAD"), []byte(headRef), 0644); err != nil {
+		return fmt.Errorf("failed to write HEAD file: %w", err)
+	}
+
+	// The built-in backends exclude .git from the clone, but custom ones may not
+	worktreeGitFile := filepath.Join(w.WorktreePath, ".git")
+	if err := os.RemoveAll(worktreeGitFile); err != nil {
 		return fmt.Errorf("failed to remove .git directory: %w", err)
 	}
-	
-	gitFileContent := fmt.Sprintf("gitdir: %s\n", worktreeMetaDir)
+
+	gitFileContent := fmt.Sprintf("gitdir: %s\n", w.registeredGitDir)
 	if err := os.WriteFile(worktreeGitFile, []byte(gitFileContent), 0644); err != nil {
 		return fmt.Errorf("failed to write .git file: %w", err)
 	}
-	
+	return nil
+}
+
+// removeWorktreeHEAD undoes writeWorktreeHEAD
+func (w *Worktree) removeWorktreeHEAD() error {
+	for _, path := range []string{filepath.Join(w.registeredGitDir, "HEAD"), filepath.Join(w.WorktreePath, ".git")} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -1,6 +1,7 @@
 package cowgit
 
 import (
+	"errors"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -835,4 +836,323 @@ func runCommand(dir string, name string, args ...string) error {
 	cmd := exec.Command(name, args...)
 	cmd.Dir = dir
 	return cmd.Run()
-}
\ No newline at end of file
+}
+
+func TestCoWWorktreeFromCommit(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "coworktree-commitish-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	repoDir := filepath.Join(tempDir, "repo")
+	if err := os.MkdirAll(repoDir, 0755); err != nil {
+		t.Fatalf("Failed to create repo dir: %v", err)
+	}
+	setupGitRepo(t, repoDir)
+
+	writeFiles := func(files map[string]string) {
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(repoDir, name), []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to write %s: %v", name, err)
+			}
+		}
+	}
+
+	// Commit A: a.txt v1, b.txt, ignore build artifacts
+	writeFiles(map[string]string{"a.txt": "v1\n", "b.txt": "b\n", "same.txt": "same\n", ".gitignore": "build/\n"})
+	if err := runCommand(repoDir, "git", "add", "."); err != nil {
+		t.Fatalf("Failed to add files: %v", err)
+	}
+	if err := runCommand(repoDir, "git", "commit", "-m", "Commit A"); err != nil {
+		t.Fatalf("Failed to commit A: %v", err)
+	}
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("Failed to get commit A: %v", err)
+	}
+	commitA := strings.TrimSpace(string(output))
+
+	// Commit B: a.txt v2, b.txt deleted, c.txt added
+	writeFiles(map[string]string{"a.txt": "v2\n", "c.txt": "c\n"})
+	if err := os.Remove(filepath.Join(repoDir, "b.txt")); err != nil {
+		t.Fatalf("Failed to remove b.txt: %v", err)
+	}
+	if err := runCommand(repoDir, "git", "add", "-A"); err != nil {
+		t.Fatalf("Failed to stage changes: %v", err)
+	}
+	if err := runCommand(repoDir, "git", "commit", "-m", "Commit B"); err != nil {
+		t.Fatalf("Failed to commit B: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(repoDir, "build"), 0755); err != nil {
+		t.Fatalf("Failed to create build dir: %v", err)
+	}
+	writeFiles(map[string]string{filepath.Join("build", "out.o"): "artifact\n"})
+
+	worktreePath := filepath.Join(tempDir, "commitish-wt")
+	worktree := NewWorktree(repoDir, worktreePath, "from-a")
+	worktree.Backend = BackendCopy
+	worktree.FromCommit = "HEAD~1"
+
+	if err := worktree.CreateCoWWorktree(); err != nil {
+		t.Fatalf("Failed to create worktree: %v", err)
+	}
+	if worktree.Backend != BackendCopy {
+		t.Fatalf("Worktree.Backend = %s, want %s (creation fell back)", worktree.Backend, BackendCopy)
+	}
+	if worktree.BaseCommit != commitA {
+		t.Errorf("BaseCommit = %s, want %s", worktree.BaseCommit, commitA)
+	}
+
+	want := []string{"a.txt", "b.txt", "c.txt"}
+	if strings.Join(worktree.ChangedPaths, ",") != strings.Join(want, ",") {
+		t.Errorf("ChangedPaths = %v, want %v", worktree.ChangedPaths, want)
+	}
+
+	expect := map[string]string{"a.txt": "v1\n", "b.txt": "b\n", "same.txt": "same\n", filepath.Join("build", "out.o"): "artifact\n"}
+	for name, content := range expect {
+		if got, err := os.ReadFile(filepath.Join(worktreePath, name)); err != nil {
+			t.Errorf("%s missing from worktree: %v", name, err)
+		} else if string(got) != content {
+			t.Errorf("%s = %q, want %q", name, string(got), content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(worktreePath, "c.txt")); !os.IsNotExist(err) {
+		t.Error("c.txt exists in worktree but is not in commit A")
+	}
+
+	cmd = exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = worktreePath
+	if output, err := cmd.Output(); err != nil {
+		t.Fatalf("Failed to get worktree HEAD: %v", err)
+	} else if head := strings.TrimSpace(string(output)); head != commitA {
+		t.Errorf("Worktree HEAD = %s, want %s", head, commitA)
+	}
+
+	cmd = exec.Command("git", "status", "--porcelain")
+	cmd.Dir = worktreePath
+	if output, err := cmd.Output(); err != nil {
+		t.Fatalf("git status failed: %v", err)
+	} else if status := strings.TrimSpace(string(output)); status != "" {
+		t.Errorf("Worktree is not clean after checking out commit A:\n%s", status)
+	}
+
+	bad := NewWorktree(repoDir, filepath.Join(tempDir, "bad-wt"), "bad")
+	bad.Backend = BackendCopy
+	bad.FromCommit = "no-such-commit"
+	if err := bad.CreateCoWWorktree(); err == nil {
+		t.Error("Expected error for an invalid commit-ish")
+	}
+}
+
+func TestCreateFromCommitWithLocalChangesFails(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "coworktree-dirty-commitish-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	repoDir := filepath.Join(tempDir, "repo")
+	if err := os.MkdirAll(repoDir, 0755); err != nil {
+		t.Fatalf("Failed to create repo dir: %v", err)
+	}
+	setupGitRepo(t, repoDir)
+
+	for _, content := range []string{"v1\n", "v2\n"} {
+		if err := os.WriteFile(filepath.Join(repoDir, "a.txt"), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write a.txt: %v", err)
+		}
+		if err := runCommand(repoDir, "git", "add", "a.txt"); err != nil {
+			t.Fatalf("Failed to add a.txt: %v", err)
+		}
+		if err := runCommand(repoDir, "git", "commit", "-m", "a.txt "+strings.TrimSpace(content)); err != nil {
+			t.Fatalf("Failed to commit a.txt: %v", err)
+		}
+	}
+
+	// A local modification to a path that differs between the commits makes the checkout fail
+	if err := os.WriteFile(filepath.Join(repoDir, "a.txt"), []byte("dirty\n"), 0644); err != nil {
+		t.Fatalf("Failed to modify a.txt: %v", err)
+	}
+
+	worktreePath := filepath.Join(tempDir, "dirty-wt")
+	worktree := NewWorktree(repoDir, worktreePath, "dirty")
+	worktree.Backend = BackendCopy
+	worktree.FromCommit = "HEAD~1"
+
+	// An explicitly requested backend fails instead of falling back to git worktree add
+	if err := worktree.CreateCoWWorktree(); err == nil {
+		t.Fatal("Expected error checking out over a local modification")
+	}
+	if len(worktree.ChangedPaths) != 0 {
+		t.Errorf("ChangedPaths = %v after a failed checkout, want none", worktree.ChangedPaths)
+	}
+	if _, err := os.Stat(worktreePath); !os.IsNotExist(err) {
+		t.Errorf("Worktree path exists after a failed creation: %v", err)
+	}
+	if err := runCommand(repoDir, "git", "rev-parse", "--verify", "--quiet", "refs/heads/dirty"); err == nil {
+		t.Error("Branch dirty exists after a failed creation")
+	}
+
+	// Automatic selection falls back to git worktree add, which reports nothing from the clone
+	fallback := NewWorktree(repoDir, filepath.Join(tempDir, "fallback-wt"), "fallback")
+	fallback.FromCommit = "HEAD~1"
+	if err := fallback.CreateCoWWorktree(); err != nil {
+		t.Fatalf("Failed to create worktree with the git fallback: %v", err)
+	}
+	if fallback.Backend != FallbackGit {
+		t.Errorf("Worktree.Backend = %s, want %s", fallback.Backend, FallbackGit)
+	}
+	if len(fallback.ChangedPaths) != 0 || fallback.RewriteStats != nil {
+		t.Errorf("Clone results left after the git fallback: ChangedPaths = %v, RewriteStats = %v", fallback.ChangedPaths, fallback.RewriteStats)
+	}
+
+	// git worktree add records the commit it checked out, like the clone does
+	parent := revParse(t, repoDir, "HEAD~1")
+	if fallback.BaseCommit != parent {
+		t.Errorf("BaseCommit after the git fallback = %s, want %s", fallback.BaseCommit, parent)
+	}
+	regular := NewWorktree(repoDir, filepath.Join(tempDir, "regular-wt"), "regular")
+	regular.FromCommit = "HEAD~1"
+	if err := regular.CreateRegularWorktree(); err != nil {
+		t.Fatalf("Failed to create regular worktree: %v", err)
+	}
+	if regular.BaseCommit != parent {
+		t.Errorf("BaseCommit of a regular worktree = %s, want %s", regular.BaseCommit, parent)
+	}
+	if head := revParse(t, regular.WorktreePath, "HEAD"); head != parent {
+		t.Errorf("Regular worktree HEAD = %s, want %s", head, parent)
+	}
+}
+
+func TestCreateFromExistingBranchKeepsBuildState(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "coworktree-existing-branch-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	repoDir := filepath.Join(tempDir, "repo")
+	if err := os.MkdirAll(repoDir, 0755); err != nil {
+		t.Fatalf("Failed to create repo dir: %v", err)
+	}
+	setupGitRepo(t, repoDir)
+
+	// A colleague's branch changes one tracked file
+	if err := runCommand(repoDir, "git", "checkout", "-q", "-b", "colleague"); err != nil {
+		t.Fatalf("Failed to create branch: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repoDir, "test.txt"), []byte("colleague content"), 0644); err != nil {
+		t.Fatalf("Failed to modify test.txt: %v", err)
+	}
+	if err := runCommand(repoDir, "git", "commit", "-q", "-am", "Colleague change"); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	if err := runCommand(repoDir, "git", "checkout", "-q", "-"); err != nil {
+		t.Fatalf("Failed to switch back: %v", err)
+	}
+
+	// Ignored build state in the main checkout
+	if err := os.WriteFile(filepath.Join(repoDir, ".git", "info", "exclude"), []byte("build/\n"), 0644); err != nil {
+		t.Fatalf("Failed to write exclude: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(repoDir, "build"), 0755); err != nil {
+		t.Fatalf("Failed to create build dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repoDir, "build", "out.o"), []byte("object"), 0644); err != nil {
+		t.Fatalf("Failed to create build output: %v", err)
+	}
+
+	worktreePath := filepath.Join(tempDir, "colleague-wt")
+	worktree := NewWorktreeWithOptions(repoDir, worktreePath, "colleague", true)
+	worktree.Backend = BackendCopy
+	worktree.Fallback = FallbackCopy
+	if err := worktree.CreateFromExistingBranch(); err != nil {
+		t.Fatalf("CreateFromExistingBranch failed: %v", err)
+	}
+
+	if content, err := os.ReadFile(filepath.Join(worktreePath, "test.txt")); err != nil || string(content) != "colleague content" {
+		t.Errorf("test.txt = %q, %v; want the branch's content", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(worktreePath, "build", "out.o")); err != nil {
+		t.Errorf("Build output missing from worktree: %v", err)
+	}
+	if len(worktree.ChangedPaths) != 1 || worktree.ChangedPaths[0] != "test.txt" {
+		t.Errorf("ChangedPaths = %v, want [test.txt]", worktree.ChangedPaths)
+	}
+
+	cmd := exec.Command("git", "symbolic-ref", "HEAD")
+	cmd.Dir = worktreePath
+	if output, err := cmd.Output(); err != nil || strings.TrimSpace(string(output)) != "refs/heads/colleague" {
+		t.Errorf("Worktree HEAD = %q, %v; want refs/heads/colleague", output, err)
+	}
+	cmd = exec.Command("git", "status", "--porcelain")
+	cmd.Dir = worktreePath
+	if output, err := cmd.Output(); err != nil || len(output) != 0 {
+		t.Errorf("Worktree not clean: %q, %v", output, err)
+	}
+
+	// Like git, a branch can only be checked out in one worktree
+	second := NewWorktreeWithOptions(repoDir, filepath.Join(tempDir, "second-wt"), "colleague", true)
+	second.Backend = BackendCopy
+	second.Fallback = FallbackCopy
+	if err := second.CreateFromExistingBranch(); !errors.Is(err, ErrBranchCheckedOut) || !strings.Contains(err.Error(), "already checked out") {
+		t.Errorf("Expected already checked out error, got %v", err)
+	}
+}
+
+func TestCreateRecordsStagesAndRewriteStats(t *testing.T) {
+	tempDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to resolve temp dir: %v", err)
+	}
+	repoDir := filepath.Join(tempDir, "repo")
+	setupCompatRepo(t, repoDir)
+
+	// An ignored file with the checkout's absolute path is rewritten
+	if err := os.WriteFile(filepath.Join(repoDir, ".gitignore"), []byte("venv/\n"), 0644); err != nil {
+		t.Fatalf("Failed to write .gitignore: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(repoDir, "venv"), 0755); err != nil {
+		t.Fatalf("Failed to create venv: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repoDir, "venv", "pyvenv.cfg"), []byte("prefix = "+repoDir+"/venv\n"), 0644); err != nil {
+		t.Fatalf("Failed to write pyvenv.cfg: %v", err)
+	}
+
+	worktree := NewWorktreeWithOptions(repoDir, filepath.Join(tempDir, "staged"), "staged", false)
+	worktree.Backend = BackendCopy
+	worktree.Fallback = FallbackCopy
+	progress := NewProgressTracker(false)
+	progress.SetQuiet(true)
+	if err := worktree.CreateCoWWorktreeWithProgress(progress); err != nil {
+		t.Fatalf("CreateCoWWorktreeWithProgress failed: %v", err)
+	}
+
+	stages := progress.Stages()
+	if len(stages) < 3 {
+		t.Fatalf("Stages = %+v, want clone, setup and rewrite", stages)
+	}
+	for _, stage := range stages {
+		if stage.Name == "" || stage.Duration <= 0 {
+			t.Errorf("Stage without name or duration: %+v", stage)
+		}
+	}
+	if worktree.RewriteStats == nil {
+		t.Fatal("RewriteStats not set")
+	}
+	if worktree.RewriteStats.ModifiedFiles != 1 {
+		t.Errorf("RewriteStats = %+v, want one modified file", *worktree.RewriteStats)
+	}
+
+	// The same branch can't be created twice
+	again := NewWorktreeWithOptions(repoDir, filepath.Join(tempDir, "again"), "staged", true)
+	again.Backend = BackendCopy
+	again.Fallback = FallbackCopy
+	if err := again.CreateCoWWorktree(); !errors.Is(err, ErrBranchExists) {
+		t.Errorf("Create with an existing branch = %v, want ErrBranchExists", err)
+	}
+}
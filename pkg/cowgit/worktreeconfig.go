@@ -0,0 +1,166 @@
+package cowgit
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/config"
+)
+
+// worktreeConfigFileName is the per-worktree config file in a worktree's git
+// directory, read by git when extensions.worktreeConfig is enabled
+const worktreeConfigFileName = "config.worktree"
+
+// ValidateConfig checks that every key in cfg is a well-formed git config key:
+// section.name or section.subsection.name
+func ValidateConfig(cfg map[string]string) error {
+	for key := range cfg {
+		if err := validateConfigKey(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateConfigKey applies git's rules for config key names
+func validateConfigKey(key string) error {
+	first, last := strings.Index(key, "."), strings.LastIndex(key, ".")
+	if first <= 0 || last == len(key)-1 || strings.ContainsRune(key, '\n') {
+		return fmt.Errorf("invalid config key %q: want section.name or section.subsection.name", key)
+	}
+	section, name := key[:first], key[last+1:]
+	for _, r := range section {
+		if !isConfigKeyRune(r) {
+			return fmt.Errorf("invalid config key %q: bad section %q", key, section)
+		}
+	}
+	for i, r := range name {
+		if !isConfigKeyRune(r) || (i == 0 && !isLetter(r)) {
+			return fmt.Errorf("invalid config key %q: bad name %q", key, name)
+		}
+	}
+	return nil
+}
+
+// isConfigKeyRune reports whether r may appear in a config section or variable name
+func isConfigKeyRune(r rune) bool {
+	return isLetter(r) || (r >= '0' && r <= '9') || r == '-'
+}
+
+// isLetter reports whether r is an ASCII letter
+func isLetter(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+// worktreeConfigFiles returns the shared config files that change when
+// extensions.worktreeConfig is enabled
+func (w *Worktree) worktreeConfigFiles() []string {
+	return []string{filepath.Join(w.commonDir(), "config"), filepath.Join(w.commonDir(), worktreeConfigFileName)}
+}
+
+// applyConfig writes Config into the new worktree's config.worktree, enabling
+// extensions.worktreeConfig first if it isn't already
+func (w *Worktree) applyConfig() error {
+	if len(w.Config) == 0 {
+		return nil
+	}
+	if err := w.enableWorktreeConfig(); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(w.Config))
+	for key := range w.Config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := w.setWorktreeConfig(key, w.Config[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enableWorktreeConfig turns on extensions.worktreeConfig like git
+// sparse-checkout init does. core.bare and core.worktree describe the main
+// worktree only, so they first move from the shared config to the main
+// worktree's config.worktree, where the other worktrees no longer see them.
+func (w *Worktree) enableWorktreeConfig() error {
+	if w.sourceConfig("extensions.worktreeConfig", "--bool") == "true" {
+		return nil
+	}
+
+	shared := filepath.Join(w.commonDir(), "config")
+	main := filepath.Join(w.commonDir(), worktreeConfigFileName)
+	for _, key := range []string{"core.bare", "core.worktree"} {
+		output, err := w.runGitCommand(w.RepoPath, "config", "--file", shared, "--get", key)
+		value := strings.TrimSpace(string(output))
+		if err != nil || (key == "core.bare" && value != "true") {
+			continue
+		}
+		if err := w.gitConfigFile(main, key, value); err != nil {
+			return err
+		}
+		if err := w.gitConfigFile(shared, "--unset", key); err != nil {
+			return err
+		}
+	}
+	return w.gitConfigFile(shared, "extensions.worktreeConfig", "true")
+}
+
+// sourceConfig returns a config value as the source checkout sees it, or an
+// empty string if it isn't set
+func (w *Worktree) sourceConfig(key string, flags ...string) string {
+	output, err := w.runGitCommand(w.RepoPath, append(append([]string{"config"}, flags...), "--get", key)...)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// setWorktreeConfig sets a key in the new worktree's config.worktree
+func (w *Worktree) setWorktreeConfig(key, value string) error {
+	return w.gitConfigFile(filepath.Join(w.registeredGitDir, worktreeConfigFileName), key, value)
+}
+
+// gitConfigFile runs git config on a single config file
+func (w *Worktree) gitConfigFile(file string, args ...string) error {
+	output, err := w.runGitCommandCombined(w.RepoPath, append([]string{"config", "--file", file}, args...)...)
+	if err != nil {
+		return fmt.Errorf("failed to update %s: git config %s: %w: %s", file, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// readWorktreeConfig returns the settings in a worktree's config.worktree as
+// flattened keys, or nil if it has none
+func readWorktreeConfig(gitDir string) map[string]string {
+	data, err := os.ReadFile(filepath.Join(gitDir, worktreeConfigFileName))
+	if err != nil {
+		return nil
+	}
+	cfg := config.New()
+	if err := config.NewDecoder(bytes.NewReader(data)).Decode(cfg); err != nil {
+		return nil
+	}
+
+	values := make(map[string]string)
+	for _, section := range cfg.Sections {
+		for _, option := range section.Options {
+			values[strings.ToLower(section.Name)+"."+strings.ToLower(option.Key)] = option.Value
+		}
+		for _, sub := range section.Subsections {
+			for _, option := range sub.Options {
+				values[strings.ToLower(section.Name)+"."+sub.Name+"."+strings.ToLower(option.Key)] = option.Value
+			}
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
@@ -0,0 +1,176 @@
+package cowgit
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gitConfigValue returns git config key as seen from dir
+func gitConfigValue(t *testing.T, dir string, key string) string {
+	t.Helper()
+	output, _ := exec.Command("git", "-C", dir, "config", "--get", key).Output()
+	return strings.TrimSpace(string(output))
+}
+
+func TestCreateWithWorktreeConfig(t *testing.T) {
+	tempDir := t.TempDir()
+	repoDir := filepath.Join(tempDir, "repo")
+	setupCompatRepo(t, repoDir)
+	sourceEmail := gitConfigValue(t, repoDir, "user.email")
+
+	manager, err := NewManager(repoDir)
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	agent := map[string]string{"user.email": "agent@example.com", "core.hooksPath": "/opt/agent-hooks"}
+	for _, noCoW := range []bool{false, true} {
+		branch := fmt.Sprintf("agent-nocow-%t", noCoW)
+		worktree, err := manager.Create(CreateOptions{
+			BranchName:   branch,
+			WorktreePath: filepath.Join(tempDir, branch),
+			NoCoW:        noCoW,
+			Backend:      BackendCopy,
+			Fallback:     FallbackCopy,
+			Config:       agent,
+		})
+		if err != nil {
+			t.Fatalf("Create(NoCoW=%t) failed: %v", noCoW, err)
+		}
+		if email := gitConfigValue(t, worktree.WorktreePath, "user.email"); email != "agent@example.com" {
+			t.Errorf("NoCoW=%t: worktree user.email = %q", noCoW, email)
+		}
+		if hooks := gitConfigValue(t, worktree.WorktreePath, "core.hooksPath"); hooks != "/opt/agent-hooks" {
+			t.Errorf("NoCoW=%t: worktree core.hooksPath = %q", noCoW, hooks)
+		}
+		if err := manager.Remove(branch, false); err != nil {
+			t.Fatalf("Remove failed: %v", err)
+		}
+	}
+
+	worktree, err := manager.Create(CreateOptions{BranchName: "agent", WorktreePath: filepath.Join(tempDir, "agent"), Backend: BackendCopy, Fallback: FallbackCopy, Config: agent})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	plain, err := manager.Create(CreateOptions{BranchName: "plain", WorktreePath: filepath.Join(tempDir, "plain"), Backend: BackendCopy, Fallback: FallbackCopy})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	// Other worktrees keep the shared settings
+	for _, dir := range []string{repoDir, plain.WorktreePath} {
+		if email := gitConfigValue(t, dir, "user.email"); email != sourceEmail {
+			t.Errorf("%s: user.email = %q, want the shared %q", dir, email, sourceEmail)
+		}
+		if hooks := gitConfigValue(t, dir, "core.hooksPath"); hooks != "" {
+			t.Errorf("%s: core.hooksPath = %q, want it unset", dir, hooks)
+		}
+	}
+
+	infos, err := ListWorktrees(repoDir)
+	if err != nil {
+		t.Fatalf("ListWorktrees failed: %v", err)
+	}
+	for _, info := range infos {
+		want := 0
+		if info.Path == worktree.WorktreePath {
+			want = 2
+			if info.Config["user.email"] != "agent@example.com" || info.Config["core.hookspath"] != "/opt/agent-hooks" {
+				t.Errorf("ListWorktrees config for %s = %v", info.Path, info.Config)
+			}
+		}
+		if len(info.Config) != want {
+			t.Errorf("ListWorktrees config for %s = %v, want %d settings", info.Path, info.Config, want)
+		}
+	}
+
+	// Invalid keys are refused before anything is created
+	_, err = manager.Create(CreateOptions{BranchName: "bad", WorktreePath: filepath.Join(tempDir, "bad"), Backend: BackendCopy, Fallback: FallbackCopy, Config: map[string]string{"email": "x"}})
+	if err == nil {
+		t.Error("Create with an invalid config key succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "bad")); !os.IsNotExist(err) {
+		t.Error("worktree created despite an invalid config key")
+	}
+}
+
+func TestWorktreeConfigMovesCoreWorktree(t *testing.T) {
+	tempDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to resolve temp dir: %v", err)
+	}
+
+	// A git directory that finds its work tree through core.worktree
+	gitDir := filepath.Join(tempDir, "dots.git")
+	home := filepath.Join(tempDir, "home")
+	if err := os.MkdirAll(home, 0755); err != nil {
+		t.Fatalf("Failed to create work tree: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".rc"), []byte("rc"), 0644); err != nil {
+		t.Fatalf("Failed to write .rc: %v", err)
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"config", "core.bare", "false"},
+		{"config", "core.worktree", home},
+		{"config", "user.email", "test@example.com"},
+		{"config", "user.name", "Test User"},
+		{"add", ".rc"},
+		{"commit", "-q", "-m", "rc"},
+	} {
+		if output, err := exec.Command("git", append([]string{"--git-dir=" + gitDir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, output)
+		}
+	}
+
+	t.Setenv("GIT_DIR", gitDir)
+	worktree := NewWorktreeWithOptions(home, filepath.Join(tempDir, "wt"), "configured", true)
+	worktree.Backend = BackendCopy
+	worktree.Fallback = FallbackCopy
+	worktree.Config = map[string]string{"user.name": "Agent"}
+	err = worktree.CreateCoWWorktree()
+	os.Unsetenv("GIT_DIR")
+	if err != nil {
+		t.Fatalf("CreateCoWWorktree failed: %v", err)
+	}
+
+	// core.worktree now belongs to the main worktree alone; shared, it would
+	// point the new worktree back at home
+	if top := revParse(t, worktree.WorktreePath, "--show-toplevel"); top != worktree.WorktreePath {
+		t.Errorf("new worktree toplevel = %s", top)
+	}
+	output, err := exec.Command("git", "--git-dir="+gitDir, "rev-parse", "--show-toplevel").CombinedOutput()
+	if err != nil || strings.TrimSpace(string(output)) != home {
+		t.Errorf("main worktree toplevel = %s, %v; want %s", output, err, home)
+	}
+	if name := gitConfigValue(t, worktree.WorktreePath, "user.name"); name != "Agent" {
+		t.Errorf("new worktree user.name = %q", name)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"user.email", true},
+		{"core.hooksPath", true},
+		{"remote.origin.url", true},
+		{"url.https://example.com/.insteadOf", true},
+		{"email", false},
+		{".email", false},
+		{"user.", false},
+		{"user.1email", false},
+		{"us_er.email", false},
+		{"user.e mail", false},
+	}
+	for _, tt := range tests {
+		err := ValidateConfig(map[string]string{tt.key: "value"})
+		if (err == nil) != tt.valid {
+			t.Errorf("ValidateConfig(%q) = %v, want valid=%t", tt.key, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package cowgit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// lockedFileName marks a worktree's git directory as locked against git worktree prune
+const lockedFileName = "locked"
+
+// sanitizeWorktreeName turns name into a single valid ref component, the way
+// git worktree add sanitizes the basename it uses as the worktree id: forbidden
+// characters become '-', ".." collapses to ".", a leading '.' becomes '-' and
+// trailing ".lock" suffixes are removed
+func sanitizeWorktreeName(name string) string {
+	var sanitized []byte
+	var last byte
+	for i := 0; i < len(name); i++ {
+		ch := name[i]
+		switch {
+		case ch == '.' && last == '.':
+			// Collapse ".." to a single "."
+		case ch == '{' && last == '@':
+			sanitized = append(sanitized, '-')
+		case ch < 0x20 || ch == 0x7f || strings.IndexByte(" ~^:?[\\*/", ch) >= 0:
+			sanitized = append(sanitized, '-')
+		default:
+			sanitized = append(sanitized, ch)
+		}
+		last = ch
+	}
+
+	if len(sanitized) > 0 && sanitized[0] == '.' {
+		sanitized[0] = '-'
+	}
+	result := string(sanitized)
+	for strings.HasSuffix(result, ".lock") {
+		result = strings.TrimSuffix(result, ".lock")
+	}
+	if result == "" {
+		result = "worktree"
+	}
+	return result
+}
+
+// allocateWorktreeID creates a new directory for a worktree under the common
+// git dir's worktrees directory and returns its id and path. Like git, the id
+// is the sanitized name, followed by 1, 2, ... when that is already taken.
+func allocateWorktreeID(commonDir, name string) (string, string, error) {
+	worktreesDir := filepath.Join(commonDir, "worktrees")
+	if err := os.MkdirAll(worktreesDir, 0755); err != nil {
+		return "", "", fmt.Errorf("failed to create worktrees directory: %w", err)
+	}
+
+	base := sanitizeWorktreeName(name)
+	id := base
+	for counter := 1; ; counter++ {
+		dir := filepath.Join(worktreesDir, id)
+		err := os.Mkdir(dir, 0755)
+		if err == nil {
+			return id, dir, nil
+		}
+		if !os.IsExist(err) {
+			return "", "", fmt.Errorf("failed to create worktree metadata directory: %w", err)
+		}
+		id = base + strconv.Itoa(counter)
+	}
+}
+
+// nextWorktreeID returns the id allocateWorktreeID would allocate for name
+// now, without creating anything
+func nextWorktreeID(commonDir, name string) string {
+	base := sanitizeWorktreeName(name)
+	id := base
+	for counter := 1; ; counter++ {
+		if _, err := os.Lstat(filepath.Join(commonDir, "worktrees", id)); err != nil {
+			return id
+		}
+		id = base + strconv.Itoa(counter)
+	}
+}
+
+// worktreeIDForPath returns the id of the linked worktree at path, following
+// its .git file or, if that is missing, searching the gitdir files in commonDir.
+// The main worktree has no id.
+func worktreeIDForPath(commonDir, path string) string {
+	if gitDir, err := resolveWorktreeGitDir(path); err == nil {
+		return filepath.Base(gitDir)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(commonDir, "worktrees"))
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(commonDir, "worktrees", entry.Name(), "gitdir"))
+		if err == nil && filepath.Dir(strings.TrimSpace(string(content))) == path {
+			return entry.Name()
+		}
+	}
+	return ""
+}
+
+// unlockRegistration removes the lock that protects a new worktree while it is set up
+func (w *Worktree) unlockRegistration() error {
+	if err := os.Remove(filepath.Join(w.registeredGitDir, lockedFileName)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to unlock worktree: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package cowgit
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeWorktreeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"feature", "feature"},
+		{"feature/foo", "feature-foo"},
+		{"with space", "with-space"},
+		{"a:b?c[d]e\\f^g~h*i", "a-b-c-d]e-f-g-h-i"},
+		{"dots..here", "dots.here"},
+		{".hidden", "-hidden"},
+		{"name.lock", "name"},
+		{"name.lock.lock", "name"},
+		{"at@{brace", "at@-brace"},
+		{"tab\there", "tab-here"},
+		{".lock", "-lock"},
+		{"", "worktree"},
+	}
+
+	for _, tt := range tests {
+		if got := sanitizeWorktreeName(tt.name); got != tt.want {
+			t.Errorf("sanitizeWorktreeName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWorktreeIDAllocation(t *testing.T) {
+	tempDir := t.TempDir()
+	repoDir := filepath.Join(tempDir, "repo")
+	if err := os.MkdirAll(repoDir, 0755); err != nil {
+		t.Fatalf("Failed to create repo dir: %v", err)
+	}
+	setupGitRepo(t, repoDir)
+
+	create := func(path, branch string) *Worktree {
+		t.Helper()
+		worktree := NewWorktreeWithOptions(repoDir, path, branch, true)
+		worktree.Backend = BackendCopy
+		worktree.Fallback = FallbackCopy
+		if err := worktree.CreateCoWWorktree(); err != nil {
+			t.Fatalf("Failed to create worktree at %s: %v", path, err)
+		}
+		return worktree
+	}
+
+	// A nested branch name no longer nests the metadata directory
+	first := create(filepath.Join(tempDir, "a", "dup"), "feature/foo")
+	if first.ID != "dup" {
+		t.Errorf("first ID = %q, want dup", first.ID)
+	}
+
+	// git worktree add continues the same numbering
+	if output, err := exec.Command("git", "-C", repoDir, "worktree", "add", "-q", "-b", "plain", filepath.Join(tempDir, "b", "dup")).CombinedOutput(); err != nil {
+		t.Fatalf("git worktree add failed: %v: %s", err, output)
+	}
+	if _, err := os.Stat(filepath.Join(repoDir, ".git", "worktrees", "dup1")); err != nil {
+		t.Fatalf("git did not allocate dup1: %v", err)
+	}
+
+	third := create(filepath.Join(tempDir, "c", "dup"), "other/foo")
+	if third.ID != "dup2" {
+		t.Errorf("third ID = %q, want dup2", third.ID)
+	}
+
+	for _, worktree := range []*Worktree{first, third} {
+		gitDir := filepath.Join(repoDir, ".git", "worktrees", worktree.ID)
+		if _, err := os.Stat(filepath.Join(gitDir, lockedFileName)); !os.IsNotExist(err) {
+			t.Errorf("%s is still locked after creation", worktree.ID)
+		}
+		content, err := os.ReadFile(filepath.Join(gitDir, "gitdir"))
+		if err != nil {
+			t.Fatalf("Failed to read gitdir: %v", err)
+		}
+		if want := filepath.Join(worktree.WorktreePath, ".git"); strings.TrimSpace(string(content)) != want {
+			t.Errorf("gitdir = %q, want %q", strings.TrimSpace(string(content)), want)
+		}
+	}
+
+	// Prune must keep every entry, and the ids show up in the listing
+	if output, err := exec.Command("git", "-C", repoDir, "worktree", "prune").CombinedOutput(); err != nil {
+		t.Fatalf("git worktree prune failed: %v: %s", err, output)
+	}
+	infos, err := ListWorktrees(repoDir)
+	if err != nil {
+		t.Fatalf("ListWorktrees failed: %v", err)
+	}
+	ids := map[string]string{}
+	for _, info := range infos {
+		ids[info.Path] = info.ID
+	}
+	want := map[string]string{
+		repoDir:                            "",
+		first.WorktreePath:                 "dup",
+		filepath.Join(tempDir, "b", "dup"): "dup1",
+		third.WorktreePath:                 "dup2",
+	}
+	for path, id := range want {
+		if got, ok := ids[path]; !ok || got != id {
+			t.Errorf("worktree %s: ID = %q (listed %v), want %q", path, got, ok, id)
+		}
+	}
+
+	// Branch checkouts still resolve through the allocated ids
+	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
+	cmd.Dir = first.WorktreePath
+	if output, err := cmd.Output(); err != nil || strings.TrimSpace(string(output)) != "feature/foo" {
+		t.Errorf("first worktree HEAD = %q, %v; want feature/foo", output, err)
+	}
+}